@@ -1,13 +1,17 @@
 package gui
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/CertStone/simpleKcpFileManager/common"
 	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
+	"github.com/CertStone/simpleKcpFileManager/kcpclient/tasks"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -52,6 +56,12 @@ func (cm *ContextMenu) ShowFileListMenu(file *kcpclient.ListItem, pos fyne.Posit
 			fyne.NewMenuItem("Delete", func() {
 				cm.showDeleteDialog(file)
 			}),
+			fyne.NewMenuItem("Create Symlink...", func() {
+				cm.showCreateLinkDialog(file, false)
+			}),
+			fyne.NewMenuItem("Disk Usage...", func() {
+				NewDiskUsageView(cm.mainWindow, file.Path).Show()
+			}),
 			fyne.NewMenuItemSeparator(),
 			fyne.NewMenuItem("Compress (ZIP)", func() {
 				cm.compressItem(file, "zip")
@@ -76,9 +86,26 @@ func (cm *ContextMenu) ShowFileListMenu(file *kcpclient.ListItem, pos fyne.Posit
 			fyne.NewMenuItem("Delete", func() {
 				cm.showDeleteDialog(file)
 			}),
-			fyne.NewMenuItemSeparator(),
+			fyne.NewMenuItem("Create Symlink...", func() {
+				cm.showCreateLinkDialog(file, false)
+			}),
 		)
 
+		// Hard links only make sense for regular files
+		if !file.IsSymlink {
+			items = append(items, fyne.NewMenuItem("Create Hard Link...", func() {
+				cm.showCreateLinkDialog(file, true)
+			}))
+		}
+
+		// Symlinks to folders can be opened like folders
+		if file.LinkIsDir {
+			items = append(items, fyne.NewMenuItem("Open", func() {
+				cm.mainWindow.navigateToPath(strings.TrimPrefix(file.Path, "/"))
+			}))
+		}
+		items = append(items, fyne.NewMenuItemSeparator())
+
 		// Check if file is an archive
 		if cm.isArchive(file.Name) {
 			items = append(items, fyne.NewMenuItem("Extract", func() {
@@ -88,6 +115,9 @@ func (cm *ContextMenu) ShowFileListMenu(file *kcpclient.ListItem, pos fyne.Posit
 			items = append(items, fyne.NewMenuItem("Edit", func() {
 				cm.editFile(file)
 			}))
+			items = append(items, fyne.NewMenuItem("View Log", func() {
+				NewLogViewer(cm.mainWindow, file).Show()
+			}))
 		}
 
 		// Add compress option for regular files too
@@ -128,6 +158,9 @@ func (cm *ContextMenu) ShowFileListMenu(file *kcpclient.ListItem, pos fyne.Posit
 	items = append(items, fyne.NewMenuItem("Refresh", func() {
 		cm.mainWindow.refreshFileList()
 	}))
+	items = append(items, fyne.NewMenuItem("Properties...", func() {
+		NewPropertiesDialog(cm.mainWindow, file).Show()
+	}))
 
 	menu := fyne.NewMenu("File Options", items...)
 	popUpMenu := widget.NewPopUpMenu(menu, cm.mainWindow.window.Canvas())
@@ -165,6 +198,9 @@ func (cm *ContextMenu) ShowBackgroundMenu(pos fyne.Position) {
 		items = append(items, fyne.NewMenuItemSeparator())
 	}
 
+	items = append(items, fyne.NewMenuItem("Disk Usage...", func() {
+		NewDiskUsageView(cm.mainWindow, "/"+cm.mainWindow.currentPath).Show()
+	}))
 	items = append(items, fyne.NewMenuItem("Refresh", func() {
 		cm.mainWindow.refreshFileList()
 	}))
@@ -174,6 +210,53 @@ func (cm *ContextMenu) ShowBackgroundMenu(pos fyne.Position) {
 	popUpMenu.ShowAtPosition(pos)
 }
 
+// ShowTaskMenu shows the context menu of a task in the task queue
+func (cm *ContextMenu) ShowTaskMenu(task *tasks.Task, pos fyne.Position) {
+	if task == nil || task.Limiter == nil {
+		return // Only transfers can be limited
+	}
+
+	limitItem := fyne.NewMenuItem("Limit Speed...", func() {
+		cm.showTaskLimitDialog(task)
+	})
+	removeItem := fyne.NewMenuItem("Remove Speed Limit", func() {
+		cm.mainWindow.taskManager.SetTaskRateLimit(task.ID, 0)
+	})
+	removeItem.Disabled = task.Limiter.Limit() == 0
+
+	menu := fyne.NewMenu("Task Options", limitItem, removeItem)
+	popUpMenu := widget.NewPopUpMenu(menu, cm.mainWindow.window.Canvas())
+	popUpMenu.ShowAtPosition(pos)
+}
+
+// showTaskLimitDialog asks for the speed limit of a single task
+func (cm *ContextMenu) showTaskLimitDialog(task *tasks.Task) {
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("0")
+	if limit := task.Limiter.Limit(); limit > 0 {
+		entry.SetText(strconv.FormatInt(limit/1024, 10))
+	}
+
+	content := container.NewVBox(
+		widget.NewLabel("Maximum speed of this task in KB/s (0 = unlimited):"),
+		entry,
+	)
+
+	dialog.ShowCustomConfirm("Limit Speed", "Apply", "Cancel", content, func(confirmed bool) {
+		if !confirmed {
+			return
+		}
+		limit, err := parseRateLimit(entry.Text)
+		if err != nil {
+			dialog.ShowError(err, cm.mainWindow.window)
+			return
+		}
+		if err := cm.mainWindow.taskManager.SetTaskRateLimit(task.ID, limit); err != nil {
+			dialog.ShowError(err, cm.mainWindow.window)
+		}
+	}, cm.mainWindow.window)
+}
+
 // downloadFile downloads a single file
 func (cm *ContextMenu) downloadFile(file *kcpclient.ListItem) {
 	// Use default download directory
@@ -194,43 +277,21 @@ func (cm *ContextMenu) downloadFile(file *kcpclient.ListItem) {
 	}
 }
 
-// downloadFolder downloads a folder recursively
+// downloadFolder downloads a folder recursively as a single task
 func (cm *ContextMenu) downloadFolder(file *kcpclient.ListItem) {
 	// Use default download directory
 	saveDir := cm.mainWindow.saveDir + "/" + file.Name
 
-	// List all files in the folder recursively
-	go func() {
-		files, err := cm.mainWindow.client.ListFiles(file.Path, true)
-		if err != nil {
-			dialog.ShowError(err, cm.mainWindow.window)
-			return
-		}
-
-		log.Printf("[DEBUG] DownloadFolder: Found %d files", len(files))
-
-		// Queue all files for download
-		for _, f := range files {
-			if !f.IsDir {
-				remotePath := f.Path
-				relativePath := strings.TrimPrefix(f.Path, file.Path)
-				localPath := saveDir + relativePath
-
-				log.Printf("[DEBUG] DownloadFolder: Queuing %s -> %s", remotePath, localPath)
-
-				// Add download task
-				if err := cm.mainWindow.taskQueue.AddDownloadTask(remotePath, localPath); err != nil {
-					log.Printf("[DEBUG] DownloadFolder: Error queueing task - %v", err)
-					dialog.ShowError(err, cm.mainWindow.window)
-					return
-				}
-			}
-		}
+	log.Printf("[DEBUG] DownloadFolder: Queuing %s -> %s", file.Path, saveDir)
+	if err := cm.mainWindow.taskQueue.AddDownloadFolderTask(file.Path, saveDir); err != nil {
+		log.Printf("[DEBUG] DownloadFolder: Error queueing task - %v", err)
+		dialog.ShowError(err, cm.mainWindow.window)
+		return
+	}
 
-		dialog.ShowInformation("Download Started",
-			fmt.Sprintf("Downloading %d files from '%s' to:\n%s", len(files), file.Name, saveDir),
-			cm.mainWindow.window)
-	}()
+	dialog.ShowInformation("Download Started",
+		fmt.Sprintf("Downloading folder '%s' to:\n%s", file.Name, saveDir),
+		cm.mainWindow.window)
 }
 
 // showRenameDialog shows the rename dialog
@@ -258,15 +319,66 @@ func (cm *ContextMenu) showRenameDialog(file *kcpclient.ListItem) {
 			newPath = newPath + "/" + newName
 		}
 
-		// Rename on server
-		err := cm.mainWindow.client.RenameFile(oldPath, newPath)
+		// Rename on server, asking before replacing an existing entry
+		var rename func(policy common.ConflictPolicy)
+		rename = func(policy common.ConflictPolicy) {
+			_, err := cm.mainWindow.client.RenameFileWithPolicy(oldPath, newPath, policy)
+			if err != nil {
+				cm.handleConflict(err, policy, rename)
+				return
+			}
+
+			// Clear selection and refresh
+			cm.mainWindow.selectedFile = nil
+			cm.mainWindow.refreshFileList()
+			cm.mainWindow.directoryTree.Refresh()
+		}
+		rename(common.ConflictFail)
+	}, cm.mainWindow.window)
+}
+
+// showCreateLinkDialog asks for a link name and creates a symlink or hard link
+// to file in the current directory
+func (cm *ContextMenu) showCreateLinkDialog(file *kcpclient.ListItem, hard bool) {
+	title := "Create Symlink"
+	if hard {
+		title = "Create Hard Link"
+	}
+
+	entry := widget.NewEntry()
+	entry.SetPlaceHolder("Link name")
+	entry.SetText(file.Name + ".link")
+
+	content := container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("Create a link to '%s' named:", file.Name)),
+		entry,
+	)
+
+	dialog.ShowCustomConfirm(title, "Create", "Cancel", content, func(confirmed bool) {
+		if !confirmed || entry.Text == "" {
+			return
+		}
+
+		var linkPath string
+		if cm.mainWindow.currentPath == "" {
+			linkPath = "/" + entry.Text
+		} else {
+			linkPath = "/" + cm.mainWindow.currentPath + "/" + entry.Text
+		}
+
+		// Absolute server paths are stored as relative links by the server
+		var err error
+		if hard {
+			err = cm.mainWindow.client.Hardlink(file.Path, linkPath)
+		} else {
+			err = cm.mainWindow.client.Symlink(file.Path, linkPath)
+		}
 		if err != nil {
 			dialog.ShowError(err, cm.mainWindow.window)
 			return
 		}
 
-		// Clear selection and refresh
-		cm.mainWindow.selectedFile = nil
+		// Refresh
 		cm.mainWindow.refreshFileList()
 		cm.mainWindow.directoryTree.Refresh()
 	}, cm.mainWindow.window)
@@ -290,9 +402,6 @@ func (cm *ContextMenu) pasteFile() {
 		dstPath = "/" + cm.mainWindow.currentPath + "/" + srcName
 	}
 
-	// Check if destination already exists (optional: ask for overwrite or rename)
-	// For simplicity, we'll just proceed and let server handle conflicts
-
 	actionName := "Copy"
 	if cm.mainWindow.clipboardIsCut {
 		actionName = "Move"
@@ -305,33 +414,97 @@ func (cm *ContextMenu) pasteFile() {
 			return
 		}
 
-		var err error
-		if cm.mainWindow.clipboardIsCut {
-			// Move operation
-			err = cm.mainWindow.client.MoveFile(srcPath, dstPath)
-			if err == nil {
-				// Clear clipboard after successful move
-				cm.mainWindow.clipboardPath = ""
-				cm.mainWindow.clipboardIsCut = false
+		// Try without touching existing entries first; on a conflict the
+		// user picks a policy and the operation is retried with it
+		var paste func(policy common.ConflictPolicy)
+		paste = func(policy common.ConflictPolicy) {
+			var result *kcpclient.ConflictResult
+			var err error
+			if cm.mainWindow.clipboardIsCut {
+				// Move operation
+				result, err = cm.mainWindow.client.MoveFileWithPolicy(srcPath, dstPath, policy)
+				if err == nil && !result.Skipped() {
+					// Clear clipboard after successful move
+					cm.mainWindow.clipboardPath = ""
+					cm.mainWindow.clipboardIsCut = false
+				}
+			} else {
+				// Copy operation
+				result, err = cm.mainWindow.client.CopyFileWithPolicy(srcPath, dstPath, policy)
 			}
-		} else {
-			// Copy operation
-			err = cm.mainWindow.client.CopyFile(srcPath, dstPath)
-		}
 
-		if err != nil {
-			dialog.ShowError(err, cm.mainWindow.window)
-			return
-		}
+			if err != nil {
+				cm.handleConflict(err, policy, paste)
+				return
+			}
 
-		dialog.ShowInformation("Success", fmt.Sprintf("%s completed successfully", actionName), cm.mainWindow.window)
+			switch result.Action {
+			case common.ConflictResultSkipped:
+				dialog.ShowInformation(actionName, fmt.Sprintf("'%s' was skipped", srcName), cm.mainWindow.window)
+			case common.ConflictResultRenamed:
+				dialog.ShowInformation("Success", fmt.Sprintf("%s completed as '%s'", actionName, path.Base(result.Path)), cm.mainWindow.window)
+			default:
+				dialog.ShowInformation("Success", fmt.Sprintf("%s completed successfully", actionName), cm.mainWindow.window)
+			}
 
-		// Refresh
-		cm.mainWindow.refreshFileList()
-		cm.mainWindow.directoryTree.Refresh()
+			// Refresh
+			cm.mainWindow.refreshFileList()
+			cm.mainWindow.directoryTree.Refresh()
+		}
+		paste(common.ConflictFail)
 	}, cm.mainWindow.window)
 }
 
+// handleConflict shows err. If it is a conflict raised under ConflictFail the
+// user is asked how to resolve it and retry is called with the chosen policy.
+func (cm *ContextMenu) handleConflict(err error, policy common.ConflictPolicy, retry func(policy common.ConflictPolicy)) {
+	var conflict *kcpclient.ConflictError
+	if policy != common.ConflictFail || !errors.As(err, &conflict) {
+		dialog.ShowError(err, cm.mainWindow.window)
+		return
+	}
+	cm.showConflictDialog(conflict, retry)
+}
+
+// showConflictDialog describes an existing destination and calls resolve
+// with the policy picked by the user. Nothing is called on cancel.
+func (cm *ContextMenu) showConflictDialog(conflict *kcpclient.ConflictError, resolve func(policy common.ConflictPolicy)) {
+	kind, overwrite := "A file", "Overwrite"
+	if conflict.IsDir {
+		kind, overwrite = "A folder", "Merge"
+	}
+
+	msg := fmt.Sprintf("%s named '%s' already exists.\n\nExisting: %s, modified %s",
+		kind, path.Base(conflict.Path), formatSize(conflict.Size), formatTime(conflict.ModTime))
+	if conflict.SrcModTime != 0 {
+		msg += fmt.Sprintf("\nSource: %s, modified %s", formatSize(conflict.SrcSize), formatTime(conflict.SrcModTime))
+	}
+
+	options := []struct {
+		label  string
+		policy common.ConflictPolicy
+	}{
+		{overwrite, common.ConflictOverwrite},
+		{overwrite + " if Newer", common.ConflictNewer},
+		{"Keep Both", common.ConflictRename},
+		{"Skip", common.ConflictSkip},
+	}
+
+	var d dialog.Dialog
+	buttons := container.NewHBox()
+	for _, opt := range options {
+		policy := opt.policy
+		buttons.Add(widget.NewButton(opt.label, func() {
+			d.Hide()
+			resolve(policy)
+		}))
+	}
+
+	content := container.NewVBox(widget.NewLabel(msg), buttons)
+	d = dialog.NewCustom("Conflict", "Cancel", content, cm.mainWindow.window)
+	d.Show()
+}
+
 // showDeleteDialog shows the delete confirmation dialog
 func (cm *ContextMenu) showDeleteDialog(file *kcpclient.ListItem) {
 	var msg string
@@ -425,8 +598,13 @@ func (cm *ContextMenu) showNewFileDialog() {
 			newPath = "/" + cm.mainWindow.currentPath + "/" + fileName
 		}
 
-		// Save file content (can be empty for binary files)
-		err := cm.mainWindow.client.SaveFile(newPath, contentEntry.Text)
+		// Save file content (can be empty for binary files); never replace an existing file
+		_, err := cm.mainWindow.client.SaveFileIf(newPath, contentEntry.Text, kcpclient.IfNotExists())
+		var exists *kcpclient.PreconditionFailedError
+		if errors.As(err, &exists) {
+			dialog.ShowError(fmt.Errorf("file '%s' already exists", fileName), cm.mainWindow.window)
+			return
+		}
 		if err != nil {
 			dialog.ShowError(err, cm.mainWindow.window)
 			return
@@ -483,7 +661,7 @@ func (cm *ContextMenu) editFile(file *kcpclient.ListItem) {
 	// Check file size (1MB limit for editor as per documentation)
 	const maxSize = 1 * 1024 * 1024
 	if file.Size > maxSize {
-		dialog.ShowError(fmt.Errorf("file too large for editing (>%d MB)", maxSize/(1024*1024)), cm.mainWindow.window)
+		dialog.ShowError(fmt.Errorf("file too large for editing (>%d MB), use View Log to read its end", maxSize/(1024*1024)), cm.mainWindow.window)
 		return
 	}
 
@@ -535,24 +713,28 @@ func (cm *ContextMenu) extractArchive(file *kcpclient.ListItem) {
 			destPath = "/" + cm.mainWindow.currentPath + "/" + destName
 		}
 
-		// Extract in background
-		go func() {
-			err := cm.mainWindow.client.Extract(file.Path, destPath)
-			if err != nil {
+		// Extract in background, asking before overwriting existing files
+		var extract func(policy common.ConflictPolicy)
+		extract = func(policy common.ConflictPolicy) {
+			go func() {
+				err := cm.mainWindow.client.ExtractWithPolicy(file.Path, destPath, policy)
+				if err != nil {
+					fyne.Do(func() {
+						cm.handleConflict(err, policy, extract)
+					})
+					return
+				}
+
+				// Refresh
 				fyne.Do(func() {
-					dialog.ShowError(err, cm.mainWindow.window)
+					dialog.ShowInformation("Extract",
+						fmt.Sprintf("Successfully extracted to %s", destPath),
+						cm.mainWindow.window)
+					cm.mainWindow.refreshFileList()
+					cm.mainWindow.directoryTree.Refresh()
 				})
-				return
-			}
-
-			// Refresh
-			fyne.Do(func() {
-				dialog.ShowInformation("Extract",
-					fmt.Sprintf("Successfully extracted to %s", destPath),
-					cm.mainWindow.window)
-				cm.mainWindow.refreshFileList()
-				cm.mainWindow.directoryTree.Refresh()
-			})
-		}()
+			}()
+		}
+		extract(common.ConflictFail)
 	}, cm.mainWindow.window)
 }
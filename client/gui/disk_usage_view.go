@@ -0,0 +1,149 @@
+package gui
+
+import (
+	"fmt"
+	"path"
+
+	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+// duTopEntries is how many of the largest entries the breakdown lists
+const duTopEntries = 50
+
+// DiskUsageView shows which entries of a folder use the most space.
+// Selecting a folder drills down into it.
+type DiskUsageView struct {
+	mainWindow  *MainWindow
+	rootPath    string // Folder the view was opened on; Up stops here
+	usage       *kcpclient.DiskUsage
+	pathLabel   *widget.Label
+	statusLabel *widget.Label
+	list        *widget.List
+	upBtn       *widget.Button
+	loading     bool
+}
+
+// NewDiskUsageView creates a breakdown view for remotePath ("/dir")
+func NewDiskUsageView(mainWindow *MainWindow, remotePath string) *DiskUsageView {
+	return &DiskUsageView{
+		mainWindow: mainWindow,
+		rootPath:   remotePath,
+	}
+}
+
+// Show displays the view and starts loading
+func (dv *DiskUsageView) Show() {
+	dv.pathLabel = widget.NewLabel(dv.rootPath)
+	dv.statusLabel = widget.NewLabel("")
+
+	dv.list = widget.NewList(
+		func() int {
+			if dv.usage == nil {
+				return 0
+			}
+			return len(dv.usage.Entries)
+		},
+		func() fyne.CanvasObject {
+			bar := widget.NewProgressBar()
+			return container.NewBorder(nil, nil,
+				container.NewHBox(widget.NewIcon(nil), widget.NewLabel("")),
+				widget.NewLabel(""),
+				bar,
+			)
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			row := o.(*fyne.Container)
+			bar := row.Objects[0].(*widget.ProgressBar)
+			left := row.Objects[1].(*fyne.Container)
+			icon := left.Objects[0].(*widget.Icon)
+			name := left.Objects[1].(*widget.Label)
+			count := row.Objects[2].(*widget.Label)
+
+			entry := dv.usage.Entries[i]
+			if entry.IsDir {
+				icon.SetResource(theme.FolderIcon())
+				count.SetText(fmt.Sprintf("%d files", entry.Files))
+			} else {
+				icon.SetResource(theme.FileIcon())
+				count.SetText("")
+			}
+			name.SetText(entry.Name)
+
+			size := formatSize(entry.Size)
+			bar.TextFormatter = func() string { return size }
+			if dv.usage.Size > 0 {
+				bar.SetValue(float64(entry.Size) / float64(dv.usage.Size))
+			} else {
+				bar.SetValue(0)
+			}
+		},
+	)
+	dv.list.OnSelected = func(id widget.ListItemID) {
+		dv.list.UnselectAll()
+		if entry := dv.usage.Entries[id]; entry.IsDir && !dv.loading {
+			dv.load(entry.Path, false)
+		}
+	}
+
+	dv.upBtn = widget.NewButtonWithIcon("Up", theme.MoveUpIcon(), func() {
+		if dv.usage != nil && dv.usage.Path != dv.rootPath && !dv.loading {
+			dv.load(path.Dir(dv.usage.Path), false)
+		}
+	})
+	rescanBtn := widget.NewButtonWithIcon("Rescan", theme.ViewRefreshIcon(), func() {
+		if dv.usage != nil && !dv.loading {
+			dv.load(dv.usage.Path, true)
+		}
+	})
+
+	top := container.NewVBox(
+		container.NewBorder(nil, nil, dv.upBtn, rescanBtn, dv.pathLabel),
+		dv.statusLabel,
+	)
+	content := container.NewBorder(top, nil, nil, nil, dv.list)
+
+	d := dialog.NewCustom("Disk Usage", "Close", content, dv.mainWindow.window)
+	d.Resize(fyne.NewSize(640, 480))
+	d.Show()
+
+	dv.load(dv.rootPath, false)
+}
+
+// load fetches the breakdown of remotePath in the background
+func (dv *DiskUsageView) load(remotePath string, refresh bool) {
+	dv.loading = true
+	dv.pathLabel.SetText(remotePath)
+	dv.statusLabel.SetText("Scanning...")
+
+	go func() {
+		usage, err := dv.mainWindow.client.DiskUsage(remotePath, duTopEntries, refresh, func(scanned int64) {
+			fyne.Do(func() {
+				dv.statusLabel.SetText(fmt.Sprintf("Scanning... %d entries", scanned))
+			})
+		})
+
+		fyne.Do(func() {
+			dv.loading = false
+			if err != nil {
+				dv.statusLabel.SetText("Scan failed: " + err.Error())
+				return
+			}
+			dv.usage = usage
+			dv.statusLabel.SetText(fmt.Sprintf("%s in %d files, %d folders",
+				formatSize(usage.Size), usage.Files, usage.Dirs))
+			if usage.Path == dv.rootPath {
+				dv.upBtn.Disable()
+			} else {
+				dv.upBtn.Enable()
+			}
+			dv.list.Refresh()
+			dv.list.ScrollToTop()
+		})
+	}()
+}
@@ -342,7 +342,7 @@ func (dd *DragDropHandler) downloadFile(file *kcpclient.ListItem) {
 	}, dd.mainWindow.window)
 }
 
-// downloadFolder downloads a folder recursively
+// downloadFolder downloads a folder recursively as a single task
 func (dd *DragDropHandler) downloadFolder(file *kcpclient.ListItem) {
 	dialog.ShowFolderOpen(func(uri fyne.ListableURI, err error) {
 		if err != nil || uri == nil {
@@ -351,37 +351,15 @@ func (dd *DragDropHandler) downloadFolder(file *kcpclient.ListItem) {
 
 		saveDir := uri.Path()
 
-		// List all files in the folder recursively
-		go func() {
-			files, err := dd.mainWindow.client.ListFiles(file.Path, true)
-			if err != nil {
-				dialog.ShowError(err, dd.mainWindow.window)
-				return
-			}
-
-			log.Printf("[DEBUG] DownloadFolder: Found %d files", len(files))
-
-			// Queue all files for download
-			for _, f := range files {
-				if !f.IsDir {
-					remotePath := f.Path
-					relativePath := strings.TrimPrefix(f.Path, file.Path)
-					localPath := filepath.Join(saveDir, relativePath)
-
-					log.Printf("[DEBUG] DownloadFolder: Queuing %s -> %s", remotePath, localPath)
-
-					// Add download task
-					if err := dd.mainWindow.taskQueue.AddDownloadTask(remotePath, localPath); err != nil {
-						log.Printf("[DEBUG] DownloadFolder: Error queueing task - %v", err)
-						dialog.ShowError(err, dd.mainWindow.window)
-						return
-					}
-				}
-			}
+		log.Printf("[DEBUG] DownloadFolder: Queuing %s -> %s", file.Path, saveDir)
+		if err := dd.mainWindow.taskQueue.AddDownloadFolderTask(file.Path, saveDir); err != nil {
+			log.Printf("[DEBUG] DownloadFolder: Error queueing task - %v", err)
+			dialog.ShowError(err, dd.mainWindow.window)
+			return
+		}
 
-			dialog.ShowInformation("Download Started",
-				fmt.Sprintf("Downloading %d files from %s", len(files), file.Name),
-				dd.mainWindow.window)
-		}()
+		dialog.ShowInformation("Download Started",
+			fmt.Sprintf("Downloading folder %s", file.Name),
+			dd.mainWindow.window)
 	}, dd.mainWindow.window)
 }
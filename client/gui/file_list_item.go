@@ -59,14 +59,14 @@ func (fli *FileListItem) SetFile(file *kcpclient.ListItem, index int) {
 	fli.file = file
 	fli.index = index
 
-	if file.IsDir {
+	if file.IsDir || file.LinkIsDir {
 		fli.icon.SetResource(theme.FolderIcon())
 	} else {
 		fli.icon.SetResource(theme.FileIcon())
 	}
 
-	fli.nameLabel.SetText(file.Name)
-	fli.sizeLabel.SetText(formatSize(file.Size))
+	fli.nameLabel.SetText(formatDisplayName(file))
+	fli.sizeLabel.SetText(fli.mainWindow.formatItemSize(file))
 	fli.modeLabel.SetText(formatMode(file.Mode))
 	fli.dateLabel.SetText(formatTime(file.ModTime))
 }
@@ -98,7 +98,7 @@ func (fli *FileListItem) DoubleTapped(*fyne.PointEvent) {
 		return
 	}
 
-	if fli.file.IsDir {
+	if fli.file.IsDir || fli.file.LinkIsDir {
 		// Navigate into folder
 		fli.mainWindow.navigateToPath(fli.file.Path)
 	}
@@ -0,0 +1,273 @@
+package gui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+)
+
+const (
+	// logViewerTailLines is how many existing lines the log viewer starts with
+	logViewerTailLines = 500
+	// logViewerMaxLines is how many lines the log viewer keeps; older ones are dropped
+	logViewerMaxLines = 10000
+	// logViewerRefresh is how often new lines are shown
+	logViewerRefresh = 250 * time.Millisecond
+)
+
+// LogViewer shows the end of a remote file and follows what is appended to
+// it, without the size limit of the text editor
+type LogViewer struct {
+	mainWindow  *MainWindow
+	window      fyne.Window
+	file        *kcpclient.ListItem
+	list        *widget.List
+	filterEntry *widget.Entry
+	followCheck *widget.Check
+	pauseBtn    *widget.Button
+	statusLabel *widget.Label
+
+	mu      sync.Mutex
+	lines   []string      // Received lines, about the last logViewerMaxLines
+	shown   []string      // Lines matching the filter, as displayed
+	dirty   bool          // Lines arrived since the display was refreshed
+	paused  bool          // Display frozen; lines are still received
+	closed  bool          // Window closed
+	body    io.ReadCloser // Tail stream, closed with the window
+	stopped chan struct{}
+}
+
+// NewLogViewer creates a log viewer for a remote file
+func NewLogViewer(mainWindow *MainWindow, file *kcpclient.ListItem) *LogViewer {
+	lv := &LogViewer{
+		mainWindow: mainWindow,
+		file:       file,
+		stopped:    make(chan struct{}),
+	}
+
+	lv.window = mainWindow.app.NewWindow(fmt.Sprintf("Log: %s", file.Name))
+	lv.window.Resize(fyne.NewSize(900, 600))
+	lv.window.CenterOnScreen()
+
+	lv.setupUI()
+
+	return lv
+}
+
+// setupUI sets up the viewer UI
+func (lv *LogViewer) setupUI() {
+	lv.list = widget.NewList(
+		func() int {
+			return len(lv.shown)
+		},
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.TextStyle = fyne.TextStyle{Monospace: true}
+			label.Truncation = fyne.TextTruncateEllipsis
+			return label
+		},
+		func(i widget.ListItemID, o fyne.CanvasObject) {
+			o.(*widget.Label).SetText(lv.shown[i])
+		},
+	)
+
+	lv.filterEntry = widget.NewEntry()
+	lv.filterEntry.SetPlaceHolder("Filter (case-insensitive)")
+	lv.filterEntry.OnChanged = func(string) {
+		lv.refresh(true)
+	}
+
+	lv.followCheck = widget.NewCheck("Follow", func(checked bool) {
+		if checked {
+			lv.list.ScrollToBottom()
+		}
+	})
+	lv.followCheck.SetChecked(true)
+
+	lv.pauseBtn = widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), func() {
+		lv.togglePause()
+	})
+	clearBtn := widget.NewButtonWithIcon("Clear", theme.ContentClearIcon(), func() {
+		lv.mu.Lock()
+		lv.lines = nil
+		lv.mu.Unlock()
+		lv.refresh(true)
+	})
+
+	lv.statusLabel = widget.NewLabel("Connecting...")
+
+	toolbar := container.NewBorder(nil, nil,
+		container.NewHBox(lv.followCheck, lv.pauseBtn, clearBtn),
+		nil,
+		lv.filterEntry,
+	)
+	content := container.NewBorder(toolbar, lv.statusLabel, nil, nil, lv.list)
+	lv.window.SetContent(content)
+
+	lv.window.Canvas().SetOnTypedKey(func(key *fyne.KeyEvent) {
+		if key.Name == fyne.KeyEscape {
+			lv.window.Close()
+		}
+	})
+	lv.window.SetOnClosed(lv.stop)
+}
+
+// Show shows the viewer window and starts following the file
+func (lv *LogViewer) Show() {
+	log.Printf("[DEBUG] LogViewer.Show: Following %s", lv.file.Path)
+	lv.window.Show()
+	go lv.follow()
+	go lv.refreshLoop()
+}
+
+// follow receives the file's lines until the window is closed
+func (lv *LogViewer) follow() {
+	body, err := lv.mainWindow.client.Tail(lv.file.Path, logViewerTailLines, true)
+	if err != nil {
+		log.Printf("[DEBUG] LogViewer.follow: %v", err)
+		lv.setStatus(fmt.Sprintf("Failed to open: %v", err))
+		return
+	}
+
+	lv.mu.Lock()
+	if lv.closed {
+		lv.mu.Unlock()
+		body.Close()
+		return
+	}
+	lv.body = body
+	lv.mu.Unlock()
+	lv.setStatus("Following")
+
+	reader := bufio.NewReader(body)
+	for {
+		// A line still being written is shown once it is complete
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lv.appendLine(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			lv.mu.Lock()
+			closed := lv.closed
+			lv.mu.Unlock()
+			if !closed {
+				log.Printf("[DEBUG] LogViewer.follow: Stream of %s ended: %v", lv.file.Path, err)
+				lv.setStatus(fmt.Sprintf("Disconnected: %v", err))
+			}
+			return
+		}
+	}
+}
+
+// appendLine adds a received line, dropping the oldest ones once there are
+// clearly more than logViewerMaxLines
+func (lv *LogViewer) appendLine(line string) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.lines = append(lv.lines, line)
+	// Trim in batches into a new slice; the display may still read the old one
+	if len(lv.lines) > logViewerMaxLines+logViewerMaxLines/10 {
+		lv.lines = append([]string(nil), lv.lines[len(lv.lines)-logViewerMaxLines:]...)
+	}
+	lv.dirty = true
+}
+
+// refreshLoop shows new lines in batches so busy logs do not flood the UI
+func (lv *LogViewer) refreshLoop() {
+	ticker := time.NewTicker(logViewerRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lv.stopped:
+			return
+		case <-ticker.C:
+			lv.refresh(false)
+		}
+	}
+}
+
+// refresh rebuilds the displayed lines from the received ones; unless force
+// is set only when new lines arrived and the display is not paused
+func (lv *LogViewer) refresh(force bool) {
+	lv.mu.Lock()
+	if !force && (!lv.dirty || lv.paused) {
+		lv.mu.Unlock()
+		return
+	}
+	lv.dirty = false
+	lines := lv.lines
+	lv.mu.Unlock()
+
+	fyne.Do(func() {
+		filter := strings.ToLower(lv.filterEntry.Text)
+		shown := make([]string, 0, len(lines))
+		for _, line := range lines {
+			if filter == "" || strings.Contains(strings.ToLower(line), filter) {
+				shown = append(shown, line)
+			}
+		}
+		lv.shown = shown
+		lv.list.Refresh()
+		if lv.followCheck.Checked {
+			lv.list.ScrollToBottom()
+		}
+		if lv.paused {
+			return
+		}
+		if filter != "" {
+			lv.statusLabel.SetText(fmt.Sprintf("Following - %d of %d lines match", len(shown), len(lines)))
+		} else {
+			lv.statusLabel.SetText(fmt.Sprintf("Following - %d lines", len(lines)))
+		}
+	})
+}
+
+// togglePause freezes or resumes the display; lines keep arriving meanwhile
+func (lv *LogViewer) togglePause() {
+	lv.mu.Lock()
+	lv.paused = !lv.paused
+	paused := lv.paused
+	lv.mu.Unlock()
+
+	if paused {
+		lv.pauseBtn.SetText("Resume")
+		lv.pauseBtn.SetIcon(theme.MediaPlayIcon())
+		lv.statusLabel.SetText("Paused")
+		return
+	}
+	lv.pauseBtn.SetText("Pause")
+	lv.pauseBtn.SetIcon(theme.MediaPauseIcon())
+	lv.refresh(true)
+}
+
+// setStatus shows a message in the status line
+func (lv *LogViewer) setStatus(text string) {
+	fyne.Do(func() {
+		lv.statusLabel.SetText(text)
+	})
+}
+
+// stop ends the stream when the window is closed
+func (lv *LogViewer) stop() {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	if lv.closed {
+		return
+	}
+	lv.closed = true
+	close(lv.stopped)
+	if lv.body != nil {
+		lv.body.Close()
+	}
+}
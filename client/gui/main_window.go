@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/CertStone/simpleKcpFileManager/common"
 	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
 	"github.com/CertStone/simpleKcpFileManager/kcpclient/tasks"
 
@@ -139,8 +140,8 @@ func (l *EventInterceptLayer) DoubleTapped(e *fyne.PointEvent) {
 		// Select the item first
 		l.mainWindow.fileList.Select(widget.ListItemID(clickedIndex))
 
-		if file.IsDir {
-			// Navigate into folder
+		if file.IsDir || file.LinkIsDir {
+			// Navigate into folder (or symlink to a folder)
 			cleanPath := strings.TrimPrefix(file.Path, "/")
 			log.Printf("[DEBUG] DoubleTapped: Navigating to folder: %s", cleanPath)
 			l.mainWindow.navigateToPath(cleanPath)
@@ -169,6 +170,13 @@ type MainWindow struct {
 	selectedIndex       int // Track selected index for visual feedback
 	saveDir             string
 	packTransferConfig  kcpclient.PackTransferConfig // Pack transfer settings
+	uploadConflict      common.ConflictPolicy        // How uploads treat existing files
+	deltaTransfer       bool                         // Send only changed blocks of existing files
+	preserveMetadata    bool                         // Downloads keep the server's mtime and mode
+	checksumAlgo        string                       // Hash downloads are verified with
+	rateLimit           int64                        // Global bandwidth limit in bytes/s, 0 for unlimited
+	showFolderSizes     bool                         // Compute recursive folder sizes with du
+	folderSizes         map[string]int64             // Recursive folder sizes by path
 	uiMutex             sync.Mutex
 	doubleTapMutex      sync.Mutex // Protects double-tap detection state
 	lastTapTime         int64
@@ -224,6 +232,9 @@ func NewMainWindow(config MainWindowConfig) *MainWindow {
 		currentPath:        "",
 		saveDir:            config.SaveDir,
 		packTransferConfig: kcpclient.DefaultPackTransferConfig(),
+		uploadConflict:     common.ConflictOverwrite,
+		preserveMetadata:   true,
+		checksumAlgo:       common.HashSHA256,
 	}
 
 	log.Printf("[DEBUG] NewMainWindow: Creating task queue")
@@ -279,6 +290,9 @@ func NewMainWindowWithWindow(config MainWindowConfig, window fyne.Window) *MainW
 		currentPath:        "",
 		saveDir:            config.SaveDir,
 		packTransferConfig: kcpclient.DefaultPackTransferConfig(),
+		uploadConflict:     common.ConflictOverwrite,
+		preserveMetadata:   true,
+		checksumAlgo:       common.HashSHA256,
 	}
 
 	log.Printf("[DEBUG] NewMainWindowWithWindow: Creating task queue")
@@ -473,14 +487,14 @@ func (mw *MainWindow) createFileList() *widget.List {
 
 			file := mw.serverFiles[i]
 
-			if file.IsDir {
+			if file.IsDir || file.LinkIsDir {
 				icon.SetResource(theme.FolderIcon())
 			} else {
 				icon.SetResource(theme.FileIcon())
 			}
 
-			nameLabel.SetText(file.Name)
-			sizeLabel.SetText(formatSize(file.Size))
+			nameLabel.SetText(formatDisplayName(&file))
+			sizeLabel.SetText(mw.formatItemSize(&file))
 			modeLabel.SetText(formatMode(file.Mode))
 			dateLabel.SetText(formatTime(file.ModTime))
 		},
@@ -498,6 +512,7 @@ func (mw *MainWindow) createFileList() *widget.List {
 			mw.selectedFile = &fileCopy
 			mw.selectedIndex = int(id)
 			mw.updateInfoLabel(mw.selectedFile)
+			mw.loadFileDetails(mw.selectedFile.Path)
 			log.Printf("[DEBUG] FileList.OnSelected: Selected file=%s", mw.selectedFile.Name)
 		}
 	}
@@ -536,6 +551,56 @@ func (mw *MainWindow) updateInfoLabel(file *kcpclient.ListItem) {
 	mw.infoLabel.SetText(fmt.Sprintf("%s | %s | %s", kind, formatSize(file.Size), mt))
 }
 
+// loadFileDetails fetches full metadata for the selected path in the background
+// and extends the info label with owner, type and link details
+func (mw *MainWindow) loadFileDetails(remotePath string) {
+	if mw.client == nil || !mw.client.IsConnected() {
+		return
+	}
+
+	go func() {
+		stat, err := mw.client.Stat(remotePath, common.FieldAll)
+		if err != nil {
+			log.Printf("[DEBUG] loadFileDetails: Stat failed - %v", err)
+			return
+		}
+
+		fyne.Do(func() {
+			// Ignore stale results if the selection changed meanwhile
+			if mw.selectedFile == nil || mw.selectedFile.Path != remotePath {
+				return
+			}
+			mw.infoLabel.SetText(formatFileDetails(stat))
+		})
+	}()
+}
+
+// formatFileDetails formats detailed file metadata for the info label
+func formatFileDetails(stat *kcpclient.FileStat) string {
+	kind := "File"
+	if stat.IsDir {
+		kind = "Folder"
+	}
+	if stat.IsSymlink {
+		kind = "Link → " + stat.LinkTarget
+	}
+
+	parts := []string{kind, formatSize(stat.Size), formatTime(stat.ModTime), formatMode(stat.Mode)}
+	if stat.Owner != "" || stat.Group != "" {
+		parts = append(parts, stat.Owner+":"+stat.Group)
+	}
+	if stat.MimeType != "" {
+		parts = append(parts, stat.MimeType)
+	}
+	if stat.Nlink > 1 {
+		parts = append(parts, fmt.Sprintf("%d links", stat.Nlink))
+	}
+	if stat.Hidden {
+		parts = append(parts, "hidden")
+	}
+	return strings.Join(parts, " | ")
+}
+
 // createNavToolbar creates the navigation toolbar
 func (mw *MainWindow) createNavToolbar() *fyne.Container {
 	contextMenu := NewContextMenu(mw)
@@ -580,13 +645,27 @@ func (mw *MainWindow) createNavToolbar() *fyne.Container {
 		}
 	})
 
+	// Folder sizes toggle: computes recursive sizes on the server
+	var folderSizesBtn *widget.Button
+	folderSizesBtn = widget.NewButtonWithIcon("Folder Sizes", theme.StorageIcon(), func() {
+		mw.showFolderSizes = !mw.showFolderSizes
+		if mw.showFolderSizes {
+			folderSizesBtn.Importance = widget.HighImportance
+			mw.loadFolderSizes()
+		} else {
+			folderSizesBtn.Importance = widget.MediumImportance
+			mw.fileList.Refresh()
+		}
+		folderSizesBtn.Refresh()
+	})
+
 	// Settings button
 	settingsBtn := widget.NewButtonWithIcon("Settings", theme.SettingsIcon(), func() {
 		settingsDialog := NewSettingsDialog(mw)
 		settingsDialog.Show()
 	})
 
-	return container.NewHBox(homeBtn, upBtn, refreshBtn, widget.NewSeparator(), downloadBtn, uploadBtn, actionsBtn, widget.NewSeparator(), settingsBtn)
+	return container.NewHBox(homeBtn, upBtn, refreshBtn, widget.NewSeparator(), downloadBtn, uploadBtn, actionsBtn, widget.NewSeparator(), folderSizesBtn, settingsBtn)
 }
 
 // createSortToolbar creates the sort toolbar with clickable column headers
@@ -724,6 +803,11 @@ func (mw *MainWindow) connectToServer() {
 					// Update both client and taskManager
 					mw.client = newClient
 					mw.taskManager = tasks.NewManager(newClient, 3, mw.packTransferConfig)
+					mw.taskManager.SetConflictPolicy(mw.uploadConflict)
+					mw.taskManager.SetDeltaTransfer(mw.deltaTransfer)
+					mw.taskManager.SetPreserveMetadata(mw.preserveMetadata)
+					mw.taskManager.SetChecksumAlgorithm(mw.checksumAlgo)
+					mw.taskManager.SetRateLimit(mw.rateLimit)
 					mw.taskQueue.taskManager = mw.taskManager
 
 					// Try connecting again
@@ -849,7 +933,8 @@ func (mw *MainWindow) refreshFileList() {
 	// Load data in background, then update UI safely
 	go func() {
 		log.Printf("[DEBUG] refreshFileList: Calling ListFiles")
-		files, err := mw.client.ListFiles(mw.currentPath, false)
+		// Link and inode info are cheap and let the list mark symlinks and hard links
+		files, err := mw.client.ListFiles(mw.currentPath, false, common.FieldLink, common.FieldInode)
 		if err != nil {
 			log.Printf("[DEBUG] refreshFileList: ListFiles failed - %v", err)
 			mw.safeUpdateStatus("Load failed: " + err.Error())
@@ -860,9 +945,63 @@ func (mw *MainWindow) refreshFileList() {
 		log.Printf("[DEBUG] refreshFileList: Updating UI")
 		mw.safeUpdateFileList(files)
 		log.Printf("[DEBUG] refreshFileList: UI updated")
+
+		if mw.showFolderSizes {
+			fyne.Do(mw.loadFolderSizes)
+		}
+	}()
+}
+
+// loadFolderSizes fetches recursive sizes of the folders in the current
+// directory and shows them in the list once the server has computed them
+func (mw *MainWindow) loadFolderSizes() {
+	dir := mw.currentPath
+	go func() {
+		usage, err := mw.client.DiskUsage("/"+dir, 0, false, nil)
+		if err != nil {
+			log.Printf("[DEBUG] loadFolderSizes: %v", err)
+			return
+		}
+
+		fyne.Do(func() {
+			if mw.folderSizes == nil {
+				mw.folderSizes = make(map[string]int64)
+			}
+			for _, entry := range usage.Entries {
+				if entry.IsDir {
+					mw.folderSizes[entry.Path] = entry.Size
+				}
+			}
+			if dir != mw.currentPath {
+				return // Navigated away meanwhile
+			}
+
+			// Store the sizes in the items too so sorting by size works
+			for i := range mw.serverFiles {
+				if size, ok := mw.folderSizes[mw.serverFiles[i].Path]; ok && mw.serverFiles[i].IsDir {
+					mw.serverFiles[i].Size = size
+				}
+			}
+			if mw.sortColumn == "size" {
+				mw.sortFiles()
+			}
+			mw.fileList.Refresh()
+		})
 	}()
 }
 
+// formatItemSize formats the size column. Folder sizes are only meaningful
+// when computed by du, so they are shown as "-" otherwise.
+func (mw *MainWindow) formatItemSize(file *kcpclient.ListItem) string {
+	if !file.IsDir {
+		return formatSize(file.Size)
+	}
+	if size, ok := mw.folderSizes[file.Path]; ok && mw.showFolderSizes {
+		return formatSize(size)
+	}
+	return "-"
+}
+
 // navigateUp navigates to parent directory
 func (mw *MainWindow) navigateUp() {
 	if mw.currentPath == "" {
@@ -905,6 +1044,18 @@ func formatSize(size int64) string {
 	return fmt.Sprintf("%.2f GB", float64(size)/(1024*1024*1024))
 }
 
+// formatDisplayName formats a file name for the list, marking symlinks with
+// their target and hard-linked files with their link count
+func formatDisplayName(file *kcpclient.ListItem) string {
+	if file.IsSymlink {
+		return file.Name + " → " + file.LinkTarget
+	}
+	if !file.IsDir && file.Nlink > 1 {
+		return fmt.Sprintf("%s [%d links]", file.Name, file.Nlink)
+	}
+	return file.Name
+}
+
 // formatTime formats Unix timestamp for display
 func formatTime(t int64) string {
 	if t == 0 {
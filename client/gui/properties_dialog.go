@@ -0,0 +1,218 @@
+package gui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// maxPreviewChanges limits how many entries the dry-run preview lists
+const maxPreviewChanges = 200
+
+// PropertiesDialog shows file details and edits permissions and ownership
+type PropertiesDialog struct {
+	mainWindow     *MainWindow
+	file           *kcpclient.ListItem
+	stat           *kcpclient.FileStat
+	infoLabel      *widget.Label
+	modeEntry      *widget.Entry
+	fileModeEntry  *widget.Entry
+	dirModeEntry   *widget.Entry
+	userEntry      *widget.Entry
+	groupEntry     *widget.Entry
+	recursiveCheck *widget.Check
+	dialog         dialog.Dialog
+}
+
+// NewPropertiesDialog creates a properties dialog for file
+func NewPropertiesDialog(mainWindow *MainWindow, file *kcpclient.ListItem) *PropertiesDialog {
+	return &PropertiesDialog{
+		mainWindow: mainWindow,
+		file:       file,
+	}
+}
+
+// Show displays the dialog and loads the current details in the background
+func (pd *PropertiesDialog) Show() {
+	pd.infoLabel = widget.NewLabel("Loading...")
+	pd.infoLabel.Wrapping = fyne.TextWrapWord
+
+	pd.modeEntry = widget.NewEntry()
+	pd.modeEntry.SetPlaceHolder("755 or u+x,go-w")
+	pd.userEntry = widget.NewEntry()
+	pd.userEntry.SetPlaceHolder("unchanged")
+	pd.groupEntry = widget.NewEntry()
+	pd.groupEntry.SetPlaceHolder("unchanged")
+
+	form := widget.NewForm(widget.NewFormItem("Mode", pd.modeEntry))
+
+	// Folders can be changed recursively with separate file/folder modes
+	if pd.file.IsDir {
+		pd.fileModeEntry = widget.NewEntry()
+		pd.fileModeEntry.SetPlaceHolder("same as Mode")
+		pd.dirModeEntry = widget.NewEntry()
+		pd.dirModeEntry.SetPlaceHolder("same as Mode")
+		pd.recursiveCheck = widget.NewCheck("Apply to all files and folders inside", nil)
+
+		form.Append("File mode", pd.fileModeEntry)
+		form.Append("Folder mode", pd.dirModeEntry)
+	}
+	form.Append("Owner", pd.userEntry)
+	form.Append("Group", pd.groupEntry)
+
+	content := container.NewVBox(
+		pd.infoLabel,
+		widget.NewSeparator(),
+		form,
+	)
+	if pd.recursiveCheck != nil {
+		content.Add(pd.recursiveCheck)
+	}
+	content.Add(container.NewHBox(
+		widget.NewButton("Preview Changes", func() {
+			pd.apply(true)
+		}),
+		widget.NewButton("Apply", func() {
+			pd.apply(false)
+		}),
+	))
+
+	pd.dialog = dialog.NewCustom("Properties: "+pd.file.Name, "Close", content, pd.mainWindow.window)
+	pd.dialog.Resize(fyne.NewSize(480, 420))
+	pd.dialog.Show()
+
+	go pd.load()
+}
+
+// load fetches full metadata and fills in the current values
+func (pd *PropertiesDialog) load() {
+	stat, err := pd.mainWindow.client.Stat(pd.file.Path, common.FieldAll)
+	fyne.Do(func() {
+		if err != nil {
+			pd.infoLabel.SetText("Failed to load details: " + err.Error())
+			return
+		}
+		pd.stat = stat
+		pd.infoLabel.SetText(fmt.Sprintf("Path: %s\n%s", stat.Path, formatFileDetails(stat)))
+		pd.modeEntry.SetText(fmt.Sprintf("%o", stat.ModeNum))
+		pd.userEntry.SetText(stat.Owner)
+		pd.groupEntry.SetText(stat.Group)
+	})
+}
+
+// apply sends the chmod/chown requests, as a dry run if preview is set.
+// Only values that differ from the current ones are sent, so a recursive
+// chown does not also reset every mode to the folder's own mode.
+func (pd *PropertiesDialog) apply(preview bool) {
+	recursive := pd.recursiveCheck != nil && pd.recursiveCheck.Checked
+
+	chmod := kcpclient.ChmodOptions{Recursive: recursive, DryRun: preview}
+	mode := strings.TrimSpace(pd.modeEntry.Text)
+	if pd.stat == nil || mode != fmt.Sprintf("%o", pd.stat.ModeNum) {
+		chmod.Mode = mode
+	}
+	if pd.fileModeEntry != nil {
+		chmod.FileMode = strings.TrimSpace(pd.fileModeEntry.Text)
+		chmod.DirMode = strings.TrimSpace(pd.dirModeEntry.Text)
+	}
+
+	chown := kcpclient.ChownOptions{Recursive: recursive, DryRun: preview}
+	if user := strings.TrimSpace(pd.userEntry.Text); pd.stat == nil || user != pd.stat.Owner {
+		chown.User = user
+	}
+	if group := strings.TrimSpace(pd.groupEntry.Text); pd.stat == nil || group != pd.stat.Group {
+		chown.Group = group
+	}
+
+	go func() {
+		var results []*kcpclient.PermResult
+		var err error
+
+		if chmod.Mode != "" || chmod.FileMode != "" || chmod.DirMode != "" {
+			var res *kcpclient.PermResult
+			if res, err = pd.mainWindow.client.Chmod(pd.file.Path, chmod); err == nil {
+				results = append(results, res)
+			}
+		}
+		if err == nil && (chown.User != "" || chown.Group != "") {
+			var res *kcpclient.PermResult
+			if res, err = pd.mainWindow.client.Chown(pd.file.Path, chown); err == nil {
+				results = append(results, res)
+			}
+		}
+
+		fyne.Do(func() {
+			if err != nil {
+				dialog.ShowError(err, pd.mainWindow.window)
+				return
+			}
+			pd.showResults(results, preview)
+			if !preview {
+				go pd.load()
+				pd.mainWindow.refreshFileList()
+			}
+		})
+	}()
+}
+
+// showResults lists the changes (or failures) reported by the server
+func (pd *PropertiesDialog) showResults(results []*kcpclient.PermResult, preview bool) {
+	var lines []string
+	changed, failed := 0, 0
+	for _, res := range results {
+		changed += res.Changed
+		failed += res.Failed
+		for _, c := range res.Changes {
+			// After applying only failures are interesting
+			if !preview && c.Error == "" {
+				continue
+			}
+			if len(lines) >= maxPreviewChanges {
+				continue
+			}
+			lines = append(lines, formatPermChange(c))
+		}
+	}
+
+	title := "Changes Applied"
+	summary := fmt.Sprintf("%d changed, %d failed", changed, failed)
+	if preview {
+		title = "Preview"
+		summary = fmt.Sprintf("%d entries would change", changed)
+	}
+	if len(lines) == maxPreviewChanges {
+		summary += fmt.Sprintf(" (showing first %d)", maxPreviewChanges)
+	}
+
+	list := widget.NewLabel(strings.Join(lines, "\n"))
+	scroll := container.NewVScroll(list)
+	scroll.SetMinSize(fyne.NewSize(520, 240))
+
+	content := container.NewBorder(widget.NewLabel(summary), nil, nil, nil, scroll)
+	if len(lines) == 0 {
+		content = container.NewVBox(widget.NewLabel(summary))
+	}
+	dialog.ShowCustom(title, "OK", content, pd.mainWindow.window)
+}
+
+// formatPermChange renders one change as "path: 0644 → 0755"
+func formatPermChange(c kcpclient.PermChange) string {
+	var parts []string
+	if c.NewMode != "" {
+		parts = append(parts, c.OldMode+" → "+c.NewMode)
+	}
+	if c.NewOwner != "" {
+		parts = append(parts, c.OldOwner+" → "+c.NewOwner)
+	}
+	if c.Error != "" {
+		parts = append(parts, "error: "+c.Error)
+	}
+	return c.Path + ": " + strings.Join(parts, ", ")
+}
@@ -3,7 +3,9 @@ package gui
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/CertStone/simpleKcpFileManager/common"
 	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
 
 	"fyne.io/fyne/v2"
@@ -18,9 +20,26 @@ type SettingsDialog struct {
 	packTransferCheck *widget.Check
 	thresholdEntry    *widget.Entry
 	downloadDirEntry  *widget.Entry
+	conflictSelect    *widget.Select
+	deltaCheck        *widget.Check
+	metadataCheck     *widget.Check
+	checksumSelect    *widget.Select
+	rateLimitEntry    *widget.Entry
 	config            kcpclient.PackTransferConfig
 }
 
+// conflictPolicyLabels lists the upload conflict policies in display order
+var conflictPolicyLabels = []struct {
+	policy common.ConflictPolicy
+	label  string
+}{
+	{common.ConflictOverwrite, "覆盖"},
+	{common.ConflictNewer, "仅当本地文件较新时覆盖"},
+	{common.ConflictRename, "保留两者 (自动重命名)"},
+	{common.ConflictSkip, "跳过"},
+	{common.ConflictFail, "报错"},
+}
+
 // NewSettingsDialog creates a new settings dialog
 func NewSettingsDialog(mainWindow *MainWindow) *SettingsDialog {
 	return &SettingsDialog{
@@ -47,6 +66,58 @@ func (sd *SettingsDialog) Show() {
 		sd.downloadDirEntry,
 	)
 
+	// Create upload conflict policy select
+	var conflictOptions []string
+	selected := conflictPolicyLabels[0].label
+	for _, c := range conflictPolicyLabels {
+		conflictOptions = append(conflictOptions, c.label)
+		if c.policy == sd.mainWindow.uploadConflict {
+			selected = c.label
+		}
+	}
+	sd.conflictSelect = widget.NewSelect(conflictOptions, nil)
+	sd.conflictSelect.SetSelected(selected)
+
+	conflictContainer := container.NewBorder(
+		nil, nil,
+		widget.NewLabel("上传时文件已存在:"),
+		nil,
+		sd.conflictSelect,
+	)
+
+	// Create delta transfer checkbox
+	sd.deltaCheck = widget.NewCheck("增量传输 (文件已存在时只发送变化的部分)", nil)
+	sd.deltaCheck.Checked = sd.mainWindow.deltaTransfer
+
+	// Create preserve metadata checkbox
+	sd.metadataCheck = widget.NewCheck("下载时保留服务器上的修改时间和权限", nil)
+	sd.metadataCheck.Checked = sd.mainWindow.preserveMetadata
+
+	// Create checksum algorithm select
+	sd.checksumSelect = widget.NewSelect(common.HashAlgorithms, nil)
+	sd.checksumSelect.SetSelected(sd.mainWindow.checksumAlgo)
+
+	checksumContainer := container.NewBorder(
+		nil, nil,
+		widget.NewLabel("下载校验算法:"),
+		nil,
+		sd.checksumSelect,
+	)
+
+	// Create global speed limit entry
+	sd.rateLimitEntry = widget.NewEntry()
+	sd.rateLimitEntry.SetPlaceHolder("0")
+	if sd.mainWindow.rateLimit > 0 {
+		sd.rateLimitEntry.SetText(strconv.FormatInt(sd.mainWindow.rateLimit/1024, 10))
+	}
+
+	rateLimitContainer := container.NewBorder(
+		nil, nil,
+		widget.NewLabel("全局限速:"),
+		widget.NewLabel("KB/s (0 为不限)"),
+		sd.rateLimitEntry,
+	)
+
 	// Create pack transfer checkbox
 	sd.packTransferCheck = widget.NewCheck("启用打包传输", func(checked bool) {
 		sd.config.Enabled = checked
@@ -82,6 +153,11 @@ func (sd *SettingsDialog) Show() {
 	content := container.NewVBox(
 		widget.NewSeparator(),
 		downloadDirContainer,
+		conflictContainer,
+		sd.deltaCheck,
+		sd.metadataCheck,
+		checksumContainer,
+		rateLimitContainer,
 		widget.NewLabel(""),
 		widget.NewSeparator(),
 		sd.packTransferCheck,
@@ -124,13 +200,41 @@ func (sd *SettingsDialog) saveSettings() {
 	// Save to main window
 	sd.mainWindow.packTransferConfig = sd.config
 
+	// Save upload conflict policy
+	for _, c := range conflictPolicyLabels {
+		if c.label == sd.conflictSelect.Selected {
+			sd.mainWindow.uploadConflict = c.policy
+		}
+	}
+
+	sd.mainWindow.deltaTransfer = sd.deltaCheck.Checked
+	sd.mainWindow.preserveMetadata = sd.metadataCheck.Checked
+	if sd.checksumSelect.Selected != "" {
+		sd.mainWindow.checksumAlgo = sd.checksumSelect.Selected
+	}
+	if limit, err := parseRateLimit(sd.rateLimitEntry.Text); err == nil {
+		sd.mainWindow.rateLimit = limit
+	} else {
+		dialog.ShowError(err, sd.mainWindow.window)
+	}
+
 	// Update task manager configuration
 	sd.mainWindow.taskManager.SetPackTransferConfig(sd.config)
+	sd.mainWindow.taskManager.SetConflictPolicy(sd.mainWindow.uploadConflict)
+	sd.mainWindow.taskManager.SetDeltaTransfer(sd.mainWindow.deltaTransfer)
+	sd.mainWindow.taskManager.SetPreserveMetadata(sd.mainWindow.preserveMetadata)
+	sd.mainWindow.taskManager.SetChecksumAlgorithm(sd.mainWindow.checksumAlgo)
+	sd.mainWindow.taskManager.SetRateLimit(sd.mainWindow.rateLimit)
 
 	// Show confirmation
 	dialog.ShowInformation("设置已保存",
 		"设置已更新\n"+
 			fmt.Sprintf("• 下载文件夹: %s\n", sd.mainWindow.saveDir)+
+			fmt.Sprintf("• 上传冲突: %s\n", sd.conflictSelect.Selected)+
+			fmt.Sprintf("• 增量传输: %s\n", getEnabledStatus(sd.mainWindow.deltaTransfer))+
+			fmt.Sprintf("• 保留时间和权限: %s\n", getEnabledStatus(sd.mainWindow.preserveMetadata))+
+			fmt.Sprintf("• 校验算法: %s\n", sd.mainWindow.checksumAlgo)+
+			fmt.Sprintf("• 全局限速: %s\n", formatRateLimit(sd.mainWindow.rateLimit))+
 			fmt.Sprintf("• 打包传输: %s\n", getEnabledStatus(sd.config.Enabled))+
 			fmt.Sprintf("• 阈值: %d MB", thresholdMB),
 		sd.mainWindow.window)
@@ -149,6 +253,28 @@ func (sd *SettingsDialog) showBrowseDialog() {
 	}, sd.mainWindow.window)
 }
 
+// parseRateLimit converts a speed limit entered in KB/s to bytes per second;
+// an empty entry means unlimited
+func parseRateLimit(text string) (int64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	kb, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || kb < 0 {
+		return 0, fmt.Errorf("invalid speed limit: %s", text)
+	}
+	return kb * 1024, nil
+}
+
+// formatRateLimit returns a human-readable speed limit
+func formatRateLimit(bytesPerSec int64) string {
+	if bytesPerSec <= 0 {
+		return "不限"
+	}
+	return formatSize(bytesPerSec) + "/s"
+}
+
 // getEnabledStatus returns human-readable status
 func getEnabledStatus(enabled bool) string {
 	if enabled {
@@ -43,7 +43,31 @@ type TaskWidget struct {
 	lastUpdate   time.Time
 	updateTicker *time.Ticker
 	stopTicker   chan struct{}
-	container    *fyne.Container // Reference to prevent duplicate UI adds
+	container    *taskRow // Reference to prevent duplicate UI adds
+}
+
+// taskRow shows a task in the queue and reports right-clicks on it
+type taskRow struct {
+	widget.BaseWidget
+	content     fyne.CanvasObject
+	onSecondary func(pos fyne.Position)
+}
+
+// newTaskRow creates a row showing content
+func newTaskRow(content fyne.CanvasObject, onSecondary func(pos fyne.Position)) *taskRow {
+	row := &taskRow{content: content, onSecondary: onSecondary}
+	row.ExtendBaseWidget(row)
+	return row
+}
+
+// CreateRenderer creates the widget renderer
+func (r *taskRow) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(r.content)
+}
+
+// TappedSecondary handles right-click
+func (r *taskRow) TappedSecondary(e *fyne.PointEvent) {
+	r.onSecondary(e.AbsolutePosition)
 }
 
 // NewTaskQueue creates a new task queue
@@ -193,11 +217,13 @@ func (tq *TaskQueue) rebuildTaskList() {
 			tw.container = nil // Ensure it's reset
 			// Create task row directly without calling addTaskToUI to avoid duplicate checks
 			buttonBar := container.NewHBox(tw.pauseBtn, tw.resumeBtn, tw.retryBtn, tw.cancelBtn)
-			taskRow := container.NewVBox(
+			taskRow := newTaskRow(container.NewVBox(
 				container.NewBorder(nil, nil, tw.fileLabel, buttonBar, tw.statusLabel),
 				tw.progressBar,
 				widget.NewSeparator(),
-			)
+			), func(pos fyne.Position) {
+				NewContextMenu(tq.mainWindow).ShowTaskMenu(tw.task, pos)
+			})
 			tw.container = taskRow
 			tq.container.Add(taskRow)
 		}
@@ -363,6 +389,9 @@ func (tw *TaskWidget) update(task *tasks.Task) {
 		} else {
 			statusText = fmt.Sprintf("Downloading: %.2f MB/s", task.Speed)
 		}
+		if limit := task.Limiter.Limit(); limit > 0 {
+			statusText += fmt.Sprintf(" (limit %s/s)", formatSize(limit))
+		}
 		tw.pauseBtn.Show()
 		tw.cancelBtn.Enable()
 		tw.retryBtn.Hide()
@@ -408,12 +437,14 @@ func (tq *TaskQueue) addTaskToUI(taskID string, tw *TaskWidget) {
 	// Create button bar
 	buttonBar := container.NewHBox(tw.pauseBtn, tw.resumeBtn, tw.retryBtn, tw.cancelBtn)
 
-	// Create task row
-	taskRow := container.NewVBox(
+	// Create task row; right-clicking it opens the task menu
+	taskRow := newTaskRow(container.NewVBox(
 		container.NewBorder(nil, nil, tw.fileLabel, buttonBar, tw.statusLabel),
 		tw.progressBar,
 		widget.NewSeparator(),
-	)
+	), func(pos fyne.Position) {
+		NewContextMenu(tq.mainWindow).ShowTaskMenu(tw.task, pos)
+	})
 
 	// Store reference to prevent duplicate adds
 	tw.container = taskRow
@@ -427,11 +458,11 @@ func (tq *TaskQueue) addTaskToUI(taskID string, tw *TaskWidget) {
 
 // retryTask retries a failed task
 func (tq *TaskQueue) retryTask(taskID string) {
-	// This would need to be implemented in the task manager
-	// For now, just remove the task widget
-	tq.taskMutex.Lock()
-	delete(tq.taskWidgets, taskID)
-	tq.taskMutex.Unlock()
+	// The widget follows the task's new status on the next refresh
+	if err := tq.taskManager.RetryTask(taskID); err != nil {
+		log.Printf("[DEBUG] TaskQueue.retryTask: %v", err)
+		dialog.ShowError(err, tq.mainWindow.window)
+	}
 }
 
 // cancelTask cancels a task
@@ -452,6 +483,19 @@ func (tq *TaskQueue) AddDownloadTask(remotePath, localPath string) error {
 	return nil
 }
 
+// AddDownloadFolderTask adds a task downloading a whole folder to the queue
+func (tq *TaskQueue) AddDownloadFolderTask(remotePath, localPath string) error {
+	task, err := tq.taskManager.AddDownloadFolderTask(remotePath, localPath)
+	if err != nil {
+		return err
+	}
+	// Immediately add to UI
+	go func() {
+		tq.updateTaskWidget(task)
+	}()
+	return nil
+}
+
 // AddUploadTask adds an upload task to the queue
 func (tq *TaskQueue) AddUploadTask(localPath, remotePath string) error {
 	task, err := tq.taskManager.AddUploadTask(localPath, remotePath)
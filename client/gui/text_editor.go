@@ -1,6 +1,7 @@
 package gui
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -24,6 +25,7 @@ type TextEditor struct {
 	saveBtn    *widget.Button
 	statusLabel *widget.Label
 	isModified bool
+	etag       string // Version of the file the editor content is based on
 }
 
 // NewTextEditor creates a new text editor
@@ -130,7 +132,7 @@ func (te *TextEditor) loadContent() {
 		te.statusLabel.SetText("Loading...")
 	})
 
-	content, err := te.mainWindow.client.ReadFile(te.file.Path)
+	content, etag, err := te.mainWindow.client.ReadFileETag(te.file.Path)
 	if err != nil {
 		log.Printf("[DEBUG] TextEditor.loadContent: Error - %v", err)
 		fyne.Do(func() {
@@ -159,6 +161,7 @@ func (te *TextEditor) loadContent() {
 		te.statusLabel.SetText(fmt.Sprintf("Loaded %d bytes", len(content)))
 		te.saveBtn.Enable()
 		te.isModified = false
+		te.etag = etag
 		te.updateWindowTitle()
 	})
 
@@ -192,8 +195,15 @@ func (te *TextEditor) isLikelyText(content string) bool {
 	return ratio > 0.9
 }
 
-// saveFile saves the file content to server
+// saveFile saves the file content to server, unless someone else changed
+// the file since it was loaded
 func (te *TextEditor) saveFile() {
+	te.save(kcpclient.IfMatch(te.etag))
+}
+
+// save saves the file content to server if pre holds. If the file changed on
+// the server the user may overwrite it anyway.
+func (te *TextEditor) save(pre kcpclient.Precondition) {
 	log.Printf("[DEBUG] TextEditor.saveFile: START for %s", te.file.Path)
 
 	content := te.textEntry.Text
@@ -205,7 +215,24 @@ func (te *TextEditor) saveFile() {
 
 	// Save in background
 	go func() {
-		err := te.mainWindow.client.SaveFile(te.file.Path, content)
+		etag, err := te.mainWindow.client.SaveFileIf(te.file.Path, content, pre)
+		var changed *kcpclient.PreconditionFailedError
+		if errors.As(err, &changed) {
+			log.Printf("[DEBUG] TextEditor.saveFile: File changed on server")
+			fyne.Do(func() {
+				te.statusLabel.SetText("File changed on server")
+				te.saveBtn.Enable()
+				dialog.ShowConfirm("File Changed",
+					"The file was changed on the server since it was opened. Overwrite those changes?",
+					func(confirmed bool) {
+						if confirmed {
+							te.save(kcpclient.Precondition{})
+						}
+					},
+					te.window)
+			})
+			return
+		}
 		if err != nil {
 			log.Printf("[DEBUG] TextEditor.saveFile: Error - %v", err)
 			fyne.Do(func() {
@@ -220,6 +247,7 @@ func (te *TextEditor) saveFile() {
 
 		fyne.Do(func() {
 			te.isModified = false
+			te.etag = etag
 			te.updateWindowTitle()
 			te.statusLabel.SetText(fmt.Sprintf("Saved at %s", time.Now().Format("15:04:05")))
 			te.saveBtn.Enable()
@@ -0,0 +1,11 @@
+package common
+
+// BatchRequest is the body of a batch action: the files to send together in
+// one tar stream
+type BatchRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// BatchHashRecord is the PAX record of a batch entry holding the hex checksum
+// of its content, computed with the algorithm named by X-Hash-Algorithm
+const BatchHashRecord = "KCPFM.hash"
@@ -26,20 +26,27 @@ func CompressToTarGz(srcPath, dstPath string) error {
 	}
 	defer dstFile.Close()
 
+	return WriteTarGz(dstFile, srcPath, nil)
+}
+
+// WriteTarGz writes a file or folder as a tar.gz stream to w, so it can be
+// uploaded while it is produced. The archive contains the source name as its
+// root. onProgress, if set, receives the number of source bytes read so far.
+func WriteTarGz(w io.Writer, srcPath string, onProgress func(done int64)) error {
 	// Create gzip writer
-	gzw := gzip.NewWriter(dstFile)
-	defer gzw.Close()
+	gzw := gzip.NewWriter(w)
 
 	// Create tar writer
 	tw := tar.NewWriter(gzw)
-	defer tw.Close()
 
 	// Get the parent directory of source to calculate relative paths
 	// This ensures consistent behavior: tar always contains the source name as root
 	srcParentDir := filepath.Dir(srcPath)
 
+	var done int64
+
 	// Walk through source path
-	err = filepath.Walk(srcPath, func(file string, fi os.FileInfo, err error) error {
+	err := filepath.Walk(srcPath, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -74,16 +81,21 @@ func CompressToTarGz(srcPath, dstPath string) error {
 		}
 
 		// Write file content if not a directory
-		if !fi.IsDir() {
+		if fi.Mode().IsRegular() {
 			fileObj, err := os.Open(file)
 			if err != nil {
 				return fmt.Errorf("open file: %w", err)
 			}
 			defer fileObj.Close()
 
-			if _, err := io.Copy(tw, fileObj); err != nil {
+			n, err := io.Copy(tw, fileObj)
+			if err != nil {
 				return fmt.Errorf("write file content: %w", err)
 			}
+			done += n
+			if onProgress != nil {
+				onProgress(done)
+			}
 		}
 
 		return nil
@@ -93,7 +105,10 @@ func CompressToTarGz(srcPath, dstPath string) error {
 		return fmt.Errorf("walk source path: %w", err)
 	}
 
-	return nil
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
 }
 
 // DecompressFromTarGz decompresses a tar.gz file to destination folder
@@ -114,8 +129,16 @@ func DecompressFromTarGz(srcPath, dstPath string) error {
 	}
 	defer gzr.Close()
 
+	return ExtractTar(gzr, dstPath, nil)
+}
+
+// ExtractTar extracts an uncompressed tar stream to destination folder while
+// it is read, replacing existing files. Symlinks and other special entries
+// are skipped. onProgress, if set, receives the number of file bytes written
+// so far.
+func ExtractTar(r io.Reader, dstPath string, onProgress func(done int64)) error {
 	// Create tar reader
-	tr := tar.NewReader(gzr)
+	tr := tar.NewReader(r)
 
 	// Create destination directory
 	if err := os.MkdirAll(dstPath, 0755); err != nil {
@@ -128,6 +151,8 @@ func DecompressFromTarGz(srcPath, dstPath string) error {
 		return fmt.Errorf("get absolute dest path: %w", err)
 	}
 
+	progress := &progressCounter{onProgress: onProgress}
+
 	// Extract files
 	for {
 		header, err := tr.Next()
@@ -169,11 +194,11 @@ func DecompressFromTarGz(srcPath, dstPath string) error {
 				return fmt.Errorf("create file: %w", err)
 			}
 
-			if _, err := io.Copy(fileObj, tr); err != nil {
-				fileObj.Close()
+			_, err = io.Copy(io.MultiWriter(fileObj, progress), tr)
+			fileObj.Close()
+			if err != nil {
 				return fmt.Errorf("write file content: %w", err)
 			}
-			fileObj.Close()
 
 		default:
 			// Skip unsupported types (symlinks, etc.)
@@ -183,6 +208,20 @@ func DecompressFromTarGz(srcPath, dstPath string) error {
 	return nil
 }
 
+// progressCounter reports the running total of the bytes written to it
+type progressCounter struct {
+	done       int64
+	onProgress func(done int64)
+}
+
+func (p *progressCounter) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.onProgress != nil {
+		p.onProgress(p.done)
+	}
+	return len(b), nil
+}
+
 // ShouldCompressFile returns true if file size exceeds threshold
 func ShouldCompressFile(filePath string, thresholdBytes int64) bool {
 	info, err := os.Stat(filePath)
@@ -0,0 +1,117 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ConflictPolicy decides what happens when a destination already exists
+type ConflictPolicy string
+
+const (
+	ConflictFail      ConflictPolicy = "fail"      // Refuse with a conflict error
+	ConflictOverwrite ConflictPolicy = "overwrite" // Replace files, merge directories
+	ConflictSkip      ConflictPolicy = "skip"      // Leave the destination untouched
+	ConflictRename    ConflictPolicy = "rename"    // Write to "name (1).ext" instead
+	ConflictNewer     ConflictPolicy = "newer"     // Overwrite only if the source is newer
+)
+
+// Conflict outcomes reported back to the client
+const (
+	ConflictResultCreated     = "created"
+	ConflictResultOverwritten = "overwritten"
+	ConflictResultSkipped     = "skipped"
+	ConflictResultRenamed     = "renamed"
+)
+
+// ConflictError reports a destination that already exists under ConflictFail,
+// or one that cannot be replaced because its type differs from the source
+type ConflictError struct {
+	Path   string // Filesystem path of the existing entry
+	Reason string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict at %s: %s", e.Path, e.Reason)
+}
+
+// ParseConflictPolicy parses a conflict query value; empty selects def
+func ParseConflictPolicy(s string, def ConflictPolicy) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return def, nil
+	case ConflictFail, ConflictOverwrite, ConflictSkip, ConflictRename, ConflictNewer:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown conflict policy: %s", s)
+	}
+}
+
+// ResolveConflict decides where a source should be written given an existing dst.
+// It returns the path to write to (empty when skipped) and the outcome.
+// srcModTime is only consulted for ConflictNewer.
+func ResolveConflict(dst string, srcIsDir bool, srcModTime time.Time, policy ConflictPolicy) (string, string, error) {
+	existing, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		return dst, ConflictResultCreated, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	switch policy {
+	case ConflictSkip:
+		return "", ConflictResultSkipped, nil
+	case ConflictRename:
+		return UniquePath(dst), ConflictResultRenamed, nil
+	case ConflictFail:
+		return "", "", &ConflictError{Path: dst, Reason: "destination already exists"}
+	}
+
+	// Overwrite and newer replace files and merge directories, never one with the other
+	if existing.IsDir() != srcIsDir {
+		return "", "", &ConflictError{Path: dst, Reason: "cannot replace a file with a directory or vice versa"}
+	}
+	if policy == ConflictNewer && !srcIsDir && !srcModTime.After(existing.ModTime()) {
+		return "", ConflictResultSkipped, nil
+	}
+	return dst, ConflictResultOverwritten, nil
+}
+
+// UniquePath returns the first "name (N).ext" variant of p that does not exist.
+// Compound archive extensions such as .tar.gz are kept together.
+func UniquePath(p string) string {
+	dir := filepath.Dir(p)
+	name := UniqueName(filepath.Base(p), func(candidate string) bool {
+		_, err := os.Lstat(filepath.Join(dir, candidate))
+		return !os.IsNotExist(err)
+	})
+	return filepath.Join(dir, name)
+}
+
+// UniqueName returns the first "name (N).ext" variant of base for which taken
+// reports false, keeping extensions the way UniquePath does
+func UniqueName(base string, taken func(name string) bool) string {
+	ext := filepath.Ext(base)
+	for _, compound := range []string{".tar.gz", ".tar.xz", ".tar.zst", ".tar.bz2"} {
+		if strings.HasSuffix(strings.ToLower(base), compound) {
+			ext = base[len(base)-len(compound):]
+			break
+		}
+	}
+	stem := strings.TrimSuffix(base, ext)
+	if stem == "" {
+		// Dotfiles like ".env" have no stem; suffix the whole name
+		stem, ext = base, ""
+	}
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Rsync-style delta transfer. The receiver describes the file it already has
+// as a Signature (a weak rolling checksum and a strong hash per block); the
+// sender scans its version with the rolling checksum and emits a delta made
+// of block copies from the old file and literal data for everything else.
+// The delta ends with the SHA256 of the sender's file so the receiver can
+// verify the reconstruction.
+
+const (
+	MinDeltaBlockSize = 4 * 1024
+	MaxDeltaBlockSize = 1024 * 1024
+
+	strongSumSize = 16
+	// maxDeltaLiteral bounds the literal data carried by one delta op
+	maxDeltaLiteral = 1024 * 1024
+)
+
+// Delta stream ops
+const (
+	deltaOpCopy = 'C' // uint32 first block, uint32 block count
+	deltaOpData = 'D' // uint32 length, then the bytes
+	deltaOpEnd  = 'E' // SHA256 of the reconstructed file
+)
+
+var (
+	signatureMagic = []byte("KSIG")
+	deltaMagic     = []byte("KDLT")
+
+	// ErrDeltaMismatch means the reconstructed file does not match the hash
+	// sent by the source, usually because the base changed in the meantime
+	ErrDeltaMismatch = errors.New("delta result does not match source hash")
+)
+
+// BlockSignature identifies one block of the base file
+type BlockSignature struct {
+	Weak   uint32
+	Strong [strongSumSize]byte
+}
+
+// Signature describes a base file as a list of fixed-size blocks; the last
+// block may be shorter
+type Signature struct {
+	BlockSize int
+	Size      int64
+	Blocks    []BlockSignature
+}
+
+// DeltaBlockSize picks a block size for a file of size bytes: about the
+// square root of the size, which balances signature size against how much
+// data a single changed byte forces to be resent
+func DeltaBlockSize(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 1023) / 1024 * 1024
+	return min(max(bs, MinDeltaBlockSize), MaxDeltaBlockSize)
+}
+
+// rollingSum is the rsync weak checksum, which can be moved along the data
+// one byte at a time
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollingSum) init(p []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(p))
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += uint32(len(p)-i) * uint32(c)
+	}
+}
+
+// roll removes out from the front of the window and appends in
+func (r *rollingSum) roll(out, in byte) {
+	r.a = r.a - uint32(out) + uint32(in)
+	r.b = r.b - r.n*uint32(out) + r.a
+}
+
+func (r *rollingSum) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
+
+func weakSum(p []byte) uint32 {
+	var r rollingSum
+	r.init(p)
+	return r.sum()
+}
+
+func strongSum(p []byte) [strongSumSize]byte {
+	var s [strongSumSize]byte
+	h := sha256.Sum256(p)
+	copy(s[:], h[:])
+	return s
+}
+
+// ComputeSignature reads a base file of size bytes and returns its signature
+func ComputeSignature(r io.Reader, size int64, blockSize int) (*Signature, error) {
+	if blockSize < MinDeltaBlockSize || blockSize > MaxDeltaBlockSize {
+		return nil, fmt.Errorf("invalid delta block size %d", blockSize)
+	}
+
+	sig := &Signature{BlockSize: blockSize, Size: size}
+	buf := make([]byte, blockSize)
+	br := bufio.NewReaderSize(r, blockSize)
+	for remaining := size; remaining > 0; {
+		n := int(min(remaining, int64(blockSize)))
+		if _, err := io.ReadFull(br, buf[:n]); err != nil {
+			return nil, err
+		}
+		sig.Blocks = append(sig.Blocks, BlockSignature{Weak: weakSum(buf[:n]), Strong: strongSum(buf[:n])})
+		remaining -= int64(n)
+	}
+	return sig, nil
+}
+
+// WriteTo encodes the signature in its binary wire format
+func (s *Signature) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	bw.Write(signatureMagic)
+	binary.Write(bw, binary.BigEndian, uint32(s.BlockSize))
+	binary.Write(bw, binary.BigEndian, s.Size)
+	binary.Write(bw, binary.BigEndian, uint32(len(s.Blocks)))
+	for _, b := range s.Blocks {
+		binary.Write(bw, binary.BigEndian, b.Weak)
+		bw.Write(b.Strong[:])
+	}
+	n := int64(len(signatureMagic)+16) + int64(len(s.Blocks))*(4+strongSumSize)
+	return n, bw.Flush()
+}
+
+// ReadSignature decodes a signature written by WriteTo
+func ReadSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(signatureMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, signatureMagic) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var header struct {
+		BlockSize uint32
+		Size      int64
+		Count     uint32
+	}
+	if err := binary.Read(br, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	bs := int64(header.BlockSize)
+	if bs < MinDeltaBlockSize || bs > MaxDeltaBlockSize || header.Size < 0 ||
+		int64(header.Count) != (header.Size+bs-1)/bs {
+		return nil, errors.New("invalid signature header")
+	}
+
+	sig := &Signature{BlockSize: int(bs), Size: header.Size, Blocks: make([]BlockSignature, header.Count)}
+	for i := range sig.Blocks {
+		if err := binary.Read(br, binary.BigEndian, &sig.Blocks[i].Weak); err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+		if _, err := io.ReadFull(br, sig.Blocks[i].Strong[:]); err != nil {
+			return nil, fmt.Errorf("invalid signature: %w", err)
+		}
+	}
+	return sig, nil
+}
+
+// deltaEncoder writes delta ops, merging runs of consecutive block copies
+type deltaEncoder struct {
+	w          *bufio.Writer
+	copyStart  int
+	copyCount  int
+	literalOut int64 // Literal bytes written, for statistics
+}
+
+func (e *deltaEncoder) copyBlock(index int) error {
+	if e.copyCount > 0 && index == e.copyStart+e.copyCount {
+		e.copyCount++
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.copyStart, e.copyCount = index, 1
+	return nil
+}
+
+func (e *deltaEncoder) flushCopy() error {
+	if e.copyCount == 0 {
+		return nil
+	}
+	e.w.WriteByte(deltaOpCopy)
+	binary.Write(e.w, binary.BigEndian, uint32(e.copyStart))
+	err := binary.Write(e.w, binary.BigEndian, uint32(e.copyCount))
+	e.copyCount = 0
+	return err
+}
+
+func (e *deltaEncoder) literal(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if err := e.flushCopy(); err != nil {
+		return err
+	}
+	e.w.WriteByte(deltaOpData)
+	binary.Write(e.w, binary.BigEndian, uint32(len(p)))
+	_, err := e.w.Write(p)
+	e.literalOut += int64(len(p))
+	return err
+}
+
+// WriteDelta reads the source file from src and writes the delta that turns
+// the base described by sig into it. It returns the number of literal bytes
+// sent, the rest being copied from the base.
+func WriteDelta(w io.Writer, sig *Signature, src io.Reader) (int64, error) {
+	bs := sig.BlockSize
+	enc := &deltaEncoder{w: bufio.NewWriter(w)}
+	enc.w.Write(deltaMagic)
+	binary.Write(enc.w, binary.BigEndian, uint32(bs))
+
+	// Full blocks are found with the rolling checksum; a short last block
+	// can only match at the end of the source
+	index := make(map[uint32][]int, len(sig.Blocks))
+	last, lastLen := -1, 0
+	for i, b := range sig.Blocks {
+		if n := sig.Size - int64(i)*int64(bs); n < int64(bs) {
+			last, lastLen = i, int(n)
+			continue
+		}
+		index[b.Weak] = append(index[b.Weak], i)
+	}
+	match := func(cands []int, p []byte) int {
+		strong := strongSum(p)
+		for _, i := range cands {
+			if sig.Blocks[i].Strong == strong {
+				return i
+			}
+		}
+		return -1
+	}
+
+	hash := sha256.New()
+	src = io.TeeReader(src, hash)
+
+	buf := make([]byte, 0, maxDeltaLiteral+2*bs)
+	pos, lit := 0, 0 // Window start and start of pending literal data in buf
+	eof := false
+	var roll rollingSum
+	valid := false
+
+	for {
+		// Keep a full window plus the byte that rolls in next
+		if !eof && len(buf)-pos < bs+1 {
+			if err := enc.literal(buf[lit:pos]); err != nil {
+				return 0, err
+			}
+			n := copy(buf, buf[pos:])
+			buf, pos, lit = buf[:n], 0, 0
+			m, err := io.ReadFull(src, buf[n:cap(buf)])
+			buf = buf[:n+m]
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return 0, err
+			}
+		}
+		if len(buf)-pos < bs {
+			break
+		}
+
+		if !valid {
+			roll.init(buf[pos : pos+bs])
+			valid = true
+		}
+		if cands, ok := index[roll.sum()]; ok {
+			if i := match(cands, buf[pos:pos+bs]); i >= 0 {
+				if err := enc.literal(buf[lit:pos]); err != nil {
+					return 0, err
+				}
+				if err := enc.copyBlock(i); err != nil {
+					return 0, err
+				}
+				pos += bs
+				lit, valid = pos, false
+				continue
+			}
+		}
+
+		if pos-lit >= maxDeltaLiteral {
+			if err := enc.literal(buf[lit:pos]); err != nil {
+				return 0, err
+			}
+			lit = pos
+		}
+		if pos+bs >= len(buf) {
+			break // At the end of the source with an unmatched window
+		}
+		roll.roll(buf[pos], buf[pos+bs])
+		pos++
+	}
+
+	// Tail: try the short last block against the end of the source
+	end := len(buf)
+	if last >= 0 && end-lit >= lastLen && weakSum(buf[end-lastLen:]) == sig.Blocks[last].Weak &&
+		match([]int{last}, buf[end-lastLen:]) == last {
+		end -= lastLen
+	} else {
+		last = -1
+	}
+	if err := enc.literal(buf[lit:end]); err != nil {
+		return 0, err
+	}
+	if last >= 0 {
+		if err := enc.copyBlock(last); err != nil {
+			return 0, err
+		}
+	}
+	if err := enc.flushCopy(); err != nil {
+		return 0, err
+	}
+
+	enc.w.WriteByte(deltaOpEnd)
+	enc.w.Write(hash.Sum(nil))
+	return enc.literalOut, enc.w.Flush()
+}
+
+// ApplyDelta reconstructs the source file into out from a delta and the base
+// file it was computed against. It returns the bytes written, or
+// ErrDeltaMismatch if the result does not match the source hash.
+func ApplyDelta(out io.Writer, base io.ReaderAt, baseSize int64, delta io.Reader) (int64, error) {
+	r := bufio.NewReader(delta)
+
+	magic := make([]byte, len(deltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, deltaMagic) {
+		return 0, errors.New("invalid delta")
+	}
+	var blockSize uint32
+	if err := binary.Read(r, binary.BigEndian, &blockSize); err != nil {
+		return 0, fmt.Errorf("invalid delta: %w", err)
+	}
+	bs := int64(blockSize)
+	if bs < MinDeltaBlockSize || bs > MaxDeltaBlockSize {
+		return 0, errors.New("invalid delta block size")
+	}
+
+	hash := sha256.New()
+	w := io.MultiWriter(out, hash)
+	var written int64
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return written, fmt.Errorf("truncated delta: %w", err)
+		}
+
+		switch op {
+		case deltaOpCopy:
+			var run struct{ Start, Count uint32 }
+			if err := binary.Read(r, binary.BigEndian, &run); err != nil {
+				return written, fmt.Errorf("invalid delta: %w", err)
+			}
+			off := int64(run.Start) * bs
+			n := min(int64(run.Count)*bs, baseSize-off)
+			if run.Count == 0 || n <= 0 {
+				return written, errors.New("delta copies beyond the base file")
+			}
+			m, err := io.Copy(w, io.NewSectionReader(base, off, n))
+			written += m
+			if err != nil {
+				return written, err
+			}
+
+		case deltaOpData:
+			var n uint32
+			if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+				return written, fmt.Errorf("invalid delta: %w", err)
+			}
+			if n > maxDeltaLiteral+2*MaxDeltaBlockSize {
+				return written, errors.New("delta literal too large")
+			}
+			m, err := io.CopyN(w, r, int64(n))
+			written += m
+			if err != nil {
+				return written, fmt.Errorf("truncated delta: %w", err)
+			}
+
+		case deltaOpEnd:
+			sum := make([]byte, sha256.Size)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				return written, fmt.Errorf("truncated delta: %w", err)
+			}
+			if !bytes.Equal(sum, hash.Sum(nil)) {
+				return written, ErrDeltaMismatch
+			}
+			return written, nil
+
+		default:
+			return written, fmt.Errorf("invalid delta op %q", op)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings for file transfers, in order of preference
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// ContentEncodings lists the supported content encodings, preferred first
+var ContentEncodings = []string{EncodingZstd, EncodingGzip}
+
+// AcceptEncodings is the Accept-Encoding value naming ContentEncodings
+var AcceptEncodings = strings.Join(ContentEncodings, ", ")
+
+// MinEncodeSize is the smallest body worth compressing
+const MinEncodeSize = 1024
+
+// NegotiateEncoding returns the preferred supported encoding allowed by an
+// Accept-Encoding header, "" if it allows none of them
+func NegotiateEncoding(accept string) string {
+	allowed := make(map[string]bool)
+	wildcard := false
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		ok := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				ok = false
+			}
+		}
+		if name == "*" {
+			wildcard = ok
+		} else if name != "" {
+			allowed[name] = ok
+		}
+	}
+	for _, enc := range ContentEncodings {
+		if ok, listed := allowed[enc]; ok || (!listed && wildcard) {
+			return enc
+		}
+	}
+	return ""
+}
+
+// IsCompressibleType reports whether content of a MIME type is worth
+// compressing; media and archive formats are compressed already
+func IsCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	switch mediaType {
+	case "image/svg+xml", "image/bmp", "image/x-icon", "audio/wav", "audio/x-wav":
+		return true
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-lzip", "application/x-lz4",
+		"application/x-7z-compressed", "application/x-rar-compressed", "application/vnd.rar",
+		"application/java-archive", "application/vnd.android.package-archive",
+		"application/epub+zip", "application/pdf", "application/wasm", "font/woff", "font/woff2":
+		return false
+	}
+	for _, prefix := range []string{"image/", "video/", "audio/",
+		"application/vnd.openxmlformats-officedocument.", "application/vnd.oasis.opendocument."} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewEncoder returns a writer compressing to w with encoding; it must be
+// closed to complete the stream. Both encodings favour speed over ratio.
+func NewEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch strings.ToLower(encoding) {
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	case EncodingGzip:
+		return gzip.NewWriterLevel(w, gzip.BestSpeed)
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// NewDecoder returns a reader decompressing r, which was compressed with
+// encoding. Closing it does not close r.
+func NewDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(encoding) {
+	case EncodingZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
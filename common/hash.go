@@ -0,0 +1,58 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// Hash algorithms for the algo query parameter of the checksum action
+const (
+	HashSHA256 = "sha256" // Default
+	HashBLAKE3 = "blake3"
+	HashXXH3   = "xxh3" // 64 bit, fastest; detects corruption, not tampering
+	HashMD5    = "md5"
+	HashCRC32C = "crc32c"
+)
+
+// HashAlgorithms lists the supported hash algorithms
+var HashAlgorithms = []string{HashSHA256, HashBLAKE3, HashXXH3, HashMD5, HashCRC32C}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// NewHash returns a hash for algo; "" selects SHA256
+func NewHash(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "", HashSHA256:
+		return sha256.New(), nil
+	case HashBLAKE3:
+		return blake3.New(), nil
+	case HashXXH3:
+		return xxh3.New(), nil
+	case HashMD5:
+		return md5.New(), nil
+	case HashCRC32C:
+		return crc32.New(crc32cTable), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q", algo)
+}
+
+// HashReader returns the hex hash of everything read from r using algo
+func HashReader(r io.Reader, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
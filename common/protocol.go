@@ -2,11 +2,35 @@ package common
 
 const (
 	// HTTP Query parameters
-	QueryAction  = "action"
-	QueryPath    = "path"
-	QueryOld     = "old"
-	QueryNew     = "new"
+	QueryAction    = "action"
+	QueryPath      = "path"
+	QueryOld       = "old"
+	QueryNew       = "new"
 	QueryRecursive = "recursive"
+	QueryFields    = "fields"
+	QueryTarget    = "target"
+	QueryConflict  = "conflict"
+	QueryMode      = "mode"
+	QueryFileMode  = "fileMode" // chmod: mode for files only
+	QueryDirMode   = "dirMode"  // chmod: mode for directories only
+	QueryUser      = "user"
+	QueryGroup     = "group"
+	QueryDryRun    = "dryRun"
+	QueryTop       = "top"       // du: number of largest entries to return
+	QueryRefresh   = "refresh"   // du: ignore cached sizes
+	QueryID        = "id"        // Upload session id
+	QuerySize      = "size"      // upload-init: total file size
+	QueryHash      = "hash"      // upload-init: SHA256 of the whole file
+	QueryChunkSize = "chunkSize" // upload-init: requested chunk size
+	QueryIndex     = "index"     // upload-chunk: chunk number
+	QueryRestart   = "restart"   // upload-init: replace a session still in use
+	QueryBlockSize = "blockSize" // signature: delta block size
+	QueryRanges    = "ranges"    // range-hash: "start-end,..." byte ranges, end exclusive
+	QueryAlgo      = "algo"      // checksum: hash algorithm, see HashAlgorithms
+	QueryPaths     = "paths"     // compress, archive: comma separated paths
+	QueryFormat    = "format"    // compress, archive: tar, targz, tarzst or zip
+	QueryLines     = "lines"     // tail: number of last lines to return
+	QueryFollow    = "follow"    // tail: "1" keeps streaming appended data
 
 	// Action values
 	ActionList     = "list"
@@ -19,6 +43,64 @@ const (
 	ActionCompress = "compress"
 	ActionExtract  = "extract"
 	ActionEdit     = "edit"
+	ActionStat     = "stat"
+	ActionSymlink  = "symlink"
+	ActionHardlink = "hardlink"
+	ActionChmod    = "chmod"
+	ActionChown    = "chown"
+	ActionDu       = "du"
+
+	ActionUploadInit   = "upload-init"
+	ActionUploadChunk  = "upload-chunk"
+	ActionUploadStatus = "upload-status"
+	ActionUploadCommit = "upload-commit"
+	ActionUploadAbort  = "upload-abort"
+
+	ActionSignature     = "signature"
+	ActionDeltaUpload   = "delta-upload"
+	ActionDeltaDownload = "delta-download"
+
+	ActionSparseMap = "sparse-map"
+	ActionRangeHash = "range-hash"
+	ActionArchive   = "archive"
+	ActionTail      = "tail"
+	ActionBatch     = "batch"
+)
+
+// Optional metadata groups for the fields query parameter (comma separated)
+const (
+	FieldAll    = "all"    // Every optional field below
+	FieldLink   = "link"   // Symlink flag and target
+	FieldOwner  = "owner"  // uid/gid and resolved user/group names
+	FieldTimes  = "times"  // Access and change times
+	FieldInode  = "inode"  // Inode number and hard link count
+	FieldHidden = "hidden" // Hidden flag (dotfile or hidden attribute)
+	FieldMime   = "mime"   // Sniffed MIME type
+)
+
+// Archive formats for the format query parameter of compress and archive
+const (
+	FormatTar    = "tar"
+	FormatTarGz  = "targz"
+	FormatTarZst = "tarzst"
+	FormatZip    = "zip"
+)
+
+// HTTP headers
+const (
+	HeaderConflictResult = "X-Conflict-Result" // created, overwritten, skipped or renamed
+	HeaderFinalPath      = "X-Final-Path"      // Destination actually written (after rename)
+	HeaderSourceMtime    = "X-Source-Mtime"    // Source modification time (unix seconds)
+	HeaderSourceMode     = "X-Source-Mode"     // Source permission bits (octal)
+	HeaderFileMode       = "X-File-Mode"       // Permission bits of a downloaded file (octal)
+	HeaderChunkDigest    = "X-Chunk-Digest"    // Hex SHA256 of an upload request body
+	HeaderAutoExtract    = "X-Auto-Extract"    // "1": extract an uploaded archive instead of storing it
+	HeaderExtractDest    = "X-Extract-Dest"    // Server directory to extract into (default: the archive's)
+	HeaderExtractedFiles = "X-Extracted-Files" // Files written by an extraction
+	HeaderExtractedDirs  = "X-Extracted-Dirs"  // Directories created or merged by an extraction
+	HeaderSkippedEntries = "X-Skipped-Entries" // Archive entries skipped by the conflict policy
+	HeaderHashAlgorithm  = "X-Hash-Algorithm"  // Algorithm of a checksum response
+	HeaderArchiveSize    = "X-Archive-Size"    // Total size of the files in a streamed archive
 )
 
 // HTTP methods
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RangeHash is the hex SHA256 of the byte range [Start, End) of a file
+type RangeHash struct {
+	Extent
+	SHA256 string `json:"sha256"`
+}
+
+// RangeHashes is the response of the range-hash action
+type RangeHashes struct {
+	Path   string      `json:"path,omitempty"`
+	Size   int64       `json:"size"`
+	ETag   string      `json:"etag,omitempty"` // Version of the file the hashes belong to
+	Hashes []RangeHash `json:"hashes"`
+}
+
+// FormatRanges encodes ranges for the ranges query parameter as
+// comma separated "start-end" pairs with an exclusive end
+func FormatRanges(ranges []Extent) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprintf("%d-%d", r.Start, r.End)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseRanges decodes the ranges query parameter; every range must lie
+// within a file of size bytes
+func ParseRanges(s string, size int64) ([]Extent, error) {
+	var ranges []Extent
+	for _, part := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("bad range %q", part)
+		}
+		start, err1 := strconv.ParseInt(from, 10, 64)
+		end, err2 := strconv.ParseInt(to, 10, 64)
+		if err1 != nil || err2 != nil || start < 0 || end < start || end > size {
+			return nil, fmt.Errorf("bad range %q", part)
+		}
+		ranges = append(ranges, Extent{Start: start, End: end})
+	}
+	return ranges, nil
+}
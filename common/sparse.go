@@ -0,0 +1,55 @@
+package common
+
+import "os"
+
+// Extent is a byte range [Start, End) of a file that holds data
+type Extent struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// SparseMap describes which parts of a file hold data; everything outside
+// the Data extents is a hole that reads as zeros
+type SparseMap struct {
+	Path      string   `json:"path,omitempty"`
+	Size      int64    `json:"size"`
+	DataBytes int64    `json:"dataBytes"`
+	Data      []Extent `json:"data"`
+}
+
+// IsSparse reports whether the file has holes worth skipping
+func (m *SparseMap) IsSparse() bool {
+	return m.DataBytes < m.Size
+}
+
+// HasData reports whether any data extent overlaps [start, end)
+func (m *SparseMap) HasData(start, end int64) bool {
+	for _, e := range m.Data {
+		if e.Start < end && start < e.End {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSparseMap returns the data extents of an open file of the given size.
+// Where holes cannot be detected the whole file is reported as data.
+func ReadSparseMap(file *os.File, size int64) (*SparseMap, error) {
+	extents, err := dataExtents(file, size)
+	if err != nil {
+		return nil, err
+	}
+	m := &SparseMap{Size: size, Data: extents}
+	for _, e := range extents {
+		m.DataBytes += e.End - e.Start
+	}
+	return m, nil
+}
+
+// denseExtents reports a whole file of size bytes as data
+func denseExtents(size int64) []Extent {
+	if size == 0 {
+		return []Extent{}
+	}
+	return []Extent{{Start: 0, End: size}}
+}
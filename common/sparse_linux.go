@@ -0,0 +1,44 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lseek whence values from <unistd.h>, not exported by package syscall
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataExtents walks the file with SEEK_DATA/SEEK_HOLE
+func dataExtents(file *os.File, size int64) ([]Extent, error) {
+	defer file.Seek(0, io.SeekStart)
+
+	extents := []Extent{}
+	for off := int64(0); off < size; {
+		start, err := file.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // Only a hole remains
+		}
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return denseExtents(size), nil // Filesystem without hole support
+		}
+		if err != nil {
+			return nil, err
+		}
+		end, err := file.Seek(start, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, size)
+		if start >= end {
+			break
+		}
+		extents = append(extents, Extent{Start: start, End: end})
+		off = end
+	}
+	return extents, nil
+}
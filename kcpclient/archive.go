@@ -0,0 +1,84 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// errNoArchive is returned by servers without the archive action, which
+// answer with a directory listing instead
+var errNoArchive = errors.New("server does not support archive downloads")
+
+// DownloadArchive downloads one or more remote files or folders as a single
+// tar.zst stream and extracts it into localDir as it arrives, so neither side
+// stores the archive. Every path lands under its base name. Files extracted
+// before a failure are left in place.
+func (c *Client) DownloadArchive(remotePaths []string, localDir string, onProgress func(percent float64, speedMBps float64)) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	query := url.Values{}
+	query.Set(common.QueryAction, common.ActionArchive)
+	query.Set(common.QueryFormat, common.FormatTarZst)
+	for _, p := range remotePaths {
+		query.Add(common.QueryPath, p)
+	}
+
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s/?%s", c.serverAddr, query.Encode()))
+	if err != nil {
+		return fmt.Errorf("request archive: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "archive")
+	}
+	sizeHeader := resp.Header.Get(common.HeaderArchiveSize)
+	if sizeHeader == "" {
+		return errNoArchive
+	}
+	total, _ := strconv.ParseInt(sizeHeader, 10, 64)
+
+	var received atomic.Int64
+	zr, err := zstd.NewReader(&countingReader{r: resp.Body, n: &received})
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	defer zr.Close()
+
+	log.Printf("[DEBUG] Archive download: %v -> %s (%d bytes)", remotePaths, localDir, total)
+
+	startTime := time.Now()
+	err = common.ExtractTar(zr, localDir, func(done int64) {
+		if onProgress == nil {
+			return
+		}
+		percent := 1.0
+		if total > 0 {
+			percent = float64(done) / float64(total)
+		}
+		elapsed := time.Since(startTime).Seconds()
+		var speed float64
+		if elapsed > 0 {
+			speed = (float64(received.Load()) / (1024 * 1024)) / elapsed
+		}
+		onProgress(percent, speed)
+	})
+	if err != nil {
+		return fmt.Errorf("extract archive: %w", err)
+	}
+
+	log.Printf("[DEBUG] Archive download completed: %d bytes received for %d bytes of files", received.Load(), total)
+	return nil
+}
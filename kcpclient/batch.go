@@ -0,0 +1,302 @@
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	// batchFileLimit is the size below which folder files are fetched in
+	// batches; larger ones are downloaded on their own with parallel chunks
+	batchFileLimit = defaultChunkSize
+	// batchMaxBytes bounds the content of one batch request
+	batchMaxBytes = 32 * 1024 * 1024
+	// batchMaxFiles bounds the files of one batch request
+	batchMaxFiles = 1000
+)
+
+// errNoBatch is returned by servers without the batch action
+var errNoBatch = errors.New("server does not support batch downloads")
+
+// folderFile is a remote file of a folder download and its local path
+type folderFile struct {
+	remote string
+	local  string
+	size   int64
+}
+
+// DownloadFolder downloads the remote folder remotePath into localPath,
+// keeping its structure including empty folders. Small files are fetched
+// many at a time in streamed batch requests, several in parallel, and each
+// is checked against the checksum sent with it; larger files are downloaded
+// one by one with parallel chunks. Files whose local copy already has the
+// remote size and modification time are skipped, so running it again after
+// a failure continues where it stopped. Files a batch did not deliver
+// intact, and all files with servers that cannot batch, are fetched on
+// their own.
+func (c *Client) DownloadFolder(remotePath, localPath string, onProgress func(percent float64, speedMBps float64)) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	items, err := c.ListFiles(remotePath, true)
+	if err != nil {
+		return fmt.Errorf("list folder: %w", err)
+	}
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	base := strings.TrimSuffix(remotePath, "/")
+	var small, large []folderFile
+	var total int64
+	var bytesDone atomic.Int64
+	for _, item := range items {
+		local := filepath.Join(localPath, filepath.FromSlash(strings.TrimPrefix(item.Path, base)))
+		if item.IsDir {
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return fmt.Errorf("failed to create folder: %w", err)
+			}
+			continue
+		}
+		total += item.Size
+		if info, err := os.Stat(local); err == nil && info.Size() == item.Size && info.ModTime().Unix() == item.ModTime {
+			bytesDone.Add(item.Size)
+			continue
+		}
+		file := folderFile{remote: item.Path, local: local, size: item.Size}
+		if item.Size < batchFileLimit {
+			small = append(small, file)
+		} else {
+			large = append(large, file)
+		}
+	}
+	log.Printf("[DEBUG] Client.DownloadFolder: %s has %d small and %d large files to fetch", remotePath, len(small), len(large))
+
+	startTime := time.Now()
+	resumedBytes := bytesDone.Load()
+
+	// Progress reporter
+	progressDone := make(chan struct{})
+	defer close(progressDone)
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				done := bytesDone.Load()
+				if onProgress != nil && total > 0 {
+					percent := float64(done) / float64(total)
+					elapsed := time.Since(startTime).Seconds()
+					var speed float64
+					if elapsed > 0 {
+						speed = (float64(done-resumedBytes) / (1024 * 1024)) / elapsed
+					}
+					onProgress(percent, speed)
+				}
+			case <-progressDone:
+				return
+			}
+		}
+	}()
+
+	missed, err := c.downloadBatches(small, &bytesDone)
+	if errors.Is(err, errNoBatch) {
+		log.Printf("[DEBUG] Client.DownloadFolder: Server cannot batch, fetching files one by one")
+		missed, err = small, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Large files, and small ones a batch did not deliver intact
+	for _, file := range append(missed, large...) {
+		start := bytesDone.Load()
+		err := c.DownloadFile(file.remote, file.local, func(percent, _ float64) {
+			bytesDone.Store(start + int64(percent*float64(file.size)))
+		})
+		if err != nil {
+			return fmt.Errorf("download %s: %w", file.remote, err)
+		}
+		bytesDone.Store(start + file.size)
+	}
+	return nil
+}
+
+// downloadBatches fetches files in batches of at most batchMaxFiles files
+// and batchMaxBytes, and returns the files that did not arrive intact
+func (c *Client) downloadBatches(files []folderFile, bytesDone *atomic.Int64) ([]folderFile, error) {
+	var batches [][]folderFile
+	var batch []folderFile
+	var batchBytes int64
+	for _, file := range files {
+		if len(batch) > 0 && (len(batch) >= batchMaxFiles || batchBytes+file.size > batchMaxBytes) {
+			batches = append(batches, batch)
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, file)
+		batchBytes += file.size
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	indices := make([]int, len(batches))
+	for i := range indices {
+		indices[i] = i
+	}
+
+	var mu sync.Mutex
+	var missed []folderFile
+	sched := newTransferScheduler(bytesDone)
+	err := sched.run(indices, func(index int) error {
+		left, err := c.downloadBatch(batches[index], sched)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		missed = append(missed, left...)
+		mu.Unlock()
+		return nil
+	})
+	return missed, err
+}
+
+// downloadBatch fetches files with one batch request, moving each entry
+// whose checksum matches into place, and returns the files that did not
+// arrive intact. Bytes counted for a batch that fails are taken back, as
+// the scheduler retries it as a whole.
+func (c *Client) downloadBatch(files []folderFile, sched *transferScheduler) ([]folderFile, error) {
+	byName := make(map[string]folderFile, len(files))
+	req := common.BatchRequest{Paths: make([]string, 0, len(files))}
+	for _, file := range files {
+		byName[file.remote] = file
+		req.Paths = append(req.Paths, file.remote)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set(common.QueryAction, common.ActionBatch)
+	if c.checksumAlgo != "" {
+		query.Set(common.QueryAlgo, c.checksumAlgo)
+	}
+	httpReq, _ := http.NewRequest("POST", fmt.Sprintf("http://%s/?%s", c.serverAddr, query.Encode()), bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept-Encoding", common.AcceptEncodings)
+	resp, err := c.httpClient.Do(sched.traced(httpReq))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	algo := resp.Header.Get(common.HeaderHashAlgorithm)
+	if resp.StatusCode == http.StatusMethodNotAllowed || (resp.StatusCode == http.StatusOK && algo == "") {
+		return nil, errNoBatch
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "batch")
+	}
+	decoded, err := decodedBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer decoded.Close()
+
+	var added int64
+	received := make(map[string]bool, len(files))
+	tarReader := tar.NewReader(decoded)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sched.bytes.Add(-added)
+			return nil, fmt.Errorf("read batch: %w", err)
+		}
+		file, ok := byName[header.Name]
+		if !ok || received[header.Name] {
+			continue
+		}
+		n, ok, err := c.writeBatchEntry(file, header, tarReader, algo, sched.bytes)
+		added += n
+		if err != nil {
+			sched.bytes.Add(-added)
+			return nil, err
+		}
+		if ok {
+			received[header.Name] = true
+		} else {
+			// Fetched again on its own
+			sched.bytes.Add(-n)
+			added -= n
+		}
+	}
+
+	var missed []folderFile
+	for _, file := range files {
+		if !received[file.remote] {
+			missed = append(missed, file)
+		}
+	}
+	return missed, nil
+}
+
+// writeBatchEntry writes a batch entry to the partial file of file and moves
+// it into place if its checksum matches the one in its header. It returns
+// the bytes written and whether the file is complete; errors are those of
+// the stream or the local disk.
+func (c *Client) writeBatchEntry(file folderFile, header *tar.Header, r io.Reader, algo string, counter *atomic.Int64) (int64, bool, error) {
+	hash, err := common.NewHash(algo)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file.local), 0755); err != nil {
+		return 0, false, fmt.Errorf("failed to create folder: %w", err)
+	}
+	partPath := downloadPartPath(file.local)
+	out, err := os.Create(partPath)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create file: %w", err)
+	}
+	n, err := io.Copy(io.MultiWriter(&countingWriter{w: out, n: counter}, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return n, false, err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != header.PAXRecords[common.BatchHashRecord] {
+		log.Printf("[DEBUG] Client.downloadBatch: Checksum mismatch for %s", file.remote)
+		os.Remove(partPath)
+		return n, false, nil
+	}
+	if err := os.Rename(partPath, file.local); err != nil {
+		os.Remove(partPath)
+		return n, false, err
+	}
+	c.setFileMeta(file.local, os.FileMode(header.Mode), header.ModTime)
+	return n, true, nil
+}
@@ -1,11 +1,11 @@
 package client
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,6 +14,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,6 +33,15 @@ type Client struct {
 	session    *smux.Session
 	sessionMu  sync.Mutex
 	httpClient *http.Client
+
+	skipFileMeta bool         // Downloads keep local default mode and mtime
+	checksumAlgo string       // Algorithm downloads are verified with; "" is SHA256
+	limiter      *RateLimiter // Global limit, shared with task clients
+	parent       *Client      // Client a task client was derived from (see WithLimiter)
+
+	encodingMu      sync.Mutex
+	encodingKnown   bool   // The server was asked which request encodings it accepts
+	requestEncoding string // Encoding uploads are compressed with, "" for none
 }
 
 // ListItem represents a file or directory
@@ -41,6 +52,39 @@ type ListItem struct {
 	ModTime int64  `json:"modTime"`
 	IsDir   bool   `json:"isDir"`
 	Mode    string `json:"mode"`
+	ETag    string `json:"etag,omitempty"` // For Precondition; empty for symlinks
+	FileMeta
+}
+
+// FileMeta holds optional metadata returned when requested via fields
+// (see the common.Field* constants). Unrequested values are left zero.
+type FileMeta struct {
+	IsSymlink  bool    `json:"isSymlink,omitempty"`
+	LinkTarget string  `json:"linkTarget,omitempty"`
+	LinkIsDir  bool    `json:"linkIsDir,omitempty"`
+	UID        *uint32 `json:"uid,omitempty"`
+	GID        *uint32 `json:"gid,omitempty"`
+	Owner      string  `json:"owner,omitempty"`
+	Group      string  `json:"group,omitempty"`
+	AccessTime int64   `json:"atime,omitempty"`
+	ChangeTime int64   `json:"ctime,omitempty"`
+	Inode      uint64  `json:"inode,omitempty"`
+	Nlink      uint64  `json:"nlink,omitempty"`
+	Hidden     bool    `json:"hidden,omitempty"`
+	MimeType   string  `json:"mimeType,omitempty"`
+}
+
+// FileStat represents detailed information about a single remote path
+type FileStat struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	IsDir   bool   `json:"isDir"`
+	Mode    string `json:"mode"`
+	ModeNum uint32 `json:"modeNum"`
+	ETag    string `json:"etag,omitempty"`
+	FileMeta
 }
 
 const (
@@ -53,6 +97,7 @@ func NewClient(serverAddr, key string) *Client {
 	return &Client{
 		serverAddr: serverAddr,
 		key:        key,
+		limiter:    NewRateLimiter(0),
 	}
 }
 
@@ -156,7 +201,7 @@ func (c *Client) setupHTTPClient() {
 		return session.OpenStream()
 	}
 	c.httpClient = &http.Client{
-		Transport: &http.Transport{DialContext: dialer},
+		Transport: &throttledTransport{base: &http.Transport{DialContext: dialer}, limiter: c.limiter},
 		Timeout:   0, // No timeout for long transfers
 	}
 }
@@ -173,13 +218,18 @@ func (c *Client) Close() error {
 
 // IsConnected returns true if connected to server
 func (c *Client) IsConnected() bool {
+	if c.parent != nil {
+		return c.parent.IsConnected()
+	}
 	c.sessionMu.Lock()
 	defer c.sessionMu.Unlock()
 	return c.session != nil && !c.session.IsClosed()
 }
 
-// ListFiles lists files in the specified directory
-func (c *Client) ListFiles(relPath string, recursive bool) ([]ListItem, error) {
+// ListFiles lists files in the specified directory.
+// Optional metadata groups (common.FieldOwner, common.FieldMime, ...) can be
+// requested via fields; plain listings omit them to stay cheap.
+func (c *Client) ListFiles(relPath string, recursive bool, fields ...string) ([]ListItem, error) {
 	log.Printf("[DEBUG] Client.ListFiles: START relPath=%s recursive=%v", relPath, recursive)
 	if !c.IsConnected() {
 		log.Printf("[DEBUG] Client.ListFiles: Not connected")
@@ -193,6 +243,9 @@ func (c *Client) ListFiles(relPath string, recursive bool) ([]ListItem, error) {
 	if recursive {
 		q += "&recursive=1"
 	}
+	if len(fields) > 0 {
+		q += "&fields=" + url.QueryEscape(strings.Join(fields, ","))
+	}
 
 	url := fmt.Sprintf("http://%s/%s", c.serverAddr, q)
 	log.Printf("[DEBUG] Client.ListFiles: GET %s", url)
@@ -218,6 +271,36 @@ func (c *Client) ListFiles(relPath string, recursive bool) ([]ListItem, error) {
 	return files, nil
 }
 
+// Stat returns detailed information about a remote file or directory.
+// Optional metadata groups can be requested via fields as in ListFiles.
+func (c *Client) Stat(remotePath string, fields ...string) (*FileStat, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	q := "?action=stat&path=" + url.QueryEscape(remotePath)
+	if len(fields) > 0 {
+		q += "&fields=" + url.QueryEscape(strings.Join(fields, ","))
+	}
+
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s/%s", c.serverAddr, q))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("stat failed (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var stat FileStat
+	if err := json.NewDecoder(resp.Body).Decode(&stat); err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
 // progressReader wraps a reader to track progress
 type progressReader struct {
 	reader     io.Reader
@@ -239,46 +322,69 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 
 // UploadFile uploads a file to the server with multi-threading support
 func (c *Client) UploadFile(localPath, remotePath string, onProgress func(written int64, total int64)) error {
+	_, err := c.UploadFileWithPolicy(localPath, remotePath, "", onProgress)
+	return err
+}
+
+// UploadFileWithPolicy uploads a file, resolving an existing remote file with policy.
+// The local modification time is sent so that common.ConflictNewer can compare.
+// Files of 4MB or more are uploaded in chunks through an upload session; if an
+// earlier attempt for the same file was interrupted only the missing chunks are sent.
+// Chunks lying entirely in holes of a sparse file are not sent at all.
+func (c *Client) UploadFileWithPolicy(localPath, remotePath string, policy common.ConflictPolicy, onProgress func(written int64, total int64)) (*ConflictResult, error) {
+	return c.UploadFileIf(localPath, remotePath, Precondition{}, policy, onProgress)
+}
+
+// UploadFileIf uploads a file like UploadFileWithPolicy if pre holds for the
+// remote file. The server checks it before accepting data and again just
+// before replacing the file; a *PreconditionFailedError is returned if it fails.
+func (c *Client) UploadFileIf(localPath, remotePath string, pre Precondition, policy common.ConflictPolicy, onProgress func(written int64, total int64)) (*ConflictResult, error) {
 	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
+		return nil, fmt.Errorf("not connected")
 	}
 
 	// Get file size
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return nil, fmt.Errorf("open file: %w", err)
 	}
 	defer file.Close()
 
 	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("stat file: %w", err)
+		return nil, fmt.Errorf("stat file: %w", err)
 	}
 	fileSize := info.Size()
 
 	// For small files (< 4MB), use single-threaded upload
 	if fileSize < defaultChunkSize {
-		return c.uploadFileSingle(localPath, remotePath, onProgress)
+		return c.uploadFileSingle(localPath, remotePath, pre, policy, onProgress)
 	}
 
-	// Multi-threaded upload for larger files
-	return c.uploadFileParallel(localPath, remotePath, fileSize, onProgress)
+	// Larger files go through a resumable upload session
+	return c.uploadFileSession(localPath, remotePath, info, pre, policy, onProgress)
 }
 
 // uploadFileSingle uploads a file using single thread (for small files)
-func (c *Client) uploadFileSingle(localPath, remotePath string, onProgress func(written int64, total int64)) error {
+func (c *Client) uploadFileSingle(localPath, remotePath string, pre Precondition, policy common.ConflictPolicy, onProgress func(written int64, total int64)) (*ConflictResult, error) {
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return nil, fmt.Errorf("open file: %w", err)
 	}
 	defer file.Close()
 
 	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("stat file: %w", err)
+		return nil, fmt.Errorf("stat file: %w", err)
 	}
 	fileSize := info.Size()
 
+	// Let the server verify what it received
+	digest, err := calcFileChecksum(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("checksum file: %w", err)
+	}
+
 	// Wrap reader with progress tracking
 	pr := &progressReader{
 		reader:     file,
@@ -286,163 +392,52 @@ func (c *Client) uploadFileSingle(localPath, remotePath string, onProgress func(
 		onProgress: onProgress,
 	}
 
-	// Create request
-	url := fmt.Sprintf("http://%s?action=upload&path=%s", c.serverAddr, url.QueryEscape(remotePath))
-	req, err := http.NewRequest("PUT", url, pr)
-	if err != nil {
-		return err
-	}
-	req.ContentLength = fileSize
-
-	// Execute request
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("upload failed (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	return nil
-}
-
-// uploadFileParallel uploads a file using multiple parallel threads
-func (c *Client) uploadFileParallel(localPath, remotePath string, fileSize int64, onProgress func(written int64, total int64)) error {
-	const numWorkers = 8
-	chunkSize := (fileSize + int64(numWorkers) - 1) / int64(numWorkers)
-
-	// Align chunk size to defaultChunkSize (4MB) boundary
-	if chunkSize < defaultChunkSize {
-		chunkSize = defaultChunkSize
-	}
-
-	// Calculate number of chunks
-	numChunks := (fileSize + chunkSize - 1) / chunkSize
-
-	log.Printf("[DEBUG] Parallel upload: size=%d, chunks=%d, chunkSize=%d", fileSize, numChunks, chunkSize)
-
-	type chunkResult struct {
-		index int
-		err   error
-	}
-
-	results := make(chan chunkResult, numChunks)
-	var bytesDone atomic.Int64
-
-	// Progress reporter
-	var lastProgress int64
-	progressDone := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				done := bytesDone.Load()
-				if onProgress != nil && done != lastProgress {
-					onProgress(done, fileSize)
-					lastProgress = done
-				}
-			case <-progressDone:
-				return
-			}
-		}
-	}()
-
-	// Start workers
-	var wg sync.WaitGroup
-	for i := int64(0); i < numChunks; i++ {
-		wg.Add(1)
-		go func(chunkIndex int64) {
-			defer wg.Done()
-
-			start := chunkIndex * chunkSize
-			end := start + chunkSize
-			if end > fileSize {
-				end = fileSize
-			}
-
-			// Upload chunk
-			err := c.uploadChunk(localPath, remotePath, start, end, fileSize, chunkIndex)
-
-			if err == nil {
-				bytesDone.Add(end - start)
-			}
-
-			results <- chunkResult{index: int(chunkIndex), err: err}
-		}(i)
-	}
-
-	// Wait for all chunks
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect results
-	for result := range results {
-		if result.err != nil {
-			close(progressDone)
-			return fmt.Errorf("chunk %d failed: %w", result.index, result.err)
-		}
+	// Compress the body on the fly if the server accepts it; the digest
+	// still covers the file itself
+	var body io.Reader = pr
+	encoding := ""
+	if fileSize >= common.MinEncodeSize {
+		encoding = c.uploadEncoding(localPath)
 	}
-
-	close(progressDone)
-
-	// Final progress update
-	if onProgress != nil {
-		onProgress(fileSize, fileSize)
+	if encoding != "" {
+		body = encodeReader(pr, encoding)
 	}
 
-	return nil
-}
-
-// uploadChunk uploads a single chunk
-func (c *Client) uploadChunk(localPath, remotePath string, start, end, fileSize int64, chunkIndex int64) error {
-	file, err := os.Open(localPath)
+	// Create request
+	url := fmt.Sprintf("http://%s?action=upload&path=%s%s", c.serverAddr, url.QueryEscape(remotePath), conflictQuery(policy))
+	req, err := http.NewRequest("PUT", url, body)
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Seek to chunk position
-	if _, err := file.Seek(start, 0); err != nil {
-		return err
+		return nil, err
 	}
-
-	// Create limited reader for this chunk
-	chunkReader := io.LimitReader(file, end-start)
-
-	// Create request with Content-Range header
-	url := fmt.Sprintf("http://%s?action=upload&path=%s", c.serverAddr, url.QueryEscape(remotePath))
-	req, err := http.NewRequest("PUT", url, chunkReader)
-	if err != nil {
-		return err
+	setSourceMeta(req, info)
+	pre.apply(req)
+	req.Header.Set(common.HeaderChunkDigest, digest)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	} else {
+		req.ContentLength = fileSize
 	}
 
-	// Set Content-Range header for chunked upload
-	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, fileSize))
-	req.ContentLength = end - start
-
 	// Execute request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("chunk upload failed (status %d): %s", resp.StatusCode, string(body))
+		return nil, responseError(resp, "upload")
 	}
 
-	return nil
+	return conflictResult(resp, remotePath), nil
 }
 
-// DownloadFile downloads a file from the server with resume support and multi-threading
+// DownloadFile downloads a file from the server with resume support and multi-threading.
+// The server's modification time and mode are applied to the finished file
+// unless disabled with SetPreserveMetadata. If a parallel download fails, the
+// partial file and the chunks already received are kept, and calling
+// DownloadFile again fetches only the rest, provided the remote file has not
+// changed (see DiscardDownload).
 func (c *Client) DownloadFile(remotePath, localPath string, onProgress func(percent float64, speedMBps float64)) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("not connected")
@@ -456,19 +451,49 @@ func (c *Client) DownloadFile(remotePath, localPath string, onProgress func(perc
 		return fmt.Errorf("head request failed: %w", err)
 	}
 	headResp.Body.Close()
+	if headResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("head request failed (status %d)", headResp.StatusCode)
+	}
 
 	fileSize := headResp.ContentLength
-	if fileSize <= 0 {
+	if fileSize < 0 {
 		return fmt.Errorf("unknown file size")
 	}
 
-	// For small files (< 4MB), use single-threaded download
-	if fileSize < defaultChunkSize {
-		return c.downloadFileSingle(remotePath, localPath, onProgress)
+	// Empty files have no content to fetch; for small files (< 4MB), use
+	// single-threaded download; multi-threaded download for larger files,
+	// skipping the holes of sparse ones
+	if fileSize == 0 {
+		err = createEmptyFile(localPath)
+		if err == nil && onProgress != nil {
+			onProgress(1, 0)
+		}
+	} else if fileSize < defaultChunkSize {
+		err = c.downloadFileSingle(remotePath, localPath, onProgress)
+	} else if m, mapErr := c.SparseMap(remotePath); mapErr == nil && m.Size == fileSize && m.IsSparse() {
+		err = c.downloadFileSparse(remotePath, localPath, remoteVersionFromHeader(fileSize, headResp.Header), m, onProgress)
+	} else {
+		err = c.downloadFileParallel(remotePath, localPath, remoteVersionFromHeader(fileSize, headResp.Header), onProgress)
+	}
+	if err != nil {
+		return err
 	}
 
-	// Multi-threaded download for larger files
-	return c.downloadFileParallel(remotePath, localPath, fileSize, onProgress)
+	c.applyFileMeta(localPath, headResp.Header)
+	return nil
+}
+
+// createEmptyFile creates localPath as an empty file, replacing any older
+// local copy
+func createEmptyFile(localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	return file.Close()
 }
 
 // downloadFileSingle downloads a file using single thread (for small files)
@@ -517,6 +542,7 @@ func (c *Client) downloadFileSingle(remotePath, localPath string, onProgress fun
 	if startByte > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startByte))
 	}
+	req.Header.Set("Accept-Encoding", common.AcceptEncodings)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -535,12 +561,18 @@ func (c *Client) downloadFileSingle(remotePath, localPath string, onProgress fun
 		}
 	}
 
+	body, err := decodedBody(resp)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
 	// Copy with progress tracking
 	startTime := time.Now()
 	downloaded := startByte
 	buf := make([]byte, 32*1024)
 	for {
-		n, readErr := resp.Body.Read(buf)
+		n, readErr := body.Read(buf)
 		if n > 0 {
 			_, writeErr := file.Write(buf[:n])
 			if writeErr != nil {
@@ -568,42 +600,68 @@ func (c *Client) downloadFileSingle(remotePath, localPath string, onProgress fun
 	return c.verifyChecksum(remotePath, localPath)
 }
 
-// downloadFileParallel downloads a file using multiple parallel threads
-func (c *Client) downloadFileParallel(remotePath, localPath string, fileSize int64, onProgress func(percent float64, speedMBps float64)) error {
-	const maxWorkers = 8
-	chunkSize := (fileSize + int64(maxWorkers) - 1) / int64(maxWorkers)
-
-	// Align chunk size to defaultChunkSize (4MB) boundary for efficiency
-	if chunkSize < defaultChunkSize {
-		chunkSize = defaultChunkSize
-	}
-
-	// Calculate number of chunks needed
-	numChunks := (fileSize + chunkSize - 1) / chunkSize
-
-	log.Printf("[DEBUG] Parallel download: size=%d, chunks=%d, chunkSize=%d", fileSize, numChunks, chunkSize)
+// downloadFileParallel downloads a file using multiple parallel threads.
+// Workers write their ranges straight into a preallocated partial file next
+// to localPath, whose manifest records the chunks that are complete; an
+// earlier attempt for the same remote version is continued. The partial file
+// replaces localPath once its checksum is verified.
+func (c *Client) downloadFileParallel(remotePath, localPath string, version remoteVersion, onProgress func(percent float64, speedMBps float64)) error {
+	fileSize := version.Size
+	chunkSize := transferChunkSize(fileSize)
 
 	// Create output directory
 	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return fmt.Errorf("create directory: %w", err)
 	}
 
-	// Create temp directory for chunk files
-	tempDir := filepath.Dir(localPath) + "/.tmp_" + filepath.Base(localPath)
-	os.RemoveAll(tempDir) // Clean up any previous attempt
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return fmt.Errorf("create temp directory: %w", err)
+	// Continue an earlier attempt if the remote file is unchanged, otherwise
+	// start over with a fresh partial file
+	partPath := downloadPartPath(localPath)
+	manifest := loadDownloadManifest(localPath, remotePath, version, false)
+	var file *os.File
+	var err error
+	if manifest != nil {
+		chunkSize = manifest.ChunkSize
+		file, err = os.OpenFile(partPath, os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("open partial file: %w", err)
+		}
+	} else {
+		c.DiscardDownload(localPath)
+		file, err = os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("create partial file: %w", err)
+		}
+		if err := preallocate(file, fileSize); err != nil {
+			file.Close()
+			return fmt.Errorf("preallocate file: %w", err)
+		}
+		manifest = newDownloadManifest(localPath, remotePath, version, chunkSize)
+		if err := manifest.save(); err != nil {
+			file.Close()
+			return fmt.Errorf("save manifest: %w", err)
+		}
 	}
-	defer os.RemoveAll(tempDir)
+	defer file.Close()
 
-	type chunkResult struct {
-		index int
-		err   error
-	}
+	// Calculate number of chunks needed
+	numChunks := (fileSize + chunkSize - 1) / chunkSize
 
-	results := make(chan chunkResult, numChunks)
+	// Chunks completed by an earlier attempt count as done
 	var bytesDone atomic.Int64
+	var pending []int
+	for i := int64(0); i < numChunks; i++ {
+		if manifest.has(i) {
+			bytesDone.Add(min(chunkSize, fileSize-i*chunkSize))
+		} else {
+			pending = append(pending, int(i))
+		}
+	}
+
+	log.Printf("[DEBUG] Parallel download: size=%d, chunks=%d, chunkSize=%d, resumed=%d", fileSize, numChunks, chunkSize, numChunks-int64(len(pending)))
+
 	startTime := time.Now()
+	resumedBytes := bytesDone.Load()
 
 	// Progress reporter
 	progressDone := make(chan struct{})
@@ -619,7 +677,7 @@ func (c *Client) downloadFileParallel(remotePath, localPath string, fileSize int
 					elapsed := time.Since(startTime).Seconds()
 					var speed float64
 					if elapsed > 0 {
-						speed = (float64(done) / (1024 * 1024)) / elapsed
+						speed = (float64(done-resumedBytes) / (1024 * 1024)) / elapsed
 					}
 					onProgress(percent, speed)
 				}
@@ -629,127 +687,151 @@ func (c *Client) downloadFileParallel(remotePath, localPath string, fileSize int
 		}
 	}()
 
-	// Limit concurrent workers
-	semaphore := make(chan struct{}, maxWorkers)
-	var wg sync.WaitGroup
-
-	// Download chunks to separate temp files
-	for i := int64(0); i < numChunks; i++ {
-		wg.Add(1)
-		go func(chunkIndex int64) {
-			defer wg.Done()
-
-			// Acquire semaphore slot
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
-			start := chunkIndex * chunkSize
-			end := start + chunkSize
-			if end > fileSize {
-				end = fileSize
-			}
-
-			// Download chunk to separate temp file
-			chunkFile := fmt.Sprintf("%s/chunk_%04d.tmp", tempDir, chunkIndex)
-			err := c.downloadChunkToFile(remotePath, chunkFile, start, end)
-
-			if err == nil {
-				bytesDone.Add(end - start)
-			}
-
-			results <- chunkResult{index: int(chunkIndex), err: err}
-		}(i)
-	}
-
-	// Wait for all chunks
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect results
-	for result := range results {
-		if result.err != nil {
-			close(progressDone)
-			return fmt.Errorf("chunk %d failed: %w", result.index, result.err)
+	// Download chunks into their place in the partial file, checking each
+	// against the server's hash; chunks still running when one fails for
+	// good finish and are recorded for the next attempt
+	sched := newTransferScheduler(&bytesDone)
+	var verify atomic.Bool
+	verify.Store(true)
+	err = sched.run(pending, func(index int) error {
+		start := int64(index) * chunkSize
+		end := min(start+chunkSize, fileSize)
+
+		// Record the chunk only once it is on disk
+		if err := c.downloadVerifiedRange(remotePath, version.ETag, file, start, end, sched, &verify); err != nil {
+			return err
 		}
-	}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		return manifest.complete(int64(index))
+	})
 
 	close(progressDone)
-
-	// Merge chunk files into final file
-	if err := c.mergeChunks(tempDir, localPath, numChunks); err != nil {
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
-	// Verify checksum
-	return c.verifyChecksum(remotePath, localPath)
+	// Chunks that still differ from the server's are fetched once more; a
+	// result that stays corrupt must not be resumed either
+	if err := c.verifyChecksum(remotePath, partPath); err != nil {
+		if repairErr := c.repairDownload(remotePath, partPath, version, chunkSize); repairErr != nil {
+			log.Printf("[DEBUG] Repair of %s failed: %v", localPath, repairErr)
+			c.DiscardDownload(localPath)
+			return err
+		}
+		if err := c.verifyChecksum(remotePath, partPath); err != nil {
+			c.DiscardDownload(localPath)
+			return err
+		}
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("rename partial file: %w", err)
+	}
+	os.Remove(downloadManifestPath(localPath))
+	return nil
 }
 
-// downloadChunkToFile downloads a chunk to a separate temp file
-func (c *Client) downloadChunkToFile(remotePath, chunkFile string, start, end int64) error {
+// downloadRangeAt downloads bytes [start, end) of a remote file into file at
+// the same offset, adding the bytes to the scheduler's count as they arrive,
+// and returns their hex SHA256. If etag is set the server refuses the range
+// once the file has changed.
+func (c *Client) downloadRangeAt(remotePath, etag string, file *os.File, start, end int64, sched *transferScheduler) (string, error) {
 	url := fmt.Sprintf("http://%s%s", c.serverAddr, remotePath)
 
 	req, _ := http.NewRequest("GET", url, nil)
 	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	// Each range is compressed on its own, so it can be decoded alone
+	req.Header.Set("Accept-Encoding", common.AcceptEncodings)
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.httpClient.Do(sched.traced(req))
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", errRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("chunk download failed (status %d): %s", resp.StatusCode, string(bodyBytes))
+		return "", fmt.Errorf("range download failed (status %d): %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	// Create chunk file
-	file, err := os.Create(chunkFile)
+	body, err := decodedBody(resp)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer file.Close()
-
-	// Write chunk data
-	_, err = io.Copy(file, resp.Body)
-	return err
-}
+	defer body.Close()
 
-// mergeChunks merges chunk temp files into the final file
-func (c *Client) mergeChunks(tempDir, finalFile string, numChunks int64) error {
-	// Create final file
-	outFile, err := os.Create(finalFile)
+	hash := sha256.New()
+	out := io.MultiWriter(&countingWriter{w: io.NewOffsetWriter(file, start), n: sched.bytes}, hash)
+	n, err := io.Copy(out, io.LimitReader(body, end-start))
+	if err == nil && n != end-start {
+		err = fmt.Errorf("short read: %d of %d bytes", n, end-start)
+	}
 	if err != nil {
-		return fmt.Errorf("create final file: %w", err)
+		// The range will be fetched again as a whole
+		sched.bytes.Add(-n)
+		return "", err
 	}
-	defer outFile.Close()
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-	// Merge chunks in order
-	for i := int64(0); i < numChunks; i++ {
-		chunkFile := fmt.Sprintf("%s/chunk_%04d.tmp", tempDir, i)
-		data, err := os.ReadFile(chunkFile)
-		if err != nil {
-			outFile.Close()
-			os.Remove(finalFile)
-			return fmt.Errorf("read chunk %d: %w", i, err)
-		}
+// countingWriter adds the bytes written through it to a shared counter
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
 
-		if _, err := outFile.Write(data); err != nil {
-			outFile.Close()
-			os.Remove(finalFile)
-			return fmt.Errorf("write chunk %d: %w", i, err)
-		}
-	}
+func (p *countingWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n.Add(int64(n))
+	return n, err
+}
 
+// countingReader adds the bytes read through it to a shared counter and
+// keeps its own count
+type countingReader struct {
+	r    io.Reader
+	n    *atomic.Int64
+	read atomic.Int64
+}
+
+func (p *countingReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n.Add(int64(n))
+	p.read.Add(int64(n))
+	return n, err
+}
+
+// SetChecksumAlgorithm selects the hash downloads are verified with, one of
+// common.HashAlgorithms. A fast non-cryptographic hash such as xxh3 still
+// catches transfer errors while costing far less CPU on large files.
+func (c *Client) SetChecksumAlgorithm(algo string) error {
+	algo = strings.ToLower(algo)
+	if _, err := common.NewHash(algo); err != nil {
+		return err
+	}
+	c.checksumAlgo = algo
 	return nil
 }
 
-// verifyChecksum verifies file integrity using SHA256
+// verifyChecksum verifies file integrity with the configured checksum
+// algorithm. Servers that do not name the algorithm they used only know SHA256.
 func (c *Client) verifyChecksum(remotePath, localPath string) error {
 	// Get remote checksum
-	url := fmt.Sprintf("http://%s%s?action=checksum", c.serverAddr, remotePath)
-	resp, err := c.httpClient.Get(url)
+	checksumURL := fmt.Sprintf("http://%s%s?action=checksum", c.serverAddr, remotePath)
+	if c.checksumAlgo != "" {
+		checksumURL += "&algo=" + url.QueryEscape(c.checksumAlgo)
+	}
+	resp, err := c.httpClient.Get(checksumURL)
 	if err != nil {
 		return fmt.Errorf("get remote checksum: %w", err)
 	}
@@ -759,15 +841,28 @@ func (c *Client) verifyChecksum(remotePath, localPath string) error {
 	if err != nil {
 		return fmt.Errorf("read remote checksum: %w", err)
 	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get remote checksum: %s", strings.TrimSpace(string(remoteHash)))
+	}
+
+	algo := resp.Header.Get(common.HeaderHashAlgorithm)
+	if algo == "" {
+		algo = common.HashSHA256
+	}
 
 	// Calculate local checksum
-	localHash, err := calcFileChecksum(localPath)
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("calculate local checksum: %w", err)
+	}
+	defer file.Close()
+	localHash, err := common.HashReader(file, algo)
 	if err != nil {
 		return fmt.Errorf("calculate local checksum: %w", err)
 	}
 
 	if string(remoteHash) != localHash {
-		return fmt.Errorf("checksum mismatch")
+		return fmt.Errorf("%s checksum mismatch", algo)
 	}
 
 	return nil
@@ -775,28 +870,7 @@ func (c *Client) verifyChecksum(remotePath, localPath string) error {
 
 // DeleteFile deletes a file or directory on the server
 func (c *Client) DeleteFile(path string) error {
-	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
-	}
-
-	url := fmt.Sprintf("http://%s?action=delete&path=%s", c.serverAddr, url.QueryEscape(path))
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return err
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("delete failed (status %d): %s", resp.StatusCode, string(body))
-	}
-
-	return nil
+	return c.DeleteFileIf(path, Precondition{})
 }
 
 // CreateDirectory creates a directory on the server
@@ -822,44 +896,56 @@ func (c *Client) CreateDirectory(path string) error {
 
 // RenameFile renames a file or directory on the server
 func (c *Client) RenameFile(oldPath, newPath string) error {
+	_, err := c.RenameFileWithPolicy(oldPath, newPath, "")
+	return err
+}
+
+// RenameFileWithPolicy renames a file or directory, resolving an existing
+// destination with policy. Under common.ConflictFail a *ConflictError is returned.
+func (c *Client) RenameFileWithPolicy(oldPath, newPath string, policy common.ConflictPolicy) (*ConflictResult, error) {
 	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
+		return nil, fmt.Errorf("not connected")
 	}
 
-	url := fmt.Sprintf("http://%s?action=rename&old=%s&new=%s", c.serverAddr, url.QueryEscape(oldPath), url.QueryEscape(newPath))
+	url := fmt.Sprintf("http://%s?action=rename&old=%s&new=%s%s", c.serverAddr, url.QueryEscape(oldPath), url.QueryEscape(newPath), conflictQuery(policy))
 	resp, err := c.httpClient.Post(url, "application/json", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("rename failed (status %d): %s", resp.StatusCode, string(body))
+		return nil, responseError(resp, "rename")
 	}
 
-	return nil
+	return conflictResult(resp, newPath), nil
 }
 
 // CopyFile copies a file or directory on the server
 func (c *Client) CopyFile(srcPath, dstPath string) error {
+	_, err := c.CopyFileWithPolicy(srcPath, dstPath, "")
+	return err
+}
+
+// CopyFileWithPolicy copies a file or directory, resolving an existing
+// destination with policy. Directories are merged entry by entry.
+func (c *Client) CopyFileWithPolicy(srcPath, dstPath string, policy common.ConflictPolicy) (*ConflictResult, error) {
 	if !c.IsConnected() {
-		return fmt.Errorf("not connected")
+		return nil, fmt.Errorf("not connected")
 	}
 
-	url := fmt.Sprintf("http://%s?action=copy&src=%s&dst=%s", c.serverAddr, url.QueryEscape(srcPath), url.QueryEscape(dstPath))
+	url := fmt.Sprintf("http://%s?action=copy&src=%s&dst=%s%s", c.serverAddr, url.QueryEscape(srcPath), url.QueryEscape(dstPath), conflictQuery(policy))
 	resp, err := c.httpClient.Post(url, "application/json", nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("copy failed (status %d): %s", resp.StatusCode, string(body))
+		return nil, responseError(resp, "copy")
 	}
 
-	return nil
+	return conflictResult(resp, dstPath), nil
 }
 
 // MoveFile moves a file or directory on the server (alias for RenameFile)
@@ -867,59 +953,67 @@ func (c *Client) MoveFile(srcPath, dstPath string) error {
 	return c.RenameFile(srcPath, dstPath)
 }
 
-// ReadFile reads a text file from the server
-func (c *Client) ReadFile(path string) (string, error) {
+// MoveFileWithPolicy moves a file or directory (alias for RenameFileWithPolicy)
+func (c *Client) MoveFileWithPolicy(srcPath, dstPath string, policy common.ConflictPolicy) (*ConflictResult, error) {
+	return c.RenameFileWithPolicy(srcPath, dstPath, policy)
+}
+
+// Symlink creates a symbolic link at linkPath pointing to target.
+// target may be relative to the link's directory or an absolute server path;
+// the server rejects targets that resolve outside its root.
+func (c *Client) Symlink(target, linkPath string) error {
 	if !c.IsConnected() {
-		return "", fmt.Errorf("not connected")
+		return fmt.Errorf("not connected")
 	}
 
-	url := fmt.Sprintf("http://%s?action=edit&path=%s", c.serverAddr, url.QueryEscape(path))
-	resp, err := c.httpClient.Get(url)
+	url := fmt.Sprintf("http://%s?action=symlink&path=%s&target=%s", c.serverAddr, url.QueryEscape(linkPath), url.QueryEscape(target))
+	resp, err := c.httpClient.Post(url, "application/json", nil)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("read failed (status %d): %s", resp.StatusCode, string(body))
+		return fmt.Errorf("symlink failed (status %d): %s", resp.StatusCode, string(body))
 	}
 
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	return string(content), nil
+	return nil
 }
 
-// SaveFile saves content to a text file on the server
-func (c *Client) SaveFile(path string, content string) error {
+// Hardlink creates a hard link at linkPath to the existing file target
+func (c *Client) Hardlink(target, linkPath string) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("not connected")
 	}
 
-	url := fmt.Sprintf("http://%s?action=edit&path=%s", c.serverAddr, url.QueryEscape(path))
-	req, err := http.NewRequest("PUT", url, bytes.NewReader([]byte(content)))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
-
-	resp, err := c.httpClient.Do(req)
+	url := fmt.Sprintf("http://%s?action=hardlink&path=%s&target=%s", c.serverAddr, url.QueryEscape(linkPath), url.QueryEscape(target))
+	resp, err := c.httpClient.Post(url, "application/json", nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("save failed (status %d): %s", resp.StatusCode, string(body))
+		return fmt.Errorf("hardlink failed (status %d): %s", resp.StatusCode, string(body))
 	}
 
 	return nil
 }
 
+// ReadFile reads a text file from the server
+func (c *Client) ReadFile(path string) (string, error) {
+	content, _, err := c.ReadFileETag(path)
+	return content, err
+}
+
+// SaveFile saves content to a text file on the server
+func (c *Client) SaveFile(path string, content string) error {
+	_, err := c.SaveFileIf(path, content, Precondition{})
+	return err
+}
+
 // Compress compresses files/folders on the server
 func (c *Client) Compress(paths []string, outputPath, format string) error {
 	if !c.IsConnected() {
@@ -952,6 +1046,12 @@ func (c *Client) Compress(paths []string, outputPath, format string) error {
 
 // Extract extracts an archive on the server
 func (c *Client) Extract(archivePath, destPath string) error {
+	return c.ExtractWithPolicy(archivePath, destPath, "")
+}
+
+// ExtractWithPolicy extracts an archive, resolving existing files with policy.
+// Under common.ConflictFail nothing is extracted if any entry exists.
+func (c *Client) ExtractWithPolicy(archivePath, destPath string, policy common.ConflictPolicy) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("not connected")
 	}
@@ -961,8 +1061,8 @@ func (c *Client) Extract(archivePath, destPath string) error {
 		dest = archivePath[:len(archivePath)-len(filepath.Ext(archivePath))]
 	}
 
-	url := fmt.Sprintf("http://%s?action=extract&path=%s&dest=%s",
-		c.serverAddr, url.QueryEscape(archivePath), url.QueryEscape(dest))
+	url := fmt.Sprintf("http://%s?action=extract&path=%s&dest=%s%s",
+		c.serverAddr, url.QueryEscape(archivePath), url.QueryEscape(dest), conflictQuery(policy))
 	resp, err := c.httpClient.Post(url, "application/json", nil)
 	if err != nil {
 		return err
@@ -970,8 +1070,7 @@ func (c *Client) Extract(archivePath, destPath string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("extract failed (status %d): %s", resp.StatusCode, string(body))
+		return responseError(resp, "extract")
 	}
 
 	return nil
@@ -1010,9 +1109,16 @@ func DefaultPackTransferConfig() PackTransferConfig {
 // UploadFilePacked uploads a file or folder with optional compression
 // If pack transfer is enabled and path is a folder or large file, it will be compressed first
 func (c *Client) UploadFilePacked(localPath, remotePath string, config PackTransferConfig, onProgress func(written int64, total int64)) error {
+	return c.UploadFilePackedWithPolicy(localPath, remotePath, config, "", onProgress)
+}
+
+// UploadFilePackedWithPolicy is UploadFilePacked with a conflict policy. For
+// packed uploads the policy is applied to each extracted entry on the server.
+func (c *Client) UploadFilePackedWithPolicy(localPath, remotePath string, config PackTransferConfig, policy common.ConflictPolicy, onProgress func(written int64, total int64)) error {
 	// Check if pack transfer is enabled
 	if !config.Enabled {
-		return c.UploadFile(localPath, remotePath, onProgress)
+		_, err := c.UploadFileWithPolicy(localPath, remotePath, policy, onProgress)
+		return err
 	}
 
 	// Check if it's a directory
@@ -1032,85 +1138,146 @@ func (c *Client) UploadFilePacked(localPath, remotePath string, config PackTrans
 	}
 
 	if shouldCompress {
-		log.Printf("[DEBUG] Pack transfer enabled, compressing before upload: %s", localPath)
-
-		// Create temporary tar.gz file in system temp directory for security
-		tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("pack_upload_%d.tar.gz", time.Now().UnixNano()))
+		log.Printf("[DEBUG] Pack transfer enabled, streaming compressed upload: %s", localPath)
 
-		// Compress
-		if err := common.CompressToTarGz(localPath, tempFile); err != nil {
-			return fmt.Errorf("compress before upload: %w", err)
-		}
-
-		// Upload compressed file to server with .tar.gz extension
-		remotePathPacked := remotePath + ".tar.gz"
-
-		// Upload with auto-extract header
 		if !c.IsConnected() {
-			os.Remove(tempFile) // Cleanup on early return
 			return fmt.Errorf("not connected")
 		}
 
-		file, err := os.Open(tempFile)
+		// Progress is measured in source bytes, since the compressed size is
+		// not known until the stream ends
+		total, err := localSize(localPath)
 		if err != nil {
-			os.Remove(tempFile) // Cleanup on early return
-			return fmt.Errorf("open compressed file: %w", err)
+			return fmt.Errorf("stat path: %w", err)
 		}
 
-		// Use a function to ensure proper cleanup order:
-		// 1. Close file handle first
-		// 2. Then delete the file
-		uploadAndCleanup := func() error {
-			defer func() {
-				file.Close()        // Close file handle first
-				os.Remove(tempFile) // Then remove temp file
-				log.Printf("[DEBUG] Pack upload temp file cleaned up: %s", tempFile)
-			}()
-
-			fileInfo, _ := file.Stat()
-			fileSize := fileInfo.Size()
-
-			// Wrap reader with progress tracking
-			pr := &progressReader{
-				reader:     file,
-				total:      fileSize,
-				onProgress: onProgress,
-			}
-
-			// Create request with auto-extract header
-			url := fmt.Sprintf("http://%s?action=upload&path=%s", c.serverAddr, url.QueryEscape(remotePathPacked))
-			req, err := http.NewRequest("PUT", url, pr)
-			if err != nil {
-				return err
-			}
-			req.Header.Set("X-Auto-Extract", "1") // Tell server to auto-extract
-			req.ContentLength = fileSize
-
-			// Execute request
-			resp, err := c.httpClient.Do(req)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
+		// Compress into a pipe while the request reads from it, so nothing
+		// is written to disk and the upload starts immediately
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(common.WriteTarGz(pw, localPath, func(done int64) {
+				if onProgress != nil {
+					onProgress(done, total)
+				}
+			}))
+		}()
 
-			if resp.StatusCode != http.StatusOK {
-				body, _ := io.ReadAll(resp.Body)
-				return fmt.Errorf("upload failed (status %d): %s", resp.StatusCode, string(body))
-			}
+		// Upload compressed stream to server with .tar.gz extension
+		remotePathPacked := remotePath + ".tar.gz"
 
-			return nil
+		// Create request with auto-extract header; the length is unknown so
+		// the body is sent chunked
+		url := fmt.Sprintf("http://%s?action=upload&path=%s%s", c.serverAddr, url.QueryEscape(remotePathPacked), conflictQuery(policy))
+		req, err := http.NewRequest("PUT", url, pr)
+		if err != nil {
+			pr.Close()
+			return err
 		}
+		req.Header.Set(common.HeaderAutoExtract, "1") // Tell server to auto-extract
 
-		if err := uploadAndCleanup(); err != nil {
+		// Execute request
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			pr.CloseWithError(err)
 			return err
 		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return responseError(resp, "upload")
+		}
 
-		log.Printf("[DEBUG] Pack transfer upload completed: %s -> %s", localPath, remotePath)
+		extracted := extractResult(resp)
+		log.Printf("[DEBUG] Pack transfer upload completed: %s -> %s (%d files, %d directories)", localPath, remotePath, extracted.Files, extracted.Dirs)
 		return nil
 	}
 
 	// No compression needed, use regular upload
-	return c.UploadFile(localPath, remotePath, onProgress)
+	_, err = c.UploadFileWithPolicy(localPath, remotePath, policy, onProgress)
+	return err
+}
+
+// ExtractResult counts what the server extracted from an uploaded archive
+type ExtractResult struct {
+	Files   int // Files written
+	Dirs    int // Directories created or merged
+	Skipped int // Entries skipped by the conflict policy or of unsupported types
+}
+
+// extractResult reads the extraction counts from a response
+func extractResult(resp *http.Response) *ExtractResult {
+	var result ExtractResult
+	result.Files, _ = strconv.Atoi(resp.Header.Get(common.HeaderExtractedFiles))
+	result.Dirs, _ = strconv.Atoi(resp.Header.Get(common.HeaderExtractedDirs))
+	result.Skipped, _ = strconv.Atoi(resp.Header.Get(common.HeaderSkippedEntries))
+	return &result
+}
+
+// UploadArchive uploads a local zip, tar, tar.gz, tar.zst or tar.xz archive
+// and has the server extract it into destDir instead of storing it. An empty
+// destDir extracts next to remotePath. The policy applies to each extracted
+// entry. Tar archives are extracted while they arrive unless the policy is
+// common.ConflictFail or the server has upload rules, which are checked on
+// every entry before any is written.
+func (c *Client) UploadArchive(localPath, remotePath, destDir string, policy common.ConflictPolicy, onProgress func(written int64, total int64)) (*ExtractResult, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat file: %w", err)
+	}
+
+	pr := &progressReader{
+		reader:     file,
+		total:      info.Size(),
+		onProgress: onProgress,
+	}
+
+	url := fmt.Sprintf("http://%s?action=upload&path=%s%s", c.serverAddr, url.QueryEscape(remotePath), conflictQuery(policy))
+	req, err := http.NewRequest("PUT", url, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(common.HeaderAutoExtract, "1")
+	if destDir != "" {
+		req.Header.Set(common.HeaderExtractDest, destDir)
+	}
+	req.ContentLength = info.Size()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "upload")
+	}
+	return extractResult(resp), nil
+}
+
+// localSize returns the size of a file or the total size of the regular
+// files in a folder
+func localSize(path string) (int64, error) {
+	var total int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
 }
 
 // DownloadFilePacked downloads a file or folder with optional server-side compression
@@ -1158,34 +1325,8 @@ func (c *Client) DownloadFilePacked(remotePath, localPath string, config PackTra
 	}
 
 	if shouldCompress {
-		log.Printf("[DEBUG] Pack transfer enabled, requesting server compression: %s", remotePath)
-
-		// Request server to compress the file/folder
-		// We'll use the compress action to create a tar.gz on the server
-		compressURL := fmt.Sprintf("http://%s?action=compress&paths=%s&output=%s.tar.gz&format=targz",
-			c.serverAddr, url.QueryEscape(remotePath), url.QueryEscape(remotePath))
-
-		// Use POST for compress action
-		resp, err := c.httpClient.Post(compressURL, "application/json", nil)
-		if err != nil {
-			return fmt.Errorf("request compression: %w", err)
-		}
-		resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			// Compression failed, fall back to regular download
-			log.Printf("[DEBUG] Server compression failed, falling back to regular download")
-			return c.DownloadFile(remotePath, localPath, onProgress)
-		}
+		log.Printf("[DEBUG] Pack transfer enabled, requesting archive stream: %s", remotePath)
 
-		// Download the compressed file to system temp directory
-		tempTarGz := filepath.Join(os.TempDir(), fmt.Sprintf("pack_download_%d.tar.gz", time.Now().UnixNano()))
-
-		if err := c.DownloadFile(remotePath+".tar.gz", tempTarGz, onProgress); err != nil {
-			return fmt.Errorf("download compressed file: %w", err)
-		}
-
-		// Extract the downloaded file to an appropriate destination
 		// For files, extract to parent directory so the file lands at localPath
 		// For folders, avoid double nesting by checking the base name
 		extractDest := filepath.Dir(localPath)
@@ -1196,24 +1337,62 @@ func (c *Client) DownloadFilePacked(remotePath, localPath string, config PackTra
 				extractDest = localPath
 			}
 		}
-		if err := common.DecompressFromTarGz(tempTarGz, extractDest); err != nil {
-			return fmt.Errorf("extract downloaded file: %w", err)
-		}
 
-		// Remove temporary tar.gz file
-		if err := os.Remove(tempTarGz); err != nil {
-			log.Printf("[DEBUG] Warning: failed to remove temporary file: %v", err)
+		err := c.DownloadArchive([]string{remotePath}, extractDest, onProgress)
+		if !errors.Is(err, errNoArchive) {
+			return err
 		}
-
-		// Clean up server-side temporary tar.gz
-		deleteURL := fmt.Sprintf("http://%s?action=delete&path=%s.tar.gz", c.serverAddr, url.QueryEscape(remotePath))
-		req, _ := http.NewRequest("DELETE", deleteURL, nil)
-		c.httpClient.Do(req)
-
-		log.Printf("[DEBUG] Pack transfer download completed: %s -> %s", remotePath, localPath)
-		return nil
+		log.Printf("[DEBUG] Server cannot stream archives, compressing on the server instead")
+		return c.downloadPackedViaServer(remotePath, localPath, extractDest, onProgress)
 	}
 
 	// No compression needed, use regular download
 	return c.DownloadFile(remotePath, localPath, onProgress)
 }
+
+// downloadPackedViaServer is the pack transfer for servers without the
+// archive action: the server writes <remotePath>.tar.gz next to the source,
+// which is downloaded, extracted into extractDest and deleted again
+func (c *Client) downloadPackedViaServer(remotePath, localPath, extractDest string, onProgress func(percent float64, speedMBps float64)) error {
+	// Request server to compress the file/folder
+	// We'll use the compress action to create a tar.gz on the server
+	compressURL := fmt.Sprintf("http://%s?action=compress&paths=%s&output=%s.tar.gz&format=targz",
+		c.serverAddr, url.QueryEscape(remotePath), url.QueryEscape(remotePath))
+
+	// Use POST for compress action
+	resp, err := c.httpClient.Post(compressURL, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("request compression: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Compression failed, fall back to regular download
+		log.Printf("[DEBUG] Server compression failed, falling back to regular download")
+		return c.DownloadFile(remotePath, localPath, onProgress)
+	}
+
+	// Download the compressed file to system temp directory
+	tempTarGz := filepath.Join(os.TempDir(), fmt.Sprintf("pack_download_%d.tar.gz", time.Now().UnixNano()))
+
+	if err := c.DownloadFile(remotePath+".tar.gz", tempTarGz, onProgress); err != nil {
+		return fmt.Errorf("download compressed file: %w", err)
+	}
+
+	if err := common.DecompressFromTarGz(tempTarGz, extractDest); err != nil {
+		return fmt.Errorf("extract downloaded file: %w", err)
+	}
+
+	// Remove temporary tar.gz file
+	if err := os.Remove(tempTarGz); err != nil {
+		log.Printf("[DEBUG] Warning: failed to remove temporary file: %v", err)
+	}
+
+	// Clean up server-side temporary tar.gz
+	deleteURL := fmt.Sprintf("http://%s?action=delete&path=%s.tar.gz", c.serverAddr, url.QueryEscape(remotePath))
+	req, _ := http.NewRequest("DELETE", deleteURL, nil)
+	c.httpClient.Do(req)
+
+	log.Printf("[DEBUG] Pack transfer download completed: %s -> %s", remotePath, localPath)
+	return nil
+}
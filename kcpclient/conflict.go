@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// ConflictError is returned when the server refuses an operation because the
+// destination already exists (HTTP 409). It describes the existing entry so
+// the caller can ask the user how to proceed.
+type ConflictError struct {
+	Reason     string `json:"reason"`
+	Policy     string `json:"policy"`
+	Path       string `json:"path"` // Server path of the existing entry
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"modTime"`
+	IsDir      bool   `json:"isDir"`
+	SrcSize    int64  `json:"srcSize,omitempty"`
+	SrcModTime int64  `json:"srcModTime,omitempty"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict at %s: %s", e.Path, e.Reason)
+}
+
+// ConflictResult reports how the server resolved the destination
+type ConflictResult struct {
+	Action string // One of the common.ConflictResult* values
+	Path   string // Final server path, differs from the request when renamed
+}
+
+// Skipped returns true if the server left an existing destination untouched
+func (r *ConflictResult) Skipped() bool {
+	return r != nil && r.Action == common.ConflictResultSkipped
+}
+
+// conflictQuery returns the conflict query suffix for policy, or "" to use
+// the server default (overwrite)
+func conflictQuery(policy common.ConflictPolicy) string {
+	if policy == "" {
+		return ""
+	}
+	return "&" + common.QueryConflict + "=" + url.QueryEscape(string(policy))
+}
+
+// conflictResult reads the conflict headers of a successful response
+func conflictResult(resp *http.Response, requested string) *ConflictResult {
+	result := &ConflictResult{
+		Action: resp.Header.Get(common.HeaderConflictResult),
+		Path:   resp.Header.Get(common.HeaderFinalPath),
+	}
+	if result.Path == "" {
+		result.Path = requested
+	}
+	return result
+}
+
+// responseError builds the error for a failed response, decoding a 409 body
+// into a *ConflictError and a 413/415 body into a *RuleError, and turning 412
+// into a *PreconditionFailedError
+func responseError(resp *http.Response, op string) error {
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return &PreconditionFailedError{ETag: resp.Header.Get("ETag")}
+	}
+	body, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		var conflict ConflictError
+		if err := json.Unmarshal(body, &conflict); err == nil && conflict.Path != "" {
+			return &conflict
+		}
+	case http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType:
+		var rule RuleError
+		if err := json.Unmarshal(body, &rule); err == nil && rule.Path != "" {
+			rule.Status = resp.StatusCode
+			return &rule
+		}
+	}
+	return fmt.Errorf("%s failed (status %d): %s", op, resp.StatusCode, string(body))
+}
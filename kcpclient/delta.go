@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// errNoDeltaBase means the destination has no copy to compute a delta against
+var errNoDeltaBase = errors.New("no delta base")
+
+// UploadFileDelta updates an existing remote file by sending only the blocks
+// that differ from it, rsync style. The server rebuilds the file next to the
+// old one and replaces it atomically after checking the result's SHA256. If
+// the remote file does not exist, or the rebuilt file does not verify (the
+// remote copy changed meanwhile), it falls back to a full UploadFile.
+func (c *Client) UploadFileDelta(localPath, remotePath string, onProgress func(written int64, total int64)) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	err := c.uploadDelta(localPath, remotePath, onProgress)
+	if err == errNoDeltaBase || errors.Is(err, common.ErrDeltaMismatch) {
+		log.Printf("[DEBUG] Delta upload of %s not possible (%v), uploading whole file", localPath, err)
+		return c.UploadFile(localPath, remotePath, onProgress)
+	}
+	return err
+}
+
+func (c *Client) uploadDelta(localPath, remotePath string, onProgress func(written int64, total int64)) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat file: %w", err)
+	}
+
+	// Fetch the signature of the remote copy
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?action=%s&path=%s", c.serverAddr, common.ActionSignature, url.QueryEscape(remotePath)))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return errNoDeltaBase
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := responseError(resp, "signature")
+		resp.Body.Close()
+		return err
+	}
+	sig, err := common.ReadSignature(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	// Stream the delta while it is being computed
+	pr, pw := io.Pipe()
+	go func() {
+		src := &progressReader{reader: file, total: info.Size(), onProgress: onProgress}
+		literal, err := common.WriteDelta(pw, sig, src)
+		if err == nil {
+			log.Printf("[DEBUG] Delta upload of %s: %d of %d bytes literal", localPath, literal, info.Size())
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s?action=%s&path=%s", c.serverAddr, common.ActionDeltaUpload, url.QueryEscape(remotePath)), pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	setSourceMeta(req, info)
+
+	resp, err = c.httpClient.Do(req)
+	if err != nil {
+		pr.CloseWithError(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnprocessableEntity:
+		return common.ErrDeltaMismatch
+	case http.StatusNotFound:
+		return errNoDeltaBase
+	}
+	return responseError(resp, "delta upload")
+}
+
+// DownloadFileDelta updates an existing local file from the server by
+// receiving only the blocks that differ from it. The new version is built in
+// a temporary file next to localPath and renamed over it after its SHA256 has
+// been verified. Without a local copy this is a normal DownloadFile.
+func (c *Client) DownloadFileDelta(remotePath, localPath string, onProgress func(percent float64, speedMBps float64)) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	err := c.downloadDelta(remotePath, localPath, onProgress)
+	if err == errNoDeltaBase || errors.Is(err, common.ErrDeltaMismatch) {
+		log.Printf("[DEBUG] Delta download of %s not possible (%v), downloading whole file", remotePath, err)
+		return c.DownloadFile(remotePath, localPath, onProgress)
+	}
+	return err
+}
+
+func (c *Client) downloadDelta(remotePath, localPath string, onProgress func(percent float64, speedMBps float64)) error {
+	base, err := os.Open(localPath)
+	if err != nil {
+		return errNoDeltaBase
+	}
+	defer base.Close()
+
+	info, err := base.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return errNoDeltaBase
+	}
+
+	// Describe the local copy
+	sig, err := common.ComputeSignature(base, info.Size(), common.DeltaBlockSize(info.Size()))
+	if err != nil {
+		return fmt.Errorf("compute signature: %w", err)
+	}
+	var body bytes.Buffer
+	sig.WriteTo(&body)
+
+	resp, err := c.httpClient.Post(fmt.Sprintf("http://%s?action=%s&path=%s", c.serverAddr, common.ActionDeltaDownload, url.QueryEscape(remotePath)), "application/octet-stream", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "delta download")
+	}
+	fileSize, _ := strconv.ParseInt(resp.Header.Get("X-File-Size"), 10, 64)
+
+	tmpPath := filepath.Join(filepath.Dir(localPath), "."+filepath.Base(localPath)+".part")
+	out, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	var w io.Writer = out
+	if onProgress != nil && fileSize > 0 {
+		w = &progressWriter{writer: out, total: fileSize, start: time.Now(), onProgress: onProgress}
+	}
+	_, err = common.ApplyDelta(w, base, info.Size(), resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	base.Close() // Windows cannot replace an open file
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return err
+	}
+	c.applyFileMeta(localPath, resp.Header)
+	return nil
+}
+
+// progressWriter reports download progress as data is written
+type progressWriter struct {
+	writer     io.Writer
+	total      int64
+	written    int64
+	start      time.Time
+	onProgress func(percent float64, speedMBps float64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.writer.Write(p)
+	pw.written += int64(n)
+
+	var speed float64
+	if elapsed := time.Since(pw.start).Seconds(); elapsed > 0 {
+		speed = (float64(pw.written) / (1024 * 1024)) / elapsed
+	}
+	pw.onProgress(float64(pw.written)/float64(pw.total), speed)
+	return n, err
+}
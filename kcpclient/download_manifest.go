@@ -0,0 +1,163 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// downloadPartPath returns the hidden partial file a parallel download to
+// localPath writes into until it is complete
+func downloadPartPath(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), "."+filepath.Base(localPath)+".download")
+}
+
+// downloadManifestPath returns the manifest recording which chunks of the
+// partial file are complete
+func downloadManifestPath(localPath string) string {
+	return downloadPartPath(localPath) + ".json"
+}
+
+// remoteVersion identifies the version of a remote file a download is based on
+type remoteVersion struct {
+	Size    int64  `json:"size"`
+	ETag    string `json:"etag,omitempty"`
+	ModTime string `json:"modTime,omitempty"` // Last-Modified as sent by the server
+}
+
+// remoteVersionFromHeader reads the version of a file from a HEAD response
+func remoteVersionFromHeader(size int64, header http.Header) remoteVersion {
+	return remoteVersion{Size: size, ETag: header.Get("ETag"), ModTime: header.Get("Last-Modified")}
+}
+
+// downloadManifest is the persisted state of a parallel or sparse download.
+// It lives next to the partial file, so that a download
+// started again after a failure or restart only fetches the missing chunks,
+// as long as the remote file is still the same version. A sparse download
+// records the data extents it fetches; its chunks are those extents split
+// into ChunkSize pieces.
+type downloadManifest struct {
+	Remote    string          `json:"remote"`
+	Version   remoteVersion   `json:"version"`
+	ChunkSize int64           `json:"chunkSize"`
+	Sparse    bool            `json:"sparse,omitempty"`
+	Data      []common.Extent `json:"data,omitempty"` // Data extents of a sparse download
+	Bitmap    []byte          `json:"bitmap"`         // One bit per completed chunk
+
+	mu   sync.Mutex
+	path string
+}
+
+// newDownloadManifest creates the manifest for a fresh download to localPath
+func newDownloadManifest(localPath, remotePath string, version remoteVersion, chunkSize int64) *downloadManifest {
+	m := &downloadManifest{
+		Remote:    remotePath,
+		Version:   version,
+		ChunkSize: chunkSize,
+		path:      downloadManifestPath(localPath),
+	}
+	m.Bitmap = make([]byte, (m.chunks()+7)/8)
+	return m
+}
+
+// newSparseDownloadManifest creates the manifest for a fresh download of the
+// data extents of a sparse file
+func newSparseDownloadManifest(localPath, remotePath string, version remoteVersion, data []common.Extent, chunkSize int64) *downloadManifest {
+	m := &downloadManifest{
+		Remote:    remotePath,
+		Version:   version,
+		ChunkSize: chunkSize,
+		Sparse:    true,
+		Data:      data,
+		path:      downloadManifestPath(localPath),
+	}
+	m.Bitmap = make([]byte, (m.chunks()+7)/8)
+	return m
+}
+
+// loadDownloadManifest reads the manifest of an earlier attempt to download
+// to localPath. It returns nil if there is none, its partial file is missing
+// or it belongs to a different remote file or version or kind of download;
+// without an ETag the size and modification time must match.
+func loadDownloadManifest(localPath, remotePath string, version remoteVersion, sparse bool) *downloadManifest {
+	data, err := os.ReadFile(downloadManifestPath(localPath))
+	if err != nil {
+		return nil
+	}
+	if info, err := os.Stat(downloadPartPath(localPath)); err != nil || info.Size() != version.Size {
+		return nil
+	}
+	var m downloadManifest
+	if err := json.Unmarshal(data, &m); err != nil || m.ChunkSize <= 0 || int64(len(m.Bitmap)) != (m.chunks()+7)/8 {
+		return nil
+	}
+	if m.Remote != remotePath || m.Version != version || (version.ETag == "" && version.ModTime == "") || m.Sparse != sparse {
+		return nil
+	}
+	m.path = downloadManifestPath(localPath)
+	return &m
+}
+
+// chunks returns the number of chunks of the download
+func (m *downloadManifest) chunks() int64 {
+	if m.Sparse {
+		return int64(len(m.pieces()))
+	}
+	return (m.Version.Size + m.ChunkSize - 1) / m.ChunkSize
+}
+
+// pieces returns the chunks of a sparse download: its data extents split
+// into pieces of at most ChunkSize
+func (m *downloadManifest) pieces() []common.Extent {
+	var pieces []common.Extent
+	for _, e := range m.Data {
+		for start := e.Start; start < e.End; start += m.ChunkSize {
+			pieces = append(pieces, common.Extent{Start: start, End: min(start+m.ChunkSize, e.End)})
+		}
+	}
+	return pieces
+}
+
+// has reports whether chunk index is complete
+func (m *downloadManifest) has(index int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Bitmap[index/8]&(1<<(index%8)) != 0
+}
+
+// complete records chunk index as complete and persists the manifest
+func (m *downloadManifest) complete(index int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Bitmap[index/8] |= 1 << (index % 8)
+	return m.save()
+}
+
+// save writes the manifest atomically; m.mu must be held unless m is not shared yet
+func (m *downloadManifest) save() error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// DiscardDownload removes the partial state an interrupted download to
+// localPath left behind, so that the next attempt starts from scratch
+func (c *Client) DiscardDownload(localPath string) error {
+	if err := os.Remove(downloadManifestPath(localPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(downloadPartPath(localPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
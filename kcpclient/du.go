@@ -0,0 +1,83 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// duPollInterval is how often DiskUsage asks again while the server scans
+const duPollInterval = 500 * time.Millisecond
+
+// DuEntry is the recursive size of one directory entry
+type DuEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"`
+	Dirs  int64  `json:"dirs"`
+	IsDir bool   `json:"isDir"`
+}
+
+// DiskUsage is the recursive size of a directory and its largest children
+type DiskUsage struct {
+	Status  string    `json:"status"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Files   int64     `json:"files"`
+	Dirs    int64     `json:"dirs"`
+	Scanned int64     `json:"scanned"`
+	Entries []DuEntry `json:"entries"` // Largest first
+}
+
+// DiskUsage computes the recursive size of a remote directory. top limits the
+// returned children (0 returns all of them). The server scans in the background
+// and caches results, so this polls until the scan is done, reporting the
+// number of entries visited so far to onProgress.
+func (c *Client) DiskUsage(remotePath string, top int, refresh bool, onProgress func(scanned int64)) (*DiskUsage, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	query := url.Values{}
+	query.Set(common.QueryAction, common.ActionDu)
+	query.Set(common.QueryPath, remotePath)
+	query.Set(common.QueryTop, strconv.Itoa(top))
+	setFlag(query, common.QueryRefresh, refresh)
+
+	for {
+		resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?%s", c.serverAddr, query.Encode()))
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+			err := responseError(resp, "du")
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var usage DiskUsage
+		err = json.NewDecoder(resp.Body).Decode(&usage)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return &usage, nil
+		}
+
+		if onProgress != nil {
+			onProgress(usage.Scanned)
+		}
+		// Only the first request forces a rescan
+		query.Del(common.QueryRefresh)
+		time.Sleep(duPollInterval)
+	}
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// decodedBody returns the body of resp with its Content-Encoding removed.
+// Closing the result does not close resp.Body.
+func decodedBody(resp *http.Response) (io.ReadCloser, error) {
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding == "" || encoding == "identity" {
+		return io.NopCloser(resp.Body), nil
+	}
+	body, err := common.NewDecoder(resp.Body, encoding)
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return body, nil
+}
+
+// uploadEncoding returns the encoding to compress uploads of localPath
+// with, "" to send it as it is: the server must accept request encodings
+// (which it announces with Accept-Encoding) and the content must not be
+// compressed already. The server's answer is asked once per client.
+func (c *Client) uploadEncoding(localPath string) string {
+	if c.parent != nil {
+		return c.parent.uploadEncoding(localPath)
+	}
+	if !common.IsCompressibleType(localContentType(localPath)) {
+		return ""
+	}
+
+	c.encodingMu.Lock()
+	defer c.encodingMu.Unlock()
+	if !c.encodingKnown {
+		req, _ := http.NewRequest("HEAD", fmt.Sprintf("http://%s/", c.serverAddr), nil)
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return "" // Ask again next time
+		}
+		resp.Body.Close()
+		c.requestEncoding = common.NegotiateEncoding(resp.Header.Get("Accept-Encoding"))
+		c.encodingKnown = true
+	}
+	return c.requestEncoding
+}
+
+// localContentType returns the MIME type of a local file from its extension,
+// or sniffed from its first bytes if the extension is unknown
+func localContentType(localPath string) string {
+	if t := mime.TypeByExtension(filepath.Ext(localPath)); t != "" {
+		return t
+	}
+	file, err := os.Open(localPath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return http.DetectContentType(head[:n])
+}
+
+// encodeChunk compresses data with encoding and reports whether that made
+// it noticeably smaller; only then is the result worth sending
+func encodeChunk(data []byte, encoding string) ([]byte, bool) {
+	if len(data) < common.MinEncodeSize {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	encoder, err := common.NewEncoder(&buf, encoding)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := encoder.Write(data); err != nil {
+		return nil, false
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() > len(data)*9/10 {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// encodeReader returns a reader yielding r compressed with encoding
+func encodeReader(r io.Reader, encoding string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		encoder, err := common.NewEncoder(pw, encoding)
+		if err == nil {
+			_, err = io.Copy(encoder, r)
+			if closeErr := encoder.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// SetPreserveMetadata controls whether downloads take over the modification
+// time and permission bits of the server's file (the default). Uploads always
+// send the local values and the server applies them.
+func (c *Client) SetPreserveMetadata(enabled bool) {
+	c.skipFileMeta = !enabled
+}
+
+// setSourceMeta sends the modification time and permission bits of the local
+// file with an upload request. Windows only knows a read-only flag, so no
+// mode is sent from there and the server keeps its own.
+func setSourceMeta(req *http.Request, info os.FileInfo) {
+	req.Header.Set(common.HeaderSourceMtime, strconv.FormatInt(info.ModTime().Unix(), 10))
+	if runtime.GOOS != "windows" {
+		req.Header.Set(common.HeaderSourceMode, strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	}
+}
+
+// applyFileMeta applies the mode (X-File-Mode) and modification time
+// (Last-Modified) from a download response to the finished local file.
+// Failures are logged only; the data itself was transferred correctly.
+func (c *Client) applyFileMeta(localPath string, header http.Header) {
+	var mode os.FileMode
+	if m, err := strconv.ParseUint(header.Get(common.HeaderFileMode), 8, 32); err == nil {
+		mode = os.FileMode(m)
+	}
+	modTime, _ := http.ParseTime(header.Get("Last-Modified"))
+	c.setFileMeta(localPath, mode, modTime)
+}
+
+// setFileMeta applies the permission bits of mode and modTime to a finished
+// local file unless metadata is not preserved; zero values are left alone
+func (c *Client) setFileMeta(localPath string, mode os.FileMode, modTime time.Time) {
+	if c.skipFileMeta {
+		return
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(localPath, mode&os.ModePerm); err != nil {
+			log.Printf("[DEBUG] Failed to set mode of %s: %v", localPath, err)
+		}
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(localPath, time.Time{}, modTime); err != nil {
+			log.Printf("[DEBUG] Failed to set modification time of %s: %v", localPath, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// PermChange describes one mode or ownership change reported by the server
+type PermChange struct {
+	Path     string `json:"path"`
+	IsDir    bool   `json:"isDir"`
+	OldMode  string `json:"oldMode,omitempty"`
+	NewMode  string `json:"newMode,omitempty"`
+	OldOwner string `json:"oldOwner,omitempty"`
+	NewOwner string `json:"newOwner,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PermResult lists the entries changed (or, for a dry run, to be changed)
+type PermResult struct {
+	DryRun  bool         `json:"dryRun"`
+	Changed int          `json:"changed"`
+	Failed  int          `json:"failed"`
+	Changes []PermChange `json:"changes"`
+}
+
+// ChmodOptions selects the modes for Chmod. Modes are octal ("755") or
+// symbolic ("u+x,go-w"); FileMode and DirMode override Mode per entry type.
+type ChmodOptions struct {
+	Mode      string
+	FileMode  string
+	DirMode   string
+	Recursive bool
+	DryRun    bool
+}
+
+// ChownOptions selects the new owner for Chown. User and Group accept names
+// or numeric ids; an empty value leaves that part unchanged.
+type ChownOptions struct {
+	User      string
+	Group     string
+	Recursive bool
+	DryRun    bool
+}
+
+// Chmod changes permissions of a path, optionally recursively
+func (c *Client) Chmod(remotePath string, opts ChmodOptions) (*PermResult, error) {
+	query := url.Values{}
+	query.Set(common.QueryPath, remotePath)
+	setIfNotEmpty(query, common.QueryMode, opts.Mode)
+	setIfNotEmpty(query, common.QueryFileMode, opts.FileMode)
+	setIfNotEmpty(query, common.QueryDirMode, opts.DirMode)
+	setFlag(query, common.QueryRecursive, opts.Recursive)
+	setFlag(query, common.QueryDryRun, opts.DryRun)
+	return c.postPerm(common.ActionChmod, query)
+}
+
+// Chown changes the owner and/or group of a path, optionally recursively
+func (c *Client) Chown(remotePath string, opts ChownOptions) (*PermResult, error) {
+	query := url.Values{}
+	query.Set(common.QueryPath, remotePath)
+	setIfNotEmpty(query, common.QueryUser, opts.User)
+	setIfNotEmpty(query, common.QueryGroup, opts.Group)
+	setFlag(query, common.QueryRecursive, opts.Recursive)
+	setFlag(query, common.QueryDryRun, opts.DryRun)
+	return c.postPerm(common.ActionChown, query)
+}
+
+// postPerm sends a chmod/chown request and decodes the change list
+func (c *Client) postPerm(action string, query url.Values) (*PermResult, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	query.Set(common.QueryAction, action)
+	url := fmt.Sprintf("http://%s?%s", c.serverAddr, query.Encode())
+	resp, err := c.httpClient.Post(url, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, action)
+	}
+
+	var result PermResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &result, nil
+}
+
+func setIfNotEmpty(query url.Values, key, value string) {
+	if value != "" {
+		query.Set(key, value)
+	}
+}
+
+func setFlag(query url.Values, key string, on bool) {
+	if on {
+		query.Set(key, "1")
+	}
+}
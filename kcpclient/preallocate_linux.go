@@ -0,0 +1,16 @@
+package client
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate reserves size bytes of disk space for file and extends it to
+// that size, so parallel writes do not fragment it or fail halfway for lack
+// of space. Filesystems without fallocate get a plain (sparse) resize.
+func preallocate(file *os.File, size int64) error {
+	if err := syscall.Fallocate(int(file.Fd()), 0, 0, size); err == nil {
+		return nil
+	}
+	return file.Truncate(size)
+}
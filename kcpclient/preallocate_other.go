@@ -0,0 +1,10 @@
+//go:build !linux
+
+package client
+
+import "os"
+
+// preallocate extends file to size bytes; space is only reserved up front on Linux
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
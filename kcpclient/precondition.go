@@ -0,0 +1,130 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Precondition makes a write conditional on the current state of the remote
+// file, so that changes made by someone else since it was read are not
+// silently overwritten. ETags come from ListItem.ETag, FileStat.ETag,
+// ReadFileETag or the result of a previous conditional write.
+type Precondition struct {
+	IfMatch     string // Write only if the file's ETag is one of these (comma separated); "*": the file must exist
+	IfNoneMatch string // Write only if the file's ETag is none of these; "*": the file must not exist
+}
+
+// IfMatch returns a precondition requiring the remote file to still have etag
+func IfMatch(etag string) Precondition {
+	return Precondition{IfMatch: etag}
+}
+
+// IfNotExists returns a precondition requiring the remote file not to exist
+func IfNotExists() Precondition {
+	return Precondition{IfNoneMatch: "*"}
+}
+
+// apply sets the precondition headers on req
+func (p Precondition) apply(req *http.Request) {
+	if p.IfMatch != "" {
+		req.Header.Set("If-Match", p.IfMatch)
+	}
+	if p.IfNoneMatch != "" {
+		req.Header.Set("If-None-Match", p.IfNoneMatch)
+	}
+}
+
+// PreconditionFailedError is returned when the server refused a write because
+// its precondition did not hold (HTTP 412): the file was changed, created or
+// deleted by someone else
+type PreconditionFailedError struct {
+	ETag string // Current ETag of the remote file, empty if it does not exist
+}
+
+func (e *PreconditionFailedError) Error() string {
+	if e.ETag == "" {
+		return "precondition failed: remote file does not exist"
+	}
+	return "precondition failed: remote file was changed"
+}
+
+// ReadFileETag reads a text file like ReadFile and also returns its ETag,
+// for a later SaveFileIf
+func (c *Client) ReadFileETag(path string) (string, string, error) {
+	if !c.IsConnected() {
+		return "", "", fmt.Errorf("not connected")
+	}
+
+	url := fmt.Sprintf("http://%s?action=edit&path=%s", c.serverAddr, url.QueryEscape(path))
+	resp, err := c.httpClient.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", responseError(resp, "read")
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	return string(content), resp.Header.Get("ETag"), nil
+}
+
+// SaveFileIf saves content to a text file if pre holds and returns the new
+// ETag. A *PreconditionFailedError is returned if it does not.
+func (c *Client) SaveFileIf(path string, content string, pre Precondition) (string, error) {
+	if !c.IsConnected() {
+		return "", fmt.Errorf("not connected")
+	}
+
+	url := fmt.Sprintf("http://%s?action=edit&path=%s", c.serverAddr, url.QueryEscape(path))
+	req, err := http.NewRequest("PUT", url, bytes.NewReader([]byte(content)))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	pre.apply(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, "save")
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// DeleteFileIf deletes a file or directory if pre holds. A
+// *PreconditionFailedError is returned if it does not.
+func (c *Client) DeleteFileIf(path string, pre Precondition) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+
+	url := fmt.Sprintf("http://%s?action=delete&path=%s", c.serverAddr, url.QueryEscape(path))
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	pre.apply(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "delete")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+var (
+	// errNoRangeHash is returned by servers that cannot hash ranges
+	errNoRangeHash = errors.New("server does not support range hashes")
+	// errChunkCorrupt is returned when a downloaded range does not match
+	// the server's hash of it
+	errChunkCorrupt = errors.New("chunk corrupted in transit")
+)
+
+// RangeHashes returns the SHA256 of each byte range of a remote file
+func (c *Client) RangeHashes(remotePath string, ranges []common.Extent) (*common.RangeHashes, error) {
+	query := url.Values{}
+	query.Set(common.QueryRanges, common.FormatRanges(ranges))
+	return c.rangeHashes(remotePath, query)
+}
+
+// ChunkHashes returns the SHA256 of every chunkSize block of a remote file
+func (c *Client) ChunkHashes(remotePath string, chunkSize int64) (*common.RangeHashes, error) {
+	query := url.Values{}
+	query.Set(common.QueryChunkSize, strconv.FormatInt(chunkSize, 10))
+	return c.rangeHashes(remotePath, query)
+}
+
+// rangeHashes sends a range-hash request with the range parameters in query
+func (c *Client) rangeHashes(remotePath string, query url.Values) (*common.RangeHashes, error) {
+	query.Set(common.QueryAction, common.ActionRangeHash)
+	query.Set(common.QueryPath, remotePath)
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?%s", c.serverAddr, query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "range hash")
+	}
+	// Older servers answer unknown actions with the root directory listing
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		io.Copy(io.Discard, resp.Body)
+		return nil, errNoRangeHash
+	}
+
+	var hashes common.RangeHashes
+	if err := json.NewDecoder(resp.Body).Decode(&hashes); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &hashes, nil
+}
+
+// downloadVerifiedRange downloads bytes [start, end) like downloadRangeAt and
+// checks them against the server's hash of the range, so that the scheduler
+// fetches a corrupted chunk again right away. verify is cleared when the
+// server cannot hash ranges, which skips the check from then on.
+func (c *Client) downloadVerifiedRange(remotePath, etag string, file *os.File, start, end int64, sched *transferScheduler, verify *atomic.Bool) error {
+	sum, err := c.downloadRangeAt(remotePath, etag, file, start, end, sched)
+	if err != nil || !verify.Load() {
+		return err
+	}
+
+	hashes, err := c.RangeHashes(remotePath, []common.Extent{{Start: start, End: end}})
+	if errors.Is(err, errNoRangeHash) {
+		if verify.CompareAndSwap(true, false) {
+			log.Printf("[DEBUG] %s: %v, chunks are not verified", remotePath, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("range hash: %w", err)
+	}
+	if etag != "" && hashes.ETag != "" && hashes.ETag != etag {
+		return errRemoteChanged
+	}
+	if len(hashes.Hashes) != 1 || hashes.Hashes[0].SHA256 != sum {
+		log.Printf("[DEBUG] Range %d-%d of %s corrupted in transit", start, end, remotePath)
+		sched.bytes.Add(start - end)
+		return errChunkCorrupt
+	}
+	return nil
+}
+
+// repairDownload compares every chunk of a complete partial file with the
+// server's hash list and downloads again those that differ
+func (c *Client) repairDownload(remotePath, partPath string, version remoteVersion, chunkSize int64) error {
+	remote, err := c.ChunkHashes(remotePath, chunkSize)
+	if err != nil {
+		return err
+	}
+	if remote.Size != version.Size || (version.ETag != "" && remote.ETag != "" && remote.ETag != version.ETag) {
+		return errRemoteChanged
+	}
+	local, err := localChunkHashes(partPath, chunkSize)
+	if err != nil {
+		return err
+	}
+
+	var bad []int
+	for i, h := range remote.Hashes {
+		if i >= len(local) || local[i] != h.SHA256 {
+			bad = append(bad, i)
+		}
+	}
+	if len(bad) == 0 {
+		return fmt.Errorf("no chunk differs from the server's")
+	}
+	log.Printf("[DEBUG] Repairing %d of %d chunks of %s", len(bad), len(remote.Hashes), partPath)
+
+	file, err := os.OpenFile(partPath, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var bytesDone atomic.Int64
+	sched := newTransferScheduler(&bytesDone)
+	err = sched.run(bad, func(index int) error {
+		h := remote.Hashes[index]
+		sum, err := c.downloadRangeAt(remotePath, version.ETag, file, h.Start, h.End, sched)
+		if err == nil && sum != h.SHA256 {
+			err = errChunkCorrupt
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// localChunkHashes returns the hex SHA256 of every chunkSize block of a local file
+func localChunkHashes(path string, chunkSize int64) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sums []string
+	for {
+		hash := sha256.New()
+		n, err := io.Copy(hash, io.LimitReader(file, chunkSize))
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			break
+		}
+		sums = append(sums, hex.EncodeToString(hash.Sum(nil)))
+		if n < chunkSize {
+			break
+		}
+	}
+	return sums, nil
+}
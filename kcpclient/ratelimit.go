@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// rateBurst is the most a limiter lets through at once; reads and writes are
+// split into pieces of at most this size so they can be paced smoothly
+const rateBurst = 64 * 1024
+
+// RateLimiter caps the throughput of the transfers it is attached to. The
+// limit covers both directions together and can be changed at any time,
+// also while transfers are running.
+type RateLimiter struct {
+	limiter *rate.Limiter
+	limit   atomic.Int64
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec; 0 means unlimited
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	l := &RateLimiter{limiter: rate.NewLimiter(rate.Inf, rateBurst)}
+	l.SetLimit(bytesPerSec)
+	return l
+}
+
+// SetLimit changes the allowed bytes per second; 0 or less removes the limit
+func (l *RateLimiter) SetLimit(bytesPerSec int64) {
+	if bytesPerSec <= 0 {
+		bytesPerSec = 0
+		l.limiter.SetLimit(rate.Inf)
+	} else {
+		l.limiter.SetLimit(rate.Limit(bytesPerSec))
+	}
+	l.limit.Store(bytesPerSec)
+}
+
+// Limit returns the allowed bytes per second, 0 if unlimited
+func (l *RateLimiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.limit.Load()
+}
+
+// wait blocks until n bytes may pass or ctx is done. A read started while
+// unlimited can return more than rateBurst once a limit is switched on, so n
+// is waited for in pieces the limiter accepts.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil || l.limit.Load() == 0 {
+		return nil
+	}
+	for n > 0 {
+		piece := min(n, rateBurst)
+		if err := l.limiter.WaitN(ctx, piece); err != nil {
+			return err
+		}
+		n -= piece
+	}
+	return nil
+}
+
+// throttledReader paces reads from r with limiter
+type throttledReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	limiter *RateLimiter
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > rateBurst && t.limiter.Limit() > 0 {
+		b = b[:rateBurst]
+	}
+	n, err := t.r.Read(b)
+	if waitErr := t.limiter.wait(t.ctx, n); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
+
+// throttledTransport paces the request and response bodies of every request
+// with limiter, on top of whatever limits base already applies
+type throttledTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		// RoundTrip must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Body = &throttledReader{ctx: req.Context(), r: req.Body, limiter: t.limiter}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledReader{ctx: req.Context(), r: resp.Body, limiter: t.limiter}
+	return resp, nil
+}
+
+// SetRateLimit caps the combined throughput of all transfers of the client
+// at bytesPerSec, 0 for unlimited. Tasks can be limited further with
+// WithLimiter.
+func (c *Client) SetRateLimit(bytesPerSec int64) {
+	c.limiter.SetLimit(bytesPerSec)
+}
+
+// RateLimit returns the client's global limit in bytes per second, 0 if unlimited
+func (c *Client) RateLimit() int64 {
+	return c.limiter.Limit()
+}
+
+// WithLimiter returns a client for a single task that shares c's connection,
+// settings and global limit but is additionally held to limiter. Changing
+// limiter later affects the task's running transfers.
+func (c *Client) WithLimiter(limiter *RateLimiter) *Client {
+	if c.httpClient == nil || limiter == nil {
+		return c // Not connected: requests fail as they would on c
+	}
+	return &Client{
+		serverAddr:   c.serverAddr,
+		key:          c.key,
+		httpClient:   &http.Client{Transport: &throttledTransport{base: c.httpClient.Transport, limiter: limiter}},
+		skipFileMeta: c.skipFileMeta,
+		checksumAlgo: c.checksumAlgo,
+		limiter:      c.limiter,
+		parent:       c,
+	}
+}
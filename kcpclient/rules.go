@@ -0,0 +1,17 @@
+package client
+
+import "fmt"
+
+// RuleError is returned when the server rejects a file because of its upload
+// rules (HTTP 413 for the size, 415 for the name or content type). It is
+// reported before any data is stored.
+type RuleError struct {
+	Reason string `json:"reason"`
+	Path   string `json:"path"` // Server path of the rejected file
+	Rule   string `json:"rule"` // Path prefix of the rule that applied
+	Status int    `json:"-"`
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("%s rejected by server rules for %s: %s", e.Path, e.Rule, e.Reason)
+}
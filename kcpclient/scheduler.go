@@ -0,0 +1,249 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Chunk sizes of parallel transfers stay within these bounds, so a failed
+	// chunk of a huge file costs little and small files still get several chunks
+	minTransferChunk = 1024 * 1024
+	maxTransferChunk = 16 * 1024 * 1024
+	// targetTransferChunks is how many chunks a file is split into when the
+	// bounds allow it
+	targetTransferChunks = 64
+
+	// Parallel transfers start with initialTransferWorkers and adapt within
+	// the bounds to the measured throughput and latency
+	minTransferWorkers     = 2
+	initialTransferWorkers = 4
+	maxTransferWorkers     = 16
+	adjustInterval         = 2 * time.Second
+	// rttSlack is the latency growth over the fastest request that is
+	// tolerated before workers are taken away
+	rttSlack = 50 * time.Millisecond
+
+	// chunkRetries is how often a failed chunk is attempted in total
+	chunkRetries = 3
+	retryDelay   = 500 * time.Millisecond
+)
+
+// errRemoteChanged is returned when the file being downloaded changed on the server
+var errRemoteChanged = errors.New("remote file changed during download")
+
+// transferChunkSize returns the chunk size for a parallel transfer of size bytes
+func transferChunkSize(size int64) int64 {
+	chunk := size / targetTransferChunks / minTransferChunk * minTransferChunk
+	return min(max(chunk, minTransferChunk), maxTransferChunk)
+}
+
+// retryable reports whether a failed chunk may succeed when sent again
+func retryable(err error) bool {
+	var pre *PreconditionFailedError
+	var rule *RuleError
+	var conflict *ConflictError
+	return !errors.Is(err, errRemoteChanged) && !errors.Is(err, errNoBatch) && !errors.As(err, &pre) && !errors.As(err, &rule) && !errors.As(err, &conflict)
+}
+
+// transferScheduler runs the chunks of an upload or download from a shared
+// queue. maxTransferWorkers workers exist, but only those below target take
+// chunks; every adjustInterval target is moved up while more workers raise
+// the throughput and down when they stop helping or requests start to queue
+// up, as seen from a rising round trip time. Failed chunks are retried.
+type transferScheduler struct {
+	bytes *atomic.Int64 // Bytes transferred so far, counted as they arrive
+	queue chan int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	target   int
+	done     bool  // No more chunks are handed out
+	err      error // First chunk that failed for good
+	lastRate float64
+	grew     bool // The last adjustment added a worker
+
+	rttMu  sync.Mutex
+	rtt    time.Duration // Smoothed time from request sent to first response byte
+	minRTT time.Duration
+}
+
+// newTransferScheduler creates a scheduler measuring throughput from bytes,
+// which the chunk function must advance as data is transferred
+func newTransferScheduler(bytes *atomic.Int64) *transferScheduler {
+	s := &transferScheduler{bytes: bytes, target: initialTransferWorkers}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// run transfers chunks with transfer and returns once all are done or one
+// failed for good; chunks already running then still finish
+func (s *transferScheduler) run(chunks []int, transfer func(index int) error) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	s.queue = make(chan int, len(chunks))
+	for _, index := range chunks {
+		s.queue <- index
+	}
+	close(s.queue)
+
+	var wg sync.WaitGroup
+	for id := 0; id < min(maxTransferWorkers, len(chunks)); id++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.worker(id, transfer)
+		}()
+	}
+
+	stop := make(chan struct{})
+	go s.control(stop)
+	wg.Wait()
+	close(stop)
+	return s.err
+}
+
+// worker takes chunks from the queue while its id is below the target
+func (s *transferScheduler) worker(id int, transfer func(index int) error) {
+	for {
+		s.mu.Lock()
+		for id >= s.target && !s.done {
+			s.cond.Wait()
+		}
+		done := s.done
+		s.mu.Unlock()
+		if done {
+			return
+		}
+
+		index, ok := <-s.queue
+		if !ok {
+			s.finish(nil)
+			return
+		}
+		if err := s.runChunk(index, transfer); err != nil {
+			s.finish(fmt.Errorf("chunk %d failed: %w", index, err))
+			return
+		}
+	}
+}
+
+// runChunk transfers one chunk, retrying failures that may be transient
+func (s *transferScheduler) runChunk(index int, transfer func(index int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := transfer(index)
+		if err == nil || attempt >= chunkRetries || !retryable(err) {
+			return err
+		}
+		log.Printf("[DEBUG] Chunk %d failed (attempt %d of %d), retrying: %v", index, attempt, chunkRetries, err)
+		time.Sleep(time.Duration(attempt) * retryDelay)
+	}
+}
+
+// finish stops handing out chunks, recording err if it is the first failure
+func (s *transferScheduler) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.done = true
+	s.cond.Broadcast()
+}
+
+// control adjusts the worker target from the throughput and latency of
+// every adjustInterval until stop is closed
+func (s *transferScheduler) control(stop chan struct{}) {
+	ticker := time.NewTicker(adjustInterval)
+	defer ticker.Stop()
+
+	lastBytes := s.bytes.Load()
+	lastTime := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			bytes := s.bytes.Load()
+			rate := float64(bytes-lastBytes) / now.Sub(lastTime).Seconds()
+			lastBytes, lastTime = bytes, now
+			s.adjust(rate)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// adjust moves the worker target by one based on the rate of the last interval
+func (s *transferScheduler) adjust(rate float64) {
+	s.rttMu.Lock()
+	rtt, minRTT := s.rtt, s.minRTT
+	s.rttMu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return
+	}
+
+	n := s.target
+	switch {
+	case minRTT > 0 && rtt > 2*minRTT+rttSlack:
+		// Requests wait behind each other: the link is saturated
+		n--
+	case rate > s.lastRate*1.1:
+		// The first interval, or more workers helped
+		n++
+	case s.grew && rate < s.lastRate*0.95:
+		// The worker added last made things worse
+		n--
+	}
+	n = min(max(n, minTransferWorkers), maxTransferWorkers)
+
+	if n != s.target {
+		log.Printf("[DEBUG] Transfer workers %d -> %d (%.2f MB/s, rtt=%v, min rtt=%v)", s.target, n, rate/(1024*1024), rtt, minRTT)
+	}
+	s.grew = n > s.target
+	s.lastRate = rate
+	s.target = n
+	s.cond.Broadcast()
+}
+
+// traced returns req set up to report its round trip time to s, which may be nil
+func (s *transferScheduler) traced(req *http.Request) *http.Request {
+	if s == nil {
+		return req
+	}
+	// The transport calls these from different goroutines
+	var wrote atomic.Int64
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			wrote.Store(time.Now().UnixNano())
+		},
+		GotFirstResponseByte: func() {
+			if t := wrote.Load(); t != 0 {
+				s.observeRTT(time.Duration(time.Now().UnixNano() - t))
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// observeRTT records the round trip time of a request
+func (s *transferScheduler) observeRTT(d time.Duration) {
+	s.rttMu.Lock()
+	defer s.rttMu.Unlock()
+	if s.minRTT == 0 || d < s.minRTT {
+		s.minRTT = d
+	}
+	if s.rtt == 0 {
+		s.rtt = d
+	} else {
+		s.rtt = (4*s.rtt + d) / 5
+	}
+}
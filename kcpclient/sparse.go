@@ -0,0 +1,171 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// SparseMap returns the data extents of a remote file. Servers that cannot
+// detect holes report the whole file as data.
+func (c *Client) SparseMap(remotePath string) (*common.SparseMap, error) {
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?action=%s&path=%s", c.serverAddr, common.ActionSparseMap, url.QueryEscape(remotePath)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "sparse map")
+	}
+
+	var m common.SparseMap
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &m, nil
+}
+
+// localSparseMap returns the data extents of a local file
+func localSparseMap(localPath string) (*common.SparseMap, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return common.ReadSparseMap(file, info.Size())
+}
+
+// downloadFileSparse downloads only the data extents of a sparse remote
+// file with parallel workers. Like a parallel download it writes into the
+// hidden partial file, which replaces localPath only once verified, fails if
+// the remote file changes meanwhile and records finished pieces in the
+// manifest, so a new attempt on the same version fetches only the rest. The
+// partial file is sized up front without writing, so the holes stay holes
+// where the filesystem supports them.
+func (c *Client) downloadFileSparse(remotePath, localPath string, version remoteVersion, m *common.SparseMap, onProgress func(percent float64, speedMBps float64)) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	// Continue an earlier attempt if the remote file is unchanged, with the
+	// data extents it recorded, otherwise start over
+	partPath := downloadPartPath(localPath)
+	manifest := loadDownloadManifest(localPath, remotePath, version, true)
+	var file *os.File
+	var err error
+	if manifest != nil {
+		file, err = os.OpenFile(partPath, os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("open partial file: %w", err)
+		}
+	} else {
+		c.DiscardDownload(localPath)
+		file, err = os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("create partial file: %w", err)
+		}
+		if err := file.Truncate(m.Size); err != nil {
+			file.Close()
+			c.DiscardDownload(localPath)
+			return fmt.Errorf("size file: %w", err)
+		}
+		manifest = newSparseDownloadManifest(localPath, remotePath, version, m.Data, transferChunkSize(m.DataBytes))
+		if err := manifest.save(); err != nil {
+			file.Close()
+			c.DiscardDownload(localPath)
+			return fmt.Errorf("save manifest: %w", err)
+		}
+	}
+	defer file.Close()
+
+	// Pieces completed by an earlier attempt count as done
+	pieces := manifest.pieces()
+	var bytesDone atomic.Int64
+	var dataBytes int64
+	var pending []int
+	for i, p := range pieces {
+		dataBytes += p.End - p.Start
+		if manifest.has(int64(i)) {
+			bytesDone.Add(p.End - p.Start)
+		} else {
+			pending = append(pending, i)
+		}
+	}
+
+	log.Printf("[DEBUG] Sparse download: size=%d, data=%d, pieces=%d, resumed=%d", m.Size, dataBytes, len(pieces), len(pieces)-len(pending))
+
+	startTime := time.Now()
+	resumedBytes := bytesDone.Load()
+
+	// Progress reporter, relative to the data actually transferred
+	progressDone := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				done := bytesDone.Load()
+				if onProgress != nil && dataBytes > 0 {
+					percent := float64(done) / float64(dataBytes)
+					elapsed := time.Since(startTime).Seconds()
+					var speed float64
+					if elapsed > 0 {
+						speed = (float64(done-resumedBytes) / (1024 * 1024)) / elapsed
+					}
+					onProgress(percent, speed)
+				}
+			case <-progressDone:
+				return
+			}
+		}
+	}()
+
+	// Record each piece only once it is on disk; pieces still running when
+	// one fails for good finish and are kept for the next attempt
+	sched := newTransferScheduler(&bytesDone)
+	var verify atomic.Bool
+	verify.Store(true)
+	err = sched.run(pending, func(index int) error {
+		if err := c.downloadVerifiedRange(remotePath, version.ETag, file, pieces[index].Start, pieces[index].End, sched, &verify); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		return manifest.complete(int64(index))
+	})
+	close(progressDone)
+
+	if err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// A result that is corrupt must not be resumed
+	if err := c.verifyChecksum(remotePath, partPath); err != nil {
+		c.DiscardDownload(localPath)
+		return err
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("rename partial file: %w", err)
+	}
+	os.Remove(downloadManifestPath(localPath))
+	return nil
+}
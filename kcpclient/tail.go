@@ -0,0 +1,46 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// errNoTail is returned by servers without the tail action, which answer
+// with a directory listing instead
+var errNoTail = errors.New("server does not support tail")
+
+// Tail returns the last lines lines of a remote file. With follow the
+// stream stays open and delivers data appended to the file later, also
+// across log rotation and truncation, until the returned reader is closed.
+func (c *Client) Tail(remotePath string, lines int, follow bool) (io.ReadCloser, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	query := url.Values{}
+	query.Set(common.QueryAction, common.ActionTail)
+	query.Set(common.QueryPath, remotePath)
+	query.Set(common.QueryLines, strconv.Itoa(lines))
+	setFlag(query, common.QueryFollow, follow)
+
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?%s", c.serverAddr, query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, responseError(resp, "tail")
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" {
+		resp.Body.Close()
+		return nil, errNoTail
+	}
+	return resp.Body, nil
+}
@@ -9,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/CertStone/simpleKcpFileManager/common"
 	kcpclient "github.com/CertStone/simpleKcpFileManager/kcpclient"
 )
 
@@ -46,14 +47,18 @@ type Task struct {
 	RemotePath string
 	FileSize   int64
 	BytesDone  int64
+	IsFolder   bool // Transfers a whole folder
 	CancelFunc context.CancelFunc
 	Canceled   atomic.Bool
+	Limiter    *kcpclient.RateLimiter // Bandwidth limit of this task alone
 }
 
 // Manager manages file operation tasks
 type Manager struct {
 	client             *kcpclient.Client
 	packTransferConfig kcpclient.PackTransferConfig
+	conflictPolicy     common.ConflictPolicy // Applied when an upload target exists
+	deltaTransfer      bool                  // Send only changed blocks of files that exist on both sides
 	tasks              map[string]*Task
 	tasksMutex         sync.RWMutex
 	taskQueue          chan *Task
@@ -82,6 +87,51 @@ func (m *Manager) SetPackTransferConfig(config kcpclient.PackTransferConfig) {
 	m.packTransferConfig = config
 }
 
+// SetConflictPolicy sets how uploads treat files that already exist on the server
+func (m *Manager) SetConflictPolicy(policy common.ConflictPolicy) {
+	m.conflictPolicy = policy
+}
+
+// SetDeltaTransfer enables rsync-style delta transfers. Uploads only use it
+// when existing files are overwritten; other conflict policies need a full upload.
+func (m *Manager) SetDeltaTransfer(enabled bool) {
+	m.deltaTransfer = enabled
+}
+
+// SetPreserveMetadata controls whether downloads keep the server's
+// modification time and mode
+func (m *Manager) SetPreserveMetadata(enabled bool) {
+	m.client.SetPreserveMetadata(enabled)
+}
+
+// SetChecksumAlgorithm selects the hash downloads are verified with
+func (m *Manager) SetChecksumAlgorithm(algo string) error {
+	return m.client.SetChecksumAlgorithm(algo)
+}
+
+// SetRateLimit caps the combined throughput of all transfers at
+// bytesPerSec, 0 for unlimited. Running transfers follow the new limit.
+func (m *Manager) SetRateLimit(bytesPerSec int64) {
+	m.client.SetRateLimit(bytesPerSec)
+}
+
+// SetTaskRateLimit caps the throughput of one transfer task at bytesPerSec,
+// 0 for unlimited. The global limit still applies on top.
+func (m *Manager) SetTaskRateLimit(taskID string, bytesPerSec int64) error {
+	m.tasksMutex.RLock()
+	task, exists := m.tasks[taskID]
+	m.tasksMutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("task not found")
+	}
+	if task.Limiter == nil {
+		return fmt.Errorf("task has no transfer to limit")
+	}
+	task.Limiter.SetLimit(bytesPerSec)
+	return nil
+}
+
 // AddDownloadTask adds a download task
 func (m *Manager) AddDownloadTask(remotePath, localPath string) (*Task, error) {
 	m.tasksMutex.Lock()
@@ -93,6 +143,7 @@ func (m *Manager) AddDownloadTask(remotePath, localPath string) (*Task, error) {
 		Status:     StatusPending,
 		RemotePath: remotePath,
 		LocalPath:  localPath,
+		Limiter:    kcpclient.NewRateLimiter(0),
 	}
 	m.tasks[task.ID] = task
 
@@ -100,6 +151,26 @@ func (m *Manager) AddDownloadTask(remotePath, localPath string) (*Task, error) {
 	return task, nil
 }
 
+// AddDownloadFolderTask adds a task downloading a whole folder
+func (m *Manager) AddDownloadFolderTask(remotePath, localPath string) (*Task, error) {
+	m.tasksMutex.Lock()
+	defer m.tasksMutex.Unlock()
+
+	task := &Task{
+		ID:         generateTaskID(),
+		Type:       TaskTypeDownload,
+		Status:     StatusPending,
+		RemotePath: remotePath,
+		LocalPath:  localPath,
+		IsFolder:   true,
+		Limiter:    kcpclient.NewRateLimiter(0),
+	}
+	m.tasks[task.ID] = task
+
+	go m.runDownloadFolderTask(task)
+	return task, nil
+}
+
 // AddUploadTask adds an upload task
 func (m *Manager) AddUploadTask(localPath, remotePath string) (*Task, error) {
 	m.tasksMutex.Lock()
@@ -118,6 +189,7 @@ func (m *Manager) AddUploadTask(localPath, remotePath string) (*Task, error) {
 		LocalPath:  localPath,
 		RemotePath: remotePath,
 		FileSize:   info.Size(),
+		Limiter:    kcpclient.NewRateLimiter(0),
 	}
 	m.tasks[task.ID] = task
 
@@ -152,6 +224,7 @@ func (m *Manager) AddUploadFolderTask(localPath, remotePath string) (*Task, erro
 		LocalPath:  localPath,
 		RemotePath: remotePath,
 		FileSize:   totalSize, // Use total folder size for progress tracking
+		Limiter:    kcpclient.NewRateLimiter(0),
 	}
 	m.tasks[task.ID] = task
 
@@ -185,15 +258,23 @@ func (m *Manager) runDownloadTask(task *Task) {
 	_, cancel := context.WithCancel(context.Background())
 	task.CancelFunc = cancel
 
+	// Transfers of this task are held to its own limit as well as the global one
+	client := m.client.WithLimiter(task.Limiter)
+
 	var err error
 	// Use pack transfer if enabled
 	if m.packTransferConfig.Enabled {
-		err = m.client.DownloadFilePacked(task.RemotePath, task.LocalPath, m.packTransferConfig, func(percent float64, speed float64) {
+		err = client.DownloadFilePacked(task.RemotePath, task.LocalPath, m.packTransferConfig, func(percent float64, speed float64) {
+			task.Progress = percent
+			task.Speed = speed
+		})
+	} else if m.deltaTransfer {
+		err = client.DownloadFileDelta(task.RemotePath, task.LocalPath, func(percent float64, speed float64) {
 			task.Progress = percent
 			task.Speed = speed
 		})
 	} else {
-		err = m.client.DownloadFile(task.RemotePath, task.LocalPath, func(percent float64, speed float64) {
+		err = client.DownloadFile(task.RemotePath, task.LocalPath, func(percent float64, speed float64) {
 			task.Progress = percent
 			task.Speed = speed
 		})
@@ -201,8 +282,50 @@ func (m *Manager) runDownloadTask(task *Task) {
 
 	if task.Canceled.Load() {
 		task.Status = StatusCanceled
-		// Remove partial file
+		// Remove partial file and the state kept for resuming
 		os.Remove(task.LocalPath)
+		m.client.DiscardDownload(task.LocalPath)
+	} else if err != nil {
+		task.Status = StatusFailed
+		task.Error = err
+	} else {
+		task.Status = StatusCompleted
+		task.Progress = 1.0
+	}
+
+	// Notify completion callback
+	if OnTaskCompleted != nil {
+		OnTaskCompleted(task)
+	}
+}
+
+// runDownloadFolderTask executes a folder download task: as one archive
+// stream with pack transfer, otherwise with small files fetched in batches
+func (m *Manager) runDownloadFolderTask(task *Task) {
+	m.semaphore <- struct{}{}
+	defer func() { <-m.semaphore }()
+
+	task.Status = StatusRunning
+	_, cancel := context.WithCancel(context.Background())
+	task.CancelFunc = cancel
+
+	// Transfers of this task are held to its own limit as well as the global one
+	client := m.client.WithLimiter(task.Limiter)
+
+	onProgress := func(percent float64, speed float64) {
+		task.Progress = percent
+		task.Speed = speed
+	}
+	var err error
+	if m.packTransferConfig.Enabled {
+		err = client.DownloadFilePacked(task.RemotePath, task.LocalPath, m.packTransferConfig, onProgress)
+	} else {
+		err = client.DownloadFolder(task.RemotePath, task.LocalPath, onProgress)
+	}
+
+	// Files already downloaded are kept when canceled; a new download skips them
+	if task.Canceled.Load() {
+		task.Status = StatusCanceled
 	} else if err != nil {
 		task.Status = StatusFailed
 		task.Error = err
@@ -226,22 +349,37 @@ func (m *Manager) runUploadTask(task *Task) {
 	_, cancel := context.WithCancel(context.Background())
 	task.CancelFunc = cancel
 
+	// Transfers of this task are held to its own limit as well as the global one
+	client := m.client.WithLimiter(task.Limiter)
+
 	var err error
 	// Use pack transfer if enabled
 	if m.packTransferConfig.Enabled {
-		err = m.client.UploadFilePacked(task.LocalPath, task.RemotePath, m.packTransferConfig, func(written, total int64) {
+		err = client.UploadFilePackedWithPolicy(task.LocalPath, task.RemotePath, m.packTransferConfig, m.conflictPolicy, func(written, total int64) {
+			if total > 0 {
+				task.Progress = float64(written) / float64(total)
+				task.BytesDone = written
+			}
+		})
+	} else if m.deltaTransfer && (m.conflictPolicy == "" || m.conflictPolicy == common.ConflictOverwrite) {
+		err = client.UploadFileDelta(task.LocalPath, task.RemotePath, func(written, total int64) {
 			if total > 0 {
 				task.Progress = float64(written) / float64(total)
 				task.BytesDone = written
 			}
 		})
 	} else {
-		err = m.client.UploadFile(task.LocalPath, task.RemotePath, func(written, total int64) {
+		var result *kcpclient.ConflictResult
+		result, err = client.UploadFileWithPolicy(task.LocalPath, task.RemotePath, m.conflictPolicy, func(written, total int64) {
 			if total > 0 {
 				task.Progress = float64(written) / float64(total)
 				task.BytesDone = written
 			}
 		})
+		if err == nil {
+			// Show where the file ended up when it was renamed
+			task.RemotePath = result.Path
+		}
 	}
 
 	if task.Canceled.Load() {
@@ -269,8 +407,11 @@ func (m *Manager) runUploadFolderTask(task *Task) {
 	_, cancel := context.WithCancel(context.Background())
 	task.CancelFunc = cancel
 
+	// Transfers of this task are held to its own limit as well as the global one
+	client := m.client.WithLimiter(task.Limiter)
+
 	// Always use pack transfer for folder uploads
-	err := m.client.UploadFilePacked(task.LocalPath, task.RemotePath, m.packTransferConfig, func(written, total int64) {
+	err := client.UploadFilePackedWithPolicy(task.LocalPath, task.RemotePath, m.packTransferConfig, m.conflictPolicy, func(written, total int64) {
 		if total > 0 {
 			task.Progress = float64(written) / float64(total)
 			task.BytesDone = written
@@ -344,6 +485,45 @@ func (m *Manager) CancelTask(taskID string) error {
 	return nil
 }
 
+// RetryTask runs a failed transfer again. Downloads continue where the
+// failed attempt stopped if the remote file has not changed since.
+func (m *Manager) RetryTask(taskID string) error {
+	m.tasksMutex.RLock()
+	task, exists := m.tasks[taskID]
+	m.tasksMutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("task not found")
+	}
+	if task.Status != StatusFailed {
+		return fmt.Errorf("task has not failed")
+	}
+
+	var run func(*Task)
+	switch task.Type {
+	case TaskTypeDownload:
+		run = m.runDownloadTask
+		if task.IsFolder {
+			run = m.runDownloadFolderTask
+		}
+	case TaskTypeUpload:
+		run = m.runUploadTask
+		if info, err := os.Stat(task.LocalPath); err == nil && info.IsDir() {
+			run = m.runUploadFolderTask
+		}
+	default:
+		return fmt.Errorf("task type cannot be retried")
+	}
+
+	task.Status = StatusPending
+	task.Error = nil
+	task.Progress = 0
+	task.Speed = 0
+	task.BytesDone = 0
+	go run(task)
+	return nil
+}
+
 // GetTask returns a task by ID
 func (m *Manager) GetTask(taskID string) (*Task, bool) {
 	m.tasksMutex.RLock()
@@ -0,0 +1,332 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// uploadRounds is how often missing chunks are re-sent before giving up
+const uploadRounds = 3
+
+// ByteRange is a half-open range [Start, End) of file offsets
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadStatus describes a server upload session
+type UploadStatus struct {
+	ID        string      `json:"id"`
+	Path      string      `json:"path"`
+	Size      int64       `json:"size"`
+	ChunkSize int64       `json:"chunkSize"`
+	Chunks    int         `json:"chunks"`
+	Received  int         `json:"received"`
+	Missing   []ByteRange `json:"missing"`
+	Resumed   bool        `json:"resumed,omitempty"`
+	Skipped   bool        `json:"skipped,omitempty"`
+	Error     string      `json:"error,omitempty"` // Set by commit when data failed verification
+}
+
+// missingChunks expands the missing ranges into chunk indexes
+func (st *UploadStatus) missingChunks() []int {
+	var chunks []int
+	for _, r := range st.Missing {
+		for off := r.Start; off < r.End; off += st.ChunkSize {
+			chunks = append(chunks, int(off/st.ChunkSize))
+		}
+	}
+	return chunks
+}
+
+// InitUpload starts or resumes an upload session for a file of size bytes.
+// hash is the SHA256 of the whole file; an unfinished session is only resumed
+// if size and hash match. chunkSize 0 lets the server choose. If src is set
+// its modification time and mode are applied to the file at commit. If sparse
+// is set, chunks without data in it are reported as received right away.
+// pre is checked at init and again at commit.
+func (c *Client) InitUpload(remotePath string, size int64, hash string, chunkSize int64, src os.FileInfo, sparse *common.SparseMap, pre Precondition, policy common.ConflictPolicy) (*UploadStatus, *ConflictResult, error) {
+	query := url.Values{}
+	query.Set(common.QueryAction, common.ActionUploadInit)
+	query.Set(common.QueryPath, remotePath)
+	query.Set(common.QuerySize, strconv.FormatInt(size, 10))
+	setIfNotEmpty(query, common.QueryHash, hash)
+	if chunkSize > 0 {
+		query.Set(common.QueryChunkSize, strconv.FormatInt(chunkSize, 10))
+	}
+	setIfNotEmpty(query, common.QueryConflict, string(policy))
+
+	var body io.Reader
+	if sparse != nil {
+		data, err := json.Marshal(sparse)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("http://%s?%s", c.serverAddr, query.Encode()), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sparse != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if src != nil {
+		setSourceMeta(req, src)
+	}
+	pre.apply(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, responseError(resp, "upload init")
+	}
+
+	var st UploadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &st, conflictResult(resp, st.Path), nil
+}
+
+// UploadStatus returns the state of an upload session
+func (c *Client) UploadStatus(id string) (*UploadStatus, error) {
+	resp, err := c.httpClient.Get(fmt.Sprintf("http://%s?action=%s&id=%s", c.serverAddr, common.ActionUploadStatus, url.QueryEscape(id)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "upload status")
+	}
+
+	var st UploadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &st, nil
+}
+
+// CommitUpload finishes an upload session. The server verifies the data
+// against the chunk digests and the hash given at init; if chunks are still
+// missing or turned out corrupt the returned status lists them and the error
+// is nil, and the session stays open.
+func (c *Client) CommitUpload(id string) (*ConflictResult, *UploadStatus, error) {
+	resp, err := c.httpClient.Post(fmt.Sprintf("http://%s?action=%s&id=%s", c.serverAddr, common.ActionUploadCommit, url.QueryEscape(id)), "", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest && resp.Header.Get("Content-Type") == "application/json" {
+		var st UploadStatus
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			return nil, nil, fmt.Errorf("decode response: %w", err)
+		}
+		return nil, &st, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, responseError(resp, "upload commit")
+	}
+	return conflictResult(resp, ""), nil, nil
+}
+
+// AbortUpload cancels an upload session and removes the partial file
+func (c *Client) AbortUpload(id string) error {
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://%s?action=%s&id=%s", c.serverAddr, common.ActionUploadAbort, url.QueryEscape(id)), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, "upload abort")
+	}
+	return nil
+}
+
+// uploadFileSession uploads a file through an upload session, sending only
+// the chunks the server is missing
+func (c *Client) uploadFileSession(localPath, remotePath string, info os.FileInfo, pre Precondition, policy common.ConflictPolicy, onProgress func(written int64, total int64)) (*ConflictResult, error) {
+	fileSize := info.Size()
+	hash, err := calcFileChecksum(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("checksum file: %w", err)
+	}
+
+	// Holes of a sparse file need not be sent
+	sparse, err := localSparseMap(localPath)
+	if err != nil || !sparse.IsSparse() {
+		sparse = nil
+	}
+
+	st, result, err := c.InitUpload(remotePath, fileSize, hash, transferChunkSize(fileSize), info, sparse, pre, policy)
+	if err != nil {
+		return nil, err
+	}
+	if st.Skipped {
+		return result, nil
+	}
+
+	log.Printf("[DEBUG] Session upload: size=%d, chunks=%d, chunkSize=%d, received=%d, resumed=%v, sparse=%v",
+		fileSize, st.Chunks, st.ChunkSize, st.Received, st.Resumed, sparse != nil)
+
+	for round := 0; ; round++ {
+		if err := c.uploadChunks(localPath, st, onProgress); err != nil {
+			return nil, err
+		}
+
+		committed, missing, err := c.CommitUpload(st.ID)
+		if err != nil {
+			return nil, err
+		}
+		if missing == nil {
+			if onProgress != nil {
+				onProgress(fileSize, fileSize)
+			}
+			// The outcome was decided at init; commit only confirms the path
+			if committed.Path != "" {
+				result.Path = committed.Path
+			}
+			return result, nil
+		}
+		if round+1 >= uploadRounds {
+			if missing.Error != "" {
+				return nil, fmt.Errorf("upload failed verification: %s", missing.Error)
+			}
+			return nil, fmt.Errorf("upload incomplete: %d of %d chunks received", missing.Received, missing.Chunks)
+		}
+		if missing.Error != "" {
+			log.Printf("[DEBUG] Upload of %s: %s, re-sending %d chunks", localPath, missing.Error, len(missing.missingChunks()))
+		}
+		st = missing
+	}
+}
+
+// uploadChunks sends the chunks missing from st through a transfer scheduler
+func (c *Client) uploadChunks(localPath string, st *UploadStatus, onProgress func(written int64, total int64)) error {
+	chunks := st.missingChunks()
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var missingBytes int64
+	for _, r := range st.Missing {
+		missingBytes += r.End - r.Start
+	}
+	var bytesDone atomic.Int64
+	bytesDone.Store(st.Size - missingBytes)
+
+	// Progress reporter
+	progressDone := make(chan struct{})
+	defer close(progressDone)
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		var lastProgress int64 = -1
+		for {
+			select {
+			case <-ticker.C:
+				done := bytesDone.Load()
+				if onProgress != nil && done != lastProgress {
+					onProgress(done, st.Size)
+					lastProgress = done
+				}
+			case <-progressDone:
+				return
+			}
+		}
+	}()
+
+	// Return the first chunk that failed for good; chunks that did arrive
+	// are kept by the server
+	sched := newTransferScheduler(&bytesDone)
+	return sched.run(chunks, func(index int) error {
+		return c.uploadSessionChunk(localPath, st, index, sched)
+	})
+}
+
+// uploadSessionChunk sends chunk index of the local file with its SHA256,
+// adding the bytes to the scheduler's count as they are sent. A chunk the
+// server rejects as corrupted fails and is re-sent by the scheduler.
+func (c *Client) uploadSessionChunk(localPath string, st *UploadStatus, index int, sched *transferScheduler) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start := int64(index) * st.ChunkSize
+	data := make([]byte, min(st.ChunkSize, st.Size-start))
+	if _, err := file.ReadAt(data, start); err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+
+	// Chunks are compressed one by one and only when that pays off; the
+	// digest covers the uncompressed chunk
+	payload := data
+	encoding := c.uploadEncoding(localPath)
+	if encoding != "" {
+		if packed, ok := encodeChunk(data, encoding); ok {
+			payload = packed
+		} else {
+			encoding = ""
+		}
+	}
+
+	url := fmt.Sprintf("http://%s?action=%s&id=%s&index=%d", c.serverAddr, common.ActionUploadChunk, url.QueryEscape(st.ID), index)
+	body := &countingReader{r: bytes.NewReader(payload), n: sched.bytes}
+	req, err := http.NewRequest("PUT", url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(payload))
+	req.Header.Set(common.HeaderChunkDigest, hex.EncodeToString(digest[:]))
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	resp, err := c.httpClient.Do(sched.traced(req))
+	if err == nil && resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			log.Printf("[DEBUG] Chunk %d of %s corrupted in transit", index, localPath)
+		}
+		err = responseError(resp, "chunk upload")
+		resp.Body.Close()
+	}
+	if err != nil {
+		// The chunk will be sent again as a whole
+		sched.bytes.Add(-body.read.Load())
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	// Progress counts the chunk's bytes, not what it compressed to
+	sched.bytes.Add(int64(len(data)) - body.read.Load())
+	return nil
+}
@@ -0,0 +1,86 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// ExtractStats counts what an extraction wrote
+type ExtractStats struct {
+	Files   int   // Regular files written
+	Dirs    int   // Directories created or merged
+	Skipped int   // Entries left alone by the conflict policy or of unsupported types
+	Bytes   int64 // File content written
+}
+
+// EntryCheck validates a regular file before it is extracted: path is its
+// destination, size its size as recorded in the archive and head its first
+// bytes (at most HeadSize). A non-nil error aborts the extraction.
+type EntryCheck func(path string, size int64, head []byte) error
+
+// HeadSize is the number of leading bytes passed to an EntryCheck
+const HeadSize = 512
+
+// readHead reads the first HeadSize bytes of r, fewer if r is shorter
+func readHead(r io.Reader) []byte {
+	head := make([]byte, HeadSize)
+	n, _ := io.ReadFull(r, head)
+	return head[:n]
+}
+
+// Magic bytes of the supported formats
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// archiveSuffixes are the names ExtractArchive handles; the tar variants
+// can also be extracted as a stream
+var archiveSuffixes = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst", ".tar.xz", ".txz"}
+
+// IsArchive reports whether name is a zip or tar archive by its extension
+func IsArchive(name string) bool {
+	return IsZip(name) || IsTarArchive(name)
+}
+
+// IsZip reports whether name has a zip extension
+func IsZip(name string) bool {
+	return strings.HasSuffix(strings.ToLower(name), ".zip")
+}
+
+// IsTarArchive reports whether name is a plain or compressed tar archive
+// by its extension
+func IsTarArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range archiveSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractArchive extracts a zip or (compressed) tar archive to dest. The
+// format is detected from the content, so the archive may be stored under
+// any name, such as an upload's part file. If check is set every file entry
+// is validated before anything is written.
+func ExtractArchive(archive, dest string, policy common.ConflictPolicy, check EntryCheck) (ExtractStats, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	magic := make([]byte, len(zipMagic))
+	n, _ := io.ReadFull(file, magic)
+	file.Close()
+
+	if bytes.Equal(magic[:n], zipMagic) {
+		return ExtractZip(archive, dest, policy, check)
+	}
+	return ExtractTar(archive, dest, policy, check)
+}
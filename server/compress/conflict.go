@@ -0,0 +1,51 @@
+package compress
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// entryPath joins an archive entry name onto dest, rejecting names that
+// escape it (Zip Slip / Tar Slip). dest must be absolute.
+func entryPath(dest, name string) (string, error) {
+	path := filepath.Join(dest, name)
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(absPath, dest+string(filepath.Separator)) && absPath != dest {
+		return "", fmt.Errorf("illegal file path: %s", name)
+	}
+	return absPath, nil
+}
+
+// entryTarget returns where an archive entry should be written under policy,
+// or "" if it is skipped. Existing directories are always merged.
+func entryTarget(path string, isDir bool, modTime time.Time, policy common.ConflictPolicy) (string, error) {
+	if isDir {
+		if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+			return "", &common.ConflictError{Path: path, Reason: "cannot replace a file with a directory"}
+		}
+		return path, nil
+	}
+	target, _, err := common.ResolveConflict(path, false, modTime, policy)
+	return target, err
+}
+
+// checkEntry reports a conflict for an entry that already exists, used to
+// scan an archive before anything is written under ConflictFail
+func checkEntry(path string, isDir bool) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if isDir && info.IsDir() {
+		return nil
+	}
+	return &common.ConflictError{Path: path, Reason: "destination already exists"}
+}
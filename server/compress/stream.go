@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ParseFormat returns the archive format named by s, which may also be
+// given as a file extension such as "tar.gz"
+func ParseFormat(s string) (string, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), ".") {
+	case common.FormatTar:
+		return common.FormatTar, nil
+	case common.FormatTarGz, "tar.gz", "tgz":
+		return common.FormatTarGz, nil
+	case common.FormatTarZst, "tar.zst", "tzst":
+		return common.FormatTarZst, nil
+	case common.FormatZip:
+		return common.FormatZip, nil
+	}
+	return "", fmt.Errorf("unsupported archive format: %s", s)
+}
+
+// FormatExtension returns the file extension of an archive format
+func FormatExtension(format string) string {
+	switch format {
+	case common.FormatTarGz:
+		return ".tar.gz"
+	case common.FormatTarZst:
+		return ".tar.zst"
+	}
+	return "." + format
+}
+
+// FormatContentType returns the MIME type of an archive format
+func FormatContentType(format string) string {
+	switch format {
+	case common.FormatTarGz:
+		return "application/gzip"
+	case common.FormatTarZst:
+		return "application/zstd"
+	case common.FormatZip:
+		return "application/zip"
+	}
+	return "application/x-tar"
+}
+
+// WriteArchive writes sources as an archive in format (see common.Format*)
+// to w while walking them, so it can be sent without being stored first.
+// Every source appears under its base name, or a "name (N).ext" variant of it
+// when an earlier source has the same one, so extracting the archive never
+// overwrites one source with another; symlinks are stored as links and other
+// special files are left out.
+func WriteArchive(w io.Writer, sources []string, format string) error {
+	switch format {
+	case common.FormatZip:
+		zipWriter := zip.NewWriter(w)
+		if err := writeZipEntries(zipWriter, sources); err != nil {
+			return err
+		}
+		return zipWriter.Close()
+	case common.FormatTarGz:
+		gzWriter := gzip.NewWriter(w)
+		if err := writeTar(gzWriter, sources); err != nil {
+			return err
+		}
+		return gzWriter.Close()
+	case common.FormatTarZst:
+		zstdWriter, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return err
+		}
+		if err := writeTar(zstdWriter, sources); err != nil {
+			zstdWriter.Close()
+			return err
+		}
+		return zstdWriter.Close()
+	case common.FormatTar:
+		return writeTar(w, sources)
+	}
+	return fmt.Errorf("unsupported archive format: %s", format)
+}
+
+// entryNames returns the top-level archive name of each source: its base
+// name, renamed like common.UniquePath if an earlier source already uses it
+func entryNames(sources []string) []string {
+	taken := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		taken[filepath.Base(source)] = true
+	}
+	used := make(map[string]bool, len(sources))
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		name := filepath.Base(source)
+		if used[name] {
+			name = common.UniqueName(name, func(candidate string) bool { return taken[candidate] })
+			taken[name] = true
+		}
+		used[name] = true
+		names[i] = name
+	}
+	return names
+}
+
+// writeTar writes sources as a complete tar stream to w
+func writeTar(w io.Writer, sources []string) error {
+	tarWriter := tar.NewWriter(w)
+	if err := writeTarEntries(tarWriter, sources); err != nil {
+		return err
+	}
+	return tarWriter.Close()
+}
+
+// writeTarEntries adds sources to a tar archive. Entries are named relative
+// to each source under its entry name, so the archive contains name/... for
+// a folder or just name for a single file.
+func writeTarEntries(tarWriter *tar.Writer, sources []string) error {
+	names := entryNames(sources)
+	for i, source := range sources {
+
+		err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip directory entry for root folder (will be created implicitly)
+			if path == source && info.IsDir() {
+				return nil
+			}
+
+			var link string
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			case !info.IsDir() && !info.Mode().IsRegular():
+				return nil // Devices, sockets and pipes have no content to send
+			}
+
+			header, err := tar.FileInfoHeader(info, link)
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(source, path)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(filepath.Join(names[i], relPath))
+
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				return copyFileTo(tarWriter, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZipEntries adds sources to a zip archive, each under its entry name
+func writeZipEntries(zipWriter *zip.Writer, sources []string) error {
+	names := entryNames(sources)
+	for i, source := range sources {
+		baseName := names[i]
+
+		err := filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			isLink := info.Mode()&os.ModeSymlink != 0
+			if !info.IsDir() && !isLink && !info.Mode().IsRegular() {
+				return nil
+			}
+
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(source, path)
+			if err != nil {
+				return err
+			}
+			header.Name = filepath.ToSlash(filepath.Join(baseName, relPath))
+			if info.IsDir() {
+				header.Name += "/"
+			} else {
+				header.Method = zip.Deflate
+			}
+
+			writer, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+			switch {
+			case isLink:
+				// Zip stores a symlink's target as its content
+				link, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				_, err = io.WriteString(writer, link)
+				return err
+			case info.Mode().IsRegular():
+				return copyFileTo(writer, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileTo copies the content of the file at path to w
+func copyFileTo(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// createArchive writes sources as an archive in format to the file output
+func createArchive(output string, sources []string, format string) error {
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := WriteArchive(file, sources, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -2,111 +2,126 @@ package compress
 
 import (
 	"archive/tar"
+	"bufio"
+	"bytes"
 	"compress/gzip"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 // CreateTar creates a TAR archive from multiple sources
 func CreateTar(output string, sources []string) error {
-	file, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Check if output should be gzipped
-	tarWriter := tar.NewWriter(file)
-	defer tarWriter.Close()
+	return createArchive(output, sources, common.FormatTar)
+}
 
-	for _, source := range sources {
-		// Get parent directory for relative path calculation
-		srcParentDir := filepath.Dir(source)
+// ExtractTar extracts a TAR archive, plain or compressed with gzip, zstd or
+// xz, to destination, resolving existing files with policy. Under
+// ConflictFail, or if check is set, the archive is scanned first so that
+// nothing is written when any entry conflicts or fails the check.
+func ExtractTar(archive, dest string, policy common.ConflictPolicy, check EntryCheck) (ExtractStats, error) {
+	var stats ExtractStats
 
-		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Create header
-			header, err := tar.FileInfoHeader(info, "")
-			if err != nil {
-				return err
-			}
+	// Get absolute destination path for security check
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return stats, err
+	}
 
-			// Calculate relative path from source's parent directory
-			// This way the archive contains: srcBaseName/... or just srcBaseName for single file
-			relPath, err := filepath.Rel(srcParentDir, path)
+	if policy == common.ConflictFail || check != nil {
+		if err := walkTar(archive, func(tarReader *tar.Reader, header *tar.Header) error {
+			path, err := entryPath(absDest, header.Name)
 			if err != nil {
 				return err
 			}
-
-			// Use forward slashes for tar archive (standard format)
-			header.Name = filepath.ToSlash(relPath)
-
-			// Skip directory entry for root folder (will be created implicitly)
-			if path == source && info.IsDir() {
-				return nil
-			}
-
-			// Write header
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return err
-			}
-
-			// Write file content
-			if !info.IsDir() {
-				file, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-				_, err = io.Copy(tarWriter, file)
-				if err != nil {
+			if policy == common.ConflictFail {
+				if err := checkEntry(path, header.Typeflag == tar.TypeDir); err != nil {
 					return err
 				}
 			}
-
+			if check != nil && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+				return check(path, header.Size, readHead(tarReader))
+			}
 			return nil
-		})
-
-		if err != nil {
-			return err
+		}); err != nil {
+			return stats, err
 		}
 	}
 
-	return nil
+	err = walkTar(archive, func(tarReader *tar.Reader, header *tar.Header) error {
+		return extractTarFile(tarReader, header, absDest, policy, &stats)
+	})
+	return stats, err
 }
 
-// ExtractTar extracts a TAR or TAR.GZ archive to destination
-func ExtractTar(archive, dest string) error {
-	file, err := os.Open(archive)
+// ExtractTarStream extracts a TAR archive (plain or compressed, as for
+// ExtractTar) while it is being read from r, for example straight from an
+// upload body. Entries are confined to dest like ExtractTar, but since the
+// archive cannot be scanned first, a conflict under ConflictFail stops
+// extraction after the entries before it have been written.
+func ExtractTarStream(r io.Reader, dest string, policy common.ConflictPolicy) (ExtractStats, error) {
+	var stats ExtractStats
+
+	absDest, err := filepath.Abs(dest)
 	if err != nil {
-		return err
+		return stats, err
 	}
-	defer file.Close()
 
-	// Get absolute destination path for security check
-	absDest, err := filepath.Abs(dest)
+	err = walkTarReader(r, func(tarReader *tar.Reader, header *tar.Header) error {
+		return extractTarFile(tarReader, header, absDest, policy, &stats)
+	})
+	return stats, err
+}
+
+// walkTar calls fn for every entry of a TAR archive
+func walkTar(archive string, fn func(*tar.Reader, *tar.Header) error) error {
+	file, err := os.Open(archive)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
+
+	return walkTarReader(file, fn)
+}
 
+// walkTarReader calls fn for every entry of a TAR stream, plain or
+// compressed with gzip, zstd or xz
+func walkTarReader(r io.Reader, fn func(*tar.Reader, *tar.Header) error) error {
 	var tarReader *tar.Reader
 
-	// Check if gzipped
-	if strings.HasSuffix(archive, ".gz") || strings.HasSuffix(archive, ".tgz") {
-		gzReader, err := gzip.NewReader(file)
+	// Detect the compression by its magic bytes, so archives stored under a
+	// temporary name (such as an upload's .part file) are still recognized
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzReader, err := gzip.NewReader(reader)
 		if err != nil {
 			return err
 		}
 		defer gzReader.Close()
 		tarReader = tar.NewReader(gzReader)
-	} else {
-		tarReader = tar.NewReader(file)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer zstdReader.Close()
+		tarReader = tar.NewReader(zstdReader)
+	case bytes.HasPrefix(magic, xzMagic):
+		xzReader, err := xz.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		tarReader = tar.NewReader(xzReader)
+	default:
+		tarReader = tar.NewReader(reader)
 	}
 
 	for {
@@ -118,7 +133,7 @@ func ExtractTar(archive, dest string) error {
 			return err
 		}
 
-		if err := extractTarFile(tarReader, header, absDest); err != nil {
+		if err := fn(tarReader, header); err != nil {
 			return err
 		}
 	}
@@ -127,21 +142,31 @@ func ExtractTar(archive, dest string) error {
 }
 
 // extractTarFile extracts a single file from tar archive
-func extractTarFile(tarReader *tar.Reader, header *tar.Header, dest string) error {
-	// Construct destination path
-	path := filepath.Join(dest, header.Name)
+func extractTarFile(tarReader *tar.Reader, header *tar.Header, dest string, policy common.ConflictPolicy, stats *ExtractStats) error {
+	// Construct destination path, preventing path traversal
+	path, err := entryPath(dest, header.Name)
+	if err != nil {
+		return err
+	}
 
-	// Security check: prevent path traversal attack
-	absPath, err := filepath.Abs(path)
+	isDir := header.Typeflag == tar.TypeDir
+	if !isDir && header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+		stats.Skipped++
+		return nil // Skip links, devices and other special entries
+	}
+
+	path, err = entryTarget(path, isDir, header.ModTime, policy)
 	if err != nil {
 		return err
 	}
-	if !strings.HasPrefix(absPath, dest+string(filepath.Separator)) && absPath != dest {
-		return fmt.Errorf("illegal file path: %s", header.Name)
+	if path == "" {
+		stats.Skipped++
+		return nil
 	}
 
 	// Create directory
-	if header.Typeflag == tar.TypeDir {
+	if isDir {
+		stats.Dirs++
 		return os.MkdirAll(path, os.FileMode(header.Mode))
 	}
 
@@ -155,10 +180,22 @@ func extractTarFile(tarReader *tar.Reader, header *tar.Header, dest string) erro
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, tarReader)
-	return err
+	n, err := io.Copy(destFile, tarReader)
+	if closeErr := destFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	stats.Files++
+	stats.Bytes += n
+
+	// Keep the archived mode (also when replacing a file) and mtime
+	if err := os.Chmod(path, os.FileMode(header.Mode).Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(path, time.Time{}, header.ModTime)
 }
 
 // CreateGzip creates a Gzip compressed file
@@ -184,75 +221,5 @@ func CreateGzip(output, source string) error {
 
 // CreateTarGz creates a gzipped TAR archive from multiple sources
 func CreateTarGz(output string, sources []string) error {
-	file, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Create gzip writer
-	gzWriter := gzip.NewWriter(file)
-	defer gzWriter.Close()
-
-	// Create tar writer on top of gzip
-	tarWriter := tar.NewWriter(gzWriter)
-	defer tarWriter.Close()
-
-	for _, source := range sources {
-		// Get parent directory for relative path calculation
-		srcParentDir := filepath.Dir(source)
-
-		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// Create header
-			header, err := tar.FileInfoHeader(info, "")
-			if err != nil {
-				return err
-			}
-
-			// Calculate relative path from source's parent directory
-			// This way the archive contains: srcBaseName/... or just srcBaseName for single file
-			relPath, err := filepath.Rel(srcParentDir, path)
-			if err != nil {
-				return err
-			}
-
-			// Use forward slashes for tar archive (standard format)
-			header.Name = filepath.ToSlash(relPath)
-
-			// Skip directory entry for root folder (will be created implicitly)
-			if path == source && info.IsDir() {
-				return nil
-			}
-
-			// Write header
-			if err := tarWriter.WriteHeader(header); err != nil {
-				return err
-			}
-
-			// Write file content
-			if !info.IsDir() {
-				f, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer f.Close()
-				_, err = io.Copy(tarWriter, f)
-				if err != nil {
-					return err
-				}
-			}
-
-			return nil
-		})
-
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return createArchive(output, sources, common.FormatTarGz)
 }
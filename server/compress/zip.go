@@ -2,136 +2,107 @@ package compress
 
 import (
 	"archive/zip"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
 )
 
 // CreateZip creates a ZIP archive from multiple sources
 func CreateZip(output string, sources []string) error {
-	file, err := os.Create(output)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	return createArchive(output, sources, common.FormatZip)
+}
 
-	zipWriter := zip.NewWriter(file)
-	defer zipWriter.Close()
+// ExtractZip extracts a ZIP archive to destination, resolving existing
+// files with policy. Under ConflictFail nothing is written if any entry
+// conflicts, and if check is set nothing is written if any file fails it.
+func ExtractZip(archive, dest string, policy common.ConflictPolicy, check EntryCheck) (ExtractStats, error) {
+	var stats ExtractStats
 
-	for _, source := range sources {
-		err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return stats, err
+	}
+	defer zipReader.Close()
 
-			// Create header
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return err
-			}
+	// Get absolute destination path for security check
+	absDest, err := filepath.Abs(dest)
+	if err != nil {
+		return stats, err
+	}
 
-			// Calculate relative path from source
-			relPath, err := filepath.Rel(source, path)
+	if policy == common.ConflictFail {
+		for _, file := range zipReader.File {
+			path, err := entryPath(absDest, file.Name)
 			if err != nil {
-				return err
-			}
-
-			// Determine archive entry name
-			var archiveName string
-			if relPath == "." {
-				// This is the root of what we're compressing
-				// If it's a single file, use just the filename
-				// If it's a directory, use the directory name as the root
-				baseName := filepath.Base(source)
-				if info.IsDir() {
-					archiveName = baseName + "/"
-				} else {
-					archiveName = baseName
-				}
-			} else {
-				// Nested path - combine base name with relative path
-				baseName := filepath.Base(source)
-				archiveName = filepath.ToSlash(filepath.Join(baseName, relPath))
-			}
-
-			header.Name = archiveName
-
-			// Handle directory
-			if info.IsDir() {
-				header.Name += "/"
+				return stats, err
 			}
-
-			// Create writer
-			writer, err := zipWriter.CreateHeader(header)
-			if err != nil {
-				return err
+			if err := checkEntry(path, file.FileInfo().IsDir()); err != nil {
+				return stats, err
 			}
-
-			// Write file content
-			if !info.IsDir() {
-				file, err := os.Open(path)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-				_, err = io.Copy(writer, file)
-				if err != nil {
-					return err
-				}
+		}
+	}
+	if check != nil {
+		for _, file := range zipReader.File {
+			if err := checkZipFile(file, absDest, check); err != nil {
+				return stats, err
 			}
+		}
+	}
 
-			return nil
-		})
-
-		if err != nil {
-			return err
+	for _, file := range zipReader.File {
+		if err := extractZipFile(file, absDest, policy, &stats); err != nil {
+			return stats, err
 		}
 	}
 
-	return nil
+	return stats, nil
 }
 
-// ExtractZip extracts a ZIP archive to destination
-func ExtractZip(archive, dest string) error {
-	zipReader, err := zip.OpenReader(archive)
+// checkZipFile runs check on a regular file entry of a zip archive
+func checkZipFile(file *zip.File, dest string, check EntryCheck) error {
+	if !file.Mode().IsRegular() {
+		return nil
+	}
+	path, err := entryPath(dest, file.Name)
 	if err != nil {
 		return err
 	}
-	defer zipReader.Close()
-
-	// Get absolute destination path for security check
-	absDest, err := filepath.Abs(dest)
+	fileReader, err := file.Open()
 	if err != nil {
 		return err
 	}
-
-	for _, file := range zipReader.File {
-		if err := extractZipFile(file, absDest); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	defer fileReader.Close()
+	return check(path, int64(file.UncompressedSize64), readHead(fileReader))
 }
 
 // extractZipFile extracts a single file from zip archive
-func extractZipFile(file *zip.File, dest string) error {
-	// Construct destination path
-	path := filepath.Join(dest, file.Name)
+func extractZipFile(file *zip.File, dest string, policy common.ConflictPolicy, stats *ExtractStats) error {
+	// Construct destination path, preventing Zip Slip
+	path, err := entryPath(dest, file.Name)
+	if err != nil {
+		return err
+	}
 
-	// Security check: prevent Zip Slip attack
-	absPath, err := filepath.Abs(path)
+	isDir := file.FileInfo().IsDir()
+	if !isDir && !file.Mode().IsRegular() {
+		stats.Skipped++
+		return nil // Skip links, devices and other special entries
+	}
+
+	path, err = entryTarget(path, isDir, file.Modified, policy)
 	if err != nil {
 		return err
 	}
-	if !strings.HasPrefix(absPath, dest+string(filepath.Separator)) && absPath != dest {
-		return fmt.Errorf("illegal file path: %s", file.Name)
+	if path == "" {
+		stats.Skipped++
+		return nil
 	}
 
 	// Create directory
-	if file.FileInfo().IsDir() {
+	if isDir {
+		stats.Dirs++
 		return os.MkdirAll(path, file.Mode())
 	}
 
@@ -153,6 +124,11 @@ func extractZipFile(file *zip.File, dest string) error {
 	}
 	defer destFile.Close()
 
-	_, err = io.Copy(destFile, fileReader)
-	return err
+	n, err := io.Copy(destFile, fileReader)
+	if err != nil {
+		return err
+	}
+	stats.Files++
+	stats.Bytes += n
+	return nil
 }
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+	"github.com/CertStone/simpleKcpFileManager/server/compress"
+)
+
+// HandleArchive handles GET /?action=archive&path=A[&path=B...][&format=F].
+// The paths (also accepted comma separated in paths=) are streamed as one
+// archive in the response body, tar unless format selects targz, tarzst or
+// zip, without writing anything to disk. X-Archive-Size carries the total
+// size of the files it will contain. An error after streaming has started
+// aborts the response, so clients never mistake a cut-off archive for a
+// complete one.
+func (h *CompressHandler) HandleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := common.FormatTar
+	if f := query.Get(common.QueryFormat); f != "" {
+		var err error
+		if format, err = compress.ParseFormat(f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	requested := query[common.QueryPath]
+	if paths := query.Get(common.QueryPaths); paths != "" {
+		requested = append(requested, strings.Split(paths, ",")...)
+	}
+
+	var sources []string
+	var size int64
+	for _, p := range requested {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		cleanPath, safe := h.fileHandler.isPathSafe(p)
+		if !safe {
+			http.Error(w, "Invalid path: "+p, http.StatusBadRequest)
+			return
+		}
+		n, err := treeSize(cleanPath)
+		if err != nil {
+			http.Error(w, "Path not found: "+p, http.StatusNotFound)
+			return
+		}
+		sources = append(sources, cleanPath)
+		size += n
+	}
+	if len(sources) == 0 {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+
+	name := "archive"
+	if len(sources) == 1 && sources[0] != filepath.Clean(h.fileHandler.rootDir) {
+		name = filepath.Base(sources[0])
+	}
+	w.Header().Set("Content-Type", compress.FormatContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+compress.FormatExtension(format)))
+	w.Header().Set(common.HeaderArchiveSize, strconv.FormatInt(size, 10))
+
+	fmt.Printf("[DEBUG] Streaming %s archive of %d paths (%d bytes)\n", format, len(sources), size)
+	if err := compress.WriteArchive(w, sources, format); err != nil {
+		fmt.Printf("[ERROR] Archive of %s failed: %v\n", strings.Join(requested, ", "), err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// treeSize returns the total size of the regular files at or below p
+func treeSize(p string) (int64, error) {
+	var size int64
+	err := filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
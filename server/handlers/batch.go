@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	// maxBatchFiles bounds the files one batch request may ask for
+	maxBatchFiles = 10000
+	// maxBatchFileSize is the largest file sent in a batch; clients fetch
+	// larger ones on their own
+	maxBatchFileSize = 16 * 1024 * 1024
+	// maxBatchRequestSize bounds the JSON body of a batch request
+	maxBatchRequestSize = 8 * 1024 * 1024
+)
+
+// HandleBatch handles POST /?action=batch[&algo=A] with a common.BatchRequest
+// body. The requested files are sent in one tar stream, in request order and
+// named exactly as requested, so many small files cost a single round trip.
+// Every entry carries the file's mode and modification time and, in the PAX
+// record common.BatchHashRecord, the checksum of its content with algo
+// (SHA256 by default, named in X-Hash-Algorithm). Files that are missing,
+// not regular or larger than 16MB are left out; clients fetch whatever is
+// missing on its own. An error after streaming has started aborts the
+// response.
+func (h *FileHandler) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	algo := strings.ToLower(r.URL.Query().Get(common.QueryAlgo))
+	if algo == "" {
+		algo = common.HashSHA256
+	}
+	if _, err := common.NewHash(algo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req common.BatchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBatchRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 || len(req.Paths) > maxBatchFiles {
+		http.Error(w, fmt.Sprintf("A batch must name 1 to %d files", maxBatchFiles), http.StatusBadRequest)
+		return
+	}
+	fullPaths := make([]string, len(req.Paths))
+	for i, p := range req.Paths {
+		cleanPath, safe := h.isPathSafe(p)
+		if !safe {
+			http.Error(w, "Invalid path: "+p, http.StatusBadRequest)
+			return
+		}
+		fullPaths[i] = cleanPath
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	w.Header().Set(common.HeaderHashAlgorithm, algo)
+
+	tarWriter := tar.NewWriter(w)
+	sent := 0
+	for i, name := range req.Paths {
+		ok, err := writeBatchEntry(tarWriter, name, fullPaths[i], algo)
+		if err != nil {
+			fmt.Printf("[ERROR] Batch download failed at %s: %v\n", name, err)
+			panic(http.ErrAbortHandler)
+		}
+		if ok {
+			sent++
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		fmt.Printf("[ERROR] Batch download failed: %v\n", err)
+		panic(http.ErrAbortHandler)
+	}
+	fmt.Printf("[DEBUG] Batch download: %d of %d files sent\n", sent, len(req.Paths))
+}
+
+// writeBatchEntry adds the file at fullPath to tarWriter as name and reports
+// whether it did. Files that cannot be sent are left out quietly; only
+// errors writing the stream are returned. The file is read into memory
+// first, so the checksum in the header matches the content that follows.
+func writeBatchEntry(tarWriter *tar.Writer, name, fullPath, algo string) (bool, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return false, nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxBatchFileSize {
+		return false, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxBatchFileSize+1))
+	if err != nil || len(data) > maxBatchFileSize {
+		return false, nil
+	}
+
+	hash, _ := common.NewHash(algo)
+	hash.Write(data)
+
+	header := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Size:       int64(len(data)),
+		Mode:       int64(info.Mode().Perm()),
+		ModTime:    info.ModTime(),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{common.BatchHashRecord: hex.EncodeToString(hash.Sum(nil))},
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return false, err
+	}
+	if _, err := tarWriter.Write(data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
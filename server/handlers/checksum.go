@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	// maxChecksumEntries bounds the files a checksum cache remembers; the
+	// least recently used are dropped first
+	maxChecksumEntries = 10000
+	// checksumFlushInterval is how often changes to the cache are written out
+	checksumFlushInterval = 10 * time.Second
+)
+
+// ChecksumCache remembers whole-file checksums so that repeated downloads of
+// a large file hash it only once. An entry is only used while the file's
+// size, modification time and inode are unchanged, and writes through the
+// server drop it explicitly. The cache is shared by all handlers of a server
+// and persisted as JSON so it survives restarts; changes are written out
+// every few seconds and by Close.
+type ChecksumCache struct {
+	mu        sync.Mutex
+	file      string // "" keeps the cache in memory only
+	entries   map[string]*checksumEntry
+	dirty     bool     // Entries changed since the file was last written
+	computing sync.Map // path + algorithm -> *sync.Mutex, so a file is hashed once at a time
+
+	saveMu sync.Mutex // Serializes writes of the file
+	stop   chan struct{}
+	closed sync.Once
+}
+
+// checksumEntry holds the checksums of one version of a file
+type checksumEntry struct {
+	Size    int64             `json:"size"`
+	ModTime int64             `json:"mtime"` // Unix nanoseconds
+	Inode   uint64            `json:"inode"`
+	Sums    map[string]string `json:"sums"` // Hex checksum by algorithm
+	Used    int64             `json:"used"` // Unix seconds of the last use
+}
+
+// NewChecksumCache creates a cache persisted to file, loading what an earlier
+// run left there. An empty file name keeps the cache in memory only.
+func NewChecksumCache(file string) *ChecksumCache {
+	c := &ChecksumCache{file: file, entries: make(map[string]*checksumEntry), stop: make(chan struct{})}
+	if file == "" {
+		return c
+	}
+	if data, err := os.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &c.entries); err != nil {
+			fmt.Printf("[ERROR] Ignoring checksum cache %s: %v\n", file, err)
+			c.entries = make(map[string]*checksumEntry)
+		}
+	}
+	go c.flushLoop()
+	return c
+}
+
+// flushLoop writes out changes every checksumFlushInterval until Close
+func (c *ChecksumCache) flushLoop() {
+	ticker := time.NewTicker(checksumFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic writes and writes out pending changes
+func (c *ChecksumCache) Close() {
+	if c == nil {
+		return
+	}
+	c.closed.Do(func() { close(c.stop) })
+	c.Flush()
+}
+
+// matches reports whether e describes the file version in info
+func (e *checksumEntry) matches(info os.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano() && e.Inode == statInode(info)
+}
+
+// Sum returns the checksum of the file at fullPath with algo, from the cache
+// if the file has not changed since it was last hashed. A nil cache hashes
+// every time.
+func (c *ChecksumCache) Sum(fullPath, algo string) (string, error) {
+	algo = strings.ToLower(algo)
+	if algo == "" {
+		algo = common.HashSHA256
+	}
+	if _, err := common.NewHash(algo); err != nil {
+		return "", err
+	}
+	if c == nil {
+		return hashFile(fullPath, algo)
+	}
+
+	if sum, ok := c.lookup(fullPath, algo); ok {
+		return sum, nil
+	}
+
+	// Requests for the same file wait for the first one instead of hashing it again
+	lock, _ := c.computing.LoadOrStore(fullPath+"\x00"+algo, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	if sum, ok := c.lookup(fullPath, algo); ok {
+		return sum, nil
+	}
+
+	before, err := os.Stat(fullPath)
+	if err != nil {
+		return "", err
+	}
+	if !before.Mode().IsRegular() {
+		return "", fmt.Errorf("not a regular file")
+	}
+	sum, err := hashFile(fullPath, algo)
+	if err != nil {
+		return "", err
+	}
+
+	// Only remember the result if the file did not change while it was read
+	after, err := os.Stat(fullPath)
+	if err != nil || !os.SameFile(before, after) || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+		return sum, nil
+	}
+	c.store(fullPath, algo, sum, after)
+	return sum, nil
+}
+
+// lookup returns the cached checksum of fullPath if it is still valid
+func (c *ChecksumCache) lookup(fullPath, algo string) (string, bool) {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[fullPath]
+	if e == nil || !e.matches(info) || e.Sums[algo] == "" {
+		return "", false
+	}
+	e.Used = time.Now().Unix()
+	return e.Sums[algo], true
+}
+
+// store records a checksum of the file version in info
+func (c *ChecksumCache) store(fullPath, algo, sum string, info os.FileInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[fullPath]
+	if e == nil || !e.matches(info) {
+		e = &checksumEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: statInode(info), Sums: map[string]string{}}
+		c.entries[fullPath] = e
+	}
+	e.Sums[algo] = sum
+	e.Used = time.Now().Unix()
+
+	if len(c.entries) > maxChecksumEntries {
+		paths := make([]string, 0, len(c.entries))
+		for p := range c.entries {
+			paths = append(paths, p)
+		}
+		sort.Slice(paths, func(i, j int) bool { return c.entries[paths[i]].Used < c.entries[paths[j]].Used })
+		for _, p := range paths[:len(paths)-maxChecksumEntries] {
+			delete(c.entries, p)
+		}
+	}
+	c.dirty = true
+}
+
+// Invalidate drops the checksums of fullPath and of everything below it
+func (c *ChecksumCache) Invalidate(fullPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(fullPath, string(filepath.Separator)) + string(filepath.Separator)
+	removed := false
+	for p := range c.entries {
+		if p == fullPath || strings.HasPrefix(p, prefix) {
+			delete(c.entries, p)
+			removed = true
+		}
+	}
+	if removed {
+		c.dirty = true
+	}
+}
+
+// Flush writes the cache to its file if it changed, through a temporary
+// file renamed over it so a crash never leaves a truncated cache
+func (c *ChecksumCache) Flush() {
+	if c == nil || c.file == "" {
+		return
+	}
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(c.entries)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	if err := writeFileAtomic(c.file, data); err != nil {
+		fmt.Printf("[ERROR] Failed to save checksum cache: %v\n", err)
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+	}
+}
+
+// writeFileAtomic replaces file with data, creating its directory if needed
+func writeFileAtomic(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// hashFile returns the hex checksum of a file with algo
+func hashFile(fullPath, algo string) (string, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return common.HashReader(file, algo)
+}
+
+// SetChecksumCache shares cache with the handler; nil hashes on every request
+func (h *FileHandler) SetChecksumCache(cache *ChecksumCache) {
+	h.hashCache = cache
+}
+
+// SetChecksumCache lets uploads invalidate the checksums of replaced files
+func (h *UploadHandler) SetChecksumCache(cache *ChecksumCache) {
+	h.fileHandler.hashCache = cache
+}
+
+// SetChecksumCache lets compression and extraction invalidate the checksums of written files
+func (h *CompressHandler) SetChecksumCache(cache *ChecksumCache) {
+	h.fileHandler.hashCache = cache
+}
+
+// SetChecksumCache lets saves invalidate the checksums of edited files
+func (h *EditHandler) SetChecksumCache(cache *ChecksumCache) {
+	h.fileHandler.hashCache = cache
+}
+
+// forgetChecksums drops cached checksums after fullPath or anything below it was written
+func (h *FileHandler) forgetChecksums(fullPath string) {
+	h.hashCache.Invalidate(fullPath)
+}
+
+// HandleChecksum handles GET /path/to/file?action=checksum[&algo=A] (or
+// path=X). It returns the hex checksum of the file, SHA256 unless algo
+// selects one of common.HashAlgorithms, with the algorithm in the
+// X-Hash-Algorithm header. Checksums come from the cache when the file is
+// unchanged.
+func (h *FileHandler) HandleChecksum(w http.ResponseWriter, r *http.Request) {
+	filePath := r.URL.Query().Get(common.QueryPath)
+	if filePath == "" {
+		filePath = r.URL.Path
+	}
+	cleanPath, safe := h.isPathSafe(filePath)
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	algo := strings.ToLower(r.URL.Query().Get(common.QueryAlgo))
+	if algo == "" {
+		algo = common.HashSHA256
+	}
+	if _, err := common.NewHash(algo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sum, err := h.hashCache.Sum(cleanPath, algo)
+	if err != nil {
+		http.Error(w, "File not found or unreadable", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set(common.HeaderHashAlgorithm, algo)
+	w.Write([]byte(sum))
+}
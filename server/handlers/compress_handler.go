@@ -5,8 +5,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/CertStone/simpleKcpFileManager/common"
 	"github.com/CertStone/simpleKcpFileManager/server/compress"
 )
 
@@ -48,6 +50,10 @@ func (h *CompressHandler) HandleCompress(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Invalid output path", http.StatusBadRequest)
 		return
 	}
+	if isReservedName(cleanOutputPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
 
 	// Parse source paths
 	sourcePaths := strings.Split(paths, ",")
@@ -69,6 +75,11 @@ func (h *CompressHandler) HandleCompress(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	defer lockFiles(cleanOutputPath)()
+	if !checkPreconditions(w, r, cleanOutputPath) {
+		return
+	}
+
 	// Create output directory
 	if err := os.MkdirAll(filepath.Dir(cleanOutputPath), 0755); err != nil {
 		http.Error(w, "Failed to create output directory: "+err.Error(), http.StatusInternalServerError)
@@ -96,6 +107,7 @@ func (h *CompressHandler) HandleCompress(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Unsupported format: "+format, http.StatusBadRequest)
 		return
 	}
+	h.fileHandler.forgetChecksums(cleanOutputPath)
 
 	if err != nil {
 		http.Error(w, "Compression failed: "+err.Error(), http.StatusInternalServerError)
@@ -106,6 +118,13 @@ func (h *CompressHandler) HandleCompress(w http.ResponseWriter, r *http.Request)
 	fmt.Fprintf(w, "OK\nCompressed %d items to %s", len(validPaths), outputPath)
 }
 
+// setExtractHeaders reports extraction counts in the response headers
+func setExtractHeaders(w http.ResponseWriter, stats compress.ExtractStats) {
+	w.Header().Set(common.HeaderExtractedFiles, strconv.Itoa(stats.Files))
+	w.Header().Set(common.HeaderExtractedDirs, strconv.Itoa(stats.Dirs))
+	w.Header().Set(common.HeaderSkippedEntries, strconv.Itoa(stats.Skipped))
+}
+
 // HandleExtract handles archive extraction
 func (h *CompressHandler) HandleExtract(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -121,6 +140,12 @@ func (h *CompressHandler) HandleExtract(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	policy, err := conflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Validate archive path
 	cleanArchivePath, safe := h.fileHandler.isPathSafe(archivePath)
 	if !safe {
@@ -139,6 +164,12 @@ func (h *CompressHandler) HandleExtract(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Preconditions apply to the destination directory
+	defer lockFiles(cleanDestPath)()
+	if !checkPreconditions(w, r, cleanDestPath) {
+		return
+	}
+
 	// Create destination directory
 	if err := os.MkdirAll(cleanDestPath, 0755); err != nil {
 		http.Error(w, "Failed to create destination directory: "+err.Error(), http.StatusInternalServerError)
@@ -147,23 +178,28 @@ func (h *CompressHandler) HandleExtract(w http.ResponseWriter, r *http.Request)
 
 	// Detect archive type and extract
 	ext := strings.ToLower(filepath.Ext(cleanArchivePath))
-	var err error
 
+	var stats compress.ExtractStats
 	switch ext {
 	case ".zip":
-		err = compress.ExtractZip(cleanArchivePath, cleanDestPath)
-	case ".tar", ".gz", ".tgz":
-		err = compress.ExtractTar(cleanArchivePath, cleanDestPath)
+		stats, err = compress.ExtractZip(cleanArchivePath, cleanDestPath, policy, h.fileHandler.entryCheck())
+	case ".tar", ".gz", ".tgz", ".zst", ".tzst", ".xz", ".txz":
+		stats, err = compress.ExtractTar(cleanArchivePath, cleanDestPath, policy, h.fileHandler.entryCheck())
 	default:
 		http.Error(w, "Unsupported archive format: "+ext, http.StatusBadRequest)
 		return
 	}
+	h.fileHandler.forgetChecksums(cleanDestPath)
 
 	if err != nil {
+		if h.fileHandler.writeConflictError(w, err, policy, nil) || writeRuleError(w, err) {
+			return
+		}
 		http.Error(w, "Extraction failed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setExtractHeaders(w, stats)
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK\nExtracted to %s", destPath)
+	fmt.Fprintf(w, "OK\nExtracted %d files to %s", stats.Files, destPath)
 }
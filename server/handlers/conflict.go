@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// ConflictInfo is the JSON body of a 409 response when a destination exists
+type ConflictInfo struct {
+	Error    string `json:"error"`
+	Reason   string `json:"reason"`
+	Policy   string `json:"policy"`
+	Path     string `json:"path"` // Server path of the existing entry
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"modTime"`
+	IsDir    bool   `json:"isDir"`
+	SrcSize  int64  `json:"srcSize,omitempty"`
+	SrcMtime int64  `json:"srcModTime,omitempty"`
+}
+
+// conflictPolicy reads the conflict query parameter.
+// Overwrite is the default so existing clients keep their behavior.
+func conflictPolicy(r *http.Request) (common.ConflictPolicy, error) {
+	return common.ParseConflictPolicy(r.URL.Query().Get(common.QueryConflict), common.ConflictOverwrite)
+}
+
+// virtualPath converts a filesystem path under the root back to a server path
+func (h *FileHandler) virtualPath(fullPath string) string {
+	rel, err := filepath.Rel(h.rootDir, fullPath)
+	if err != nil {
+		return filepath.Base(fullPath)
+	}
+	if rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// writeConflictError writes err as a structured 409 response if it is a
+// *common.ConflictError and reports whether it did so
+func (h *FileHandler) writeConflictError(w http.ResponseWriter, err error, policy common.ConflictPolicy, src os.FileInfo) bool {
+	var conflict *common.ConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+
+	info := ConflictInfo{
+		Error:  "conflict",
+		Reason: conflict.Reason,
+		Policy: string(policy),
+		Path:   h.virtualPath(conflict.Path),
+	}
+	if existing, err := os.Lstat(conflict.Path); err == nil {
+		info.Size = existing.Size()
+		info.ModTime = existing.ModTime().Unix()
+		info.IsDir = existing.IsDir()
+	}
+	if src != nil {
+		info.SrcSize = src.Size()
+		info.SrcMtime = src.ModTime().Unix()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(info)
+	return true
+}
+
+// setConflictHeaders reports the conflict outcome and final destination
+func (h *FileHandler) setConflictHeaders(w http.ResponseWriter, result, finalPath string) {
+	w.Header().Set(common.HeaderConflictResult, result)
+	if finalPath != "" {
+		w.Header().Set(common.HeaderFinalPath, h.virtualPath(finalPath))
+	}
+}
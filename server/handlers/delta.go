@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// openRegular opens the regular file named by the path parameter, writing
+// the error response and returning nil if that is not possible
+func (h *FileHandler) openRegular(w http.ResponseWriter, r *http.Request) (*os.File, os.FileInfo, string) {
+	filePath := r.URL.Query().Get(common.QueryPath)
+	if filePath == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return nil, nil, ""
+	}
+
+	cleanPath, safe := h.isPathSafe(filePath)
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return nil, nil, ""
+	}
+
+	file, err := os.Open(cleanPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return nil, nil, ""
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, ""
+	}
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		http.Error(w, "Path is not a regular file", http.StatusBadRequest)
+		return nil, nil, ""
+	}
+	return file, info, cleanPath
+}
+
+// HandleSignature handles GET /?action=signature&path=X[&blockSize=N].
+// It returns the block signature of an existing file in the binary format of
+// common.Signature, which a client uses to compute a delta upload.
+func (h *FileHandler) HandleSignature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, info, _ := h.openRegular(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	blockSize := common.DeltaBlockSize(info.Size())
+	if s := r.URL.Query().Get(common.QueryBlockSize); s != "" {
+		var err error
+		if blockSize, err = strconv.Atoi(s); err != nil {
+			http.Error(w, "Invalid blockSize parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sig, err := common.ComputeSignature(file, info.Size(), blockSize)
+	if err != nil {
+		http.Error(w, "Failed to compute signature: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-File-Size", strconv.FormatInt(info.Size(), 10))
+	sig.WriteTo(w)
+}
+
+// HandleDeltaDownload handles POST /?action=delta-download&path=X.
+// The body is the signature of the client's copy; the response is the delta
+// that turns it into the server's file, ending with the file's SHA256.
+func (h *FileHandler) HandleDeltaDownload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, info, _ := h.openRegular(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	sig, err := common.ReadSignature(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-File-Size", strconv.FormatInt(info.Size(), 10))
+	SetFileMetaHeaders(w, info)
+	if literal, err := common.WriteDelta(w, sig, file); err != nil {
+		fmt.Printf("[ERROR] Delta download of %s failed: %v\n", file.Name(), err)
+	} else {
+		fmt.Printf("[DEBUG] Delta download of %s: %d of %d bytes literal\n", file.Name(), literal, info.Size())
+	}
+}
+
+// HandleDeltaUpload handles PUT /?action=delta-upload&path=X.
+// The body is a delta computed against the signature of the existing file.
+// The new version is rebuilt in a part file and renamed over the target only
+// if it matches the hash at the end of the delta (422 otherwise, so the
+// client can fall back to a full upload).
+func (h *UploadHandler) HandleDeltaUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	base, info, cleanPath := h.fileHandler.openRegular(w, r)
+	if base == nil {
+		return
+	}
+	defer base.Close()
+	if isReservedName(cleanPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
+
+	// One delta at a time per file, since all of them rebuild the same part file
+	lock := fileLock(cleanPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The delta was computed against the version the client saw
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
+	if err := h.fileHandler.checkWrite(cleanPath, -1); err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	part, err := newPartPath(cleanPath)
+	if err != nil {
+		http.Error(w, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		http.Error(w, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A small delta can repeat base blocks many times, so the size limit
+	// applies while the file is rebuilt, not only afterwards
+	written, err := common.ApplyDelta(h.fileHandler.limitWriter(cleanPath, out), base, info.Size(), r.Body)
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(part)
+		if errors.Is(err, common.ErrDeltaMismatch) {
+			writeDigestError(w, "Delta result")
+			return
+		}
+		if writeRuleError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to apply delta: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only now are the size and content of the new version known
+	err = h.fileHandler.checkWrite(cleanPath, written)
+	if err == nil {
+		err = h.fileHandler.checkFileHead(part, cleanPath)
+	}
+	if err != nil {
+		os.Remove(part)
+		if !writeRuleError(w, err) {
+			http.Error(w, "Failed to check file: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := commitPart(part, cleanPath, sourceMetaFromRequest(r)); err != nil {
+		os.Remove(part)
+		http.Error(w, "Failed to commit file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.fileHandler.forgetChecksums(cleanPath)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-File-Size", strconv.FormatInt(written, 10))
+	fmt.Fprintf(w, "OK\nRebuilt: %d bytes", written)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// errDigestMismatch is returned when uploaded data does not match the digest
+// the client sent with it
+var errDigestMismatch = errors.New("digest mismatch")
+
+// digestBody tees an upload body into a SHA256 hasher so it can be checked
+// against the X-Chunk-Digest header once it has been written
+type digestBody struct {
+	io.Reader
+	hash     hash.Hash
+	expected string // Lowercase hex, empty if the client sent no digest
+}
+
+// newDigestBody wraps body, reading the expected digest from r's headers
+func newDigestBody(r *http.Request, body io.Reader) *digestBody {
+	h := sha256.New()
+	return &digestBody{
+		Reader:   io.TeeReader(body, h),
+		hash:     h,
+		expected: strings.ToLower(strings.TrimSpace(r.Header.Get(common.HeaderChunkDigest))),
+	}
+}
+
+// sum returns the hex SHA256 of everything read so far
+func (d *digestBody) sum() string {
+	return hex.EncodeToString(d.hash.Sum(nil))
+}
+
+// verify returns errDigestMismatch if a digest was sent and does not match
+func (d *digestBody) verify() error {
+	if d.expected != "" && d.expected != d.sum() {
+		return errDigestMismatch
+	}
+	return nil
+}
+
+// writeDigestError answers a failed digest check with 422 so clients know
+// to send the data again
+func writeDigestError(w http.ResponseWriter, what string) {
+	http.Error(w, what+" digest mismatch", http.StatusUnprocessableEntity)
+}
+
+// zeroDigest returns the hex SHA256 of n zero bytes
+func zeroDigest(n int64) string {
+	sum := sha256.Sum256(make([]byte, n))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashChunks reads a file once and returns the SHA256 of every chunkSize
+// block along with the SHA256 of the whole file
+func hashChunks(path string, chunkSize int64) ([]string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	whole := sha256.New()
+	var sums []string
+	for {
+		chunk := sha256.New()
+		n, err := io.Copy(io.MultiWriter(whole, chunk), io.LimitReader(file, chunkSize))
+		if err != nil {
+			return nil, "", err
+		}
+		if n == 0 {
+			break
+		}
+		sums = append(sums, hex.EncodeToString(chunk.Sum(nil)))
+		if n < chunkSize {
+			break
+		}
+	}
+	return sums, hex.EncodeToString(whole.Sum(nil)), nil
+}
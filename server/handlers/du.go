@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	defaultDuTop = 20
+	// duWait is how long a du request waits for the scan before answering 202
+	duWait = 2 * time.Second
+)
+
+// DuEntry is the recursive size of one directory entry
+type DuEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int64  `json:"files"` // Regular files (and links) below, 1 for a file
+	Dirs  int64  `json:"dirs"`  // Directories below, not counting itself
+	IsDir bool   `json:"isDir"`
+}
+
+// DuResult is the JSON response of the du action. While the scan is still
+// running Status is "running", only Scanned is set and the status is 202.
+type DuResult struct {
+	Status  string    `json:"status"` // "running" or "done"
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Files   int64     `json:"files"`
+	Dirs    int64     `json:"dirs"`
+	Scanned int64     `json:"scanned"` // Entries visited so far
+	Entries []DuEntry `json:"entries"` // Largest children first, at most top
+}
+
+// duNode caches the totals of one directory, valid while its mtime is unchanged
+type duNode struct {
+	mtime    time.Time
+	size     int64
+	files    int64
+	dirs     int64
+	children []DuEntry // Sorted by size, largest first; Path is left empty
+}
+
+// duJob is a scan in progress, shared by concurrent requests for the same path
+type duJob struct {
+	done    chan struct{}
+	scanned atomic.Int64
+	node    *duNode
+	err     error
+}
+
+// duCache holds per-directory results and running scans
+type duCache struct {
+	nodes sync.Map // full path -> *duNode
+	jobs  sync.Map // full path -> *duJob
+}
+
+// HandleDu handles GET /?action=du&path=X&top=N[&refresh=1].
+// Sizes are computed in the background; the request waits briefly and returns
+// 202 with progress if the scan has not finished, so clients poll until 200.
+// Directories are only rescanned when their mtime changed, which catches
+// added, removed and renamed entries but not files growing in place; use
+// refresh=1 to force a full rescan.
+func (h *FileHandler) HandleDu(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	cleanPath, safe := h.isPathSafe(query.Get(common.QueryPath))
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Stat(cleanPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Path not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	top := defaultDuTop
+	if s := query.Get(common.QueryTop); s != "" {
+		if top, err = strconv.Atoi(s); err != nil || top < 0 {
+			http.Error(w, "Invalid top parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if query.Get(common.QueryRefresh) == "1" {
+		h.du.invalidate(cleanPath)
+	}
+
+	job := h.du.start(cleanPath)
+
+	result := DuResult{Path: h.virtualPath(cleanPath), Entries: []DuEntry{}}
+	select {
+	case <-job.done:
+	case <-time.After(duWait):
+		result.Status = "running"
+		result.Scanned = job.scanned.Load()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if job.err != nil {
+		http.Error(w, "Failed to scan: "+job.err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	node := job.node
+	result.Status = "done"
+	result.Size = node.size
+	result.Files = node.files
+	result.Dirs = node.dirs
+	result.Scanned = job.scanned.Load()
+
+	// top=0 returns every child, which the GUI uses for folder sizes
+	children := node.children
+	if top > 0 && len(children) > top {
+		children = children[:top]
+	}
+	for _, c := range children {
+		c.Path = joinVirtual(result.Path, c.Name)
+		result.Entries = append(result.Entries, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// joinVirtual joins a child name onto a server path
+func joinVirtual(parent, name string) string {
+	return strings.TrimSuffix(parent, "/") + "/" + name
+}
+
+// start returns the running scan for dir, starting one if needed
+func (c *duCache) start(dir string) *duJob {
+	job := &duJob{done: make(chan struct{})}
+	if existing, loaded := c.jobs.LoadOrStore(dir, job); loaded {
+		return existing.(*duJob)
+	}
+
+	go func() {
+		job.node, job.err = c.scan(dir, &job.scanned)
+		c.jobs.Delete(dir)
+		close(job.done)
+	}()
+	return job
+}
+
+// invalidate drops cached results for dir and everything below it
+func (c *duCache) invalidate(dir string) {
+	prefix := dir + string(filepath.Separator)
+	c.nodes.Range(func(key, _ any) bool {
+		if p := key.(string); p == dir || strings.HasPrefix(p, prefix) {
+			c.nodes.Delete(key)
+		}
+		return true
+	})
+}
+
+// scan computes the totals of dir, reusing cached subdirectories whose mtime
+// is unchanged. Symlinks are counted by their own size and never followed.
+func (c *duCache) scan(dir string, scanned *atomic.Int64) (*duNode, error) {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := c.nodes.Load(dir); ok {
+		node := cached.(*duNode)
+		if node.mtime.Equal(info.ModTime()) {
+			return c.refreshNode(dir, node, scanned), nil
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &duNode{mtime: info.ModTime()}
+	for _, entry := range entries {
+		scanned.Add(1)
+		child := DuEntry{Name: entry.Name(), IsDir: entry.IsDir()}
+
+		if entry.IsDir() {
+			sub, err := c.scan(filepath.Join(dir, entry.Name()), scanned)
+			if err != nil {
+				continue // Unreadable directories count as empty
+			}
+			child.Size, child.Files, child.Dirs = sub.size, sub.files, sub.dirs
+		} else {
+			fi, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			child.Size, child.Files = fi.Size(), 1
+		}
+		node.children = append(node.children, child)
+	}
+
+	node.total()
+	c.nodes.Store(dir, node)
+	return node, nil
+}
+
+// refreshNode revalidates the subdirectories of an unchanged directory and
+// rebuilds its totals if any of them changed
+func (c *duCache) refreshNode(dir string, node *duNode, scanned *atomic.Int64) *duNode {
+	var updated *duNode
+	for i, child := range node.children {
+		scanned.Add(1)
+		if !child.IsDir {
+			continue
+		}
+		sub, err := c.scan(filepath.Join(dir, child.Name), scanned)
+		if err != nil || (sub.size == child.Size && sub.files == child.Files && sub.dirs == child.Dirs) {
+			continue
+		}
+		if updated == nil {
+			updated = &duNode{mtime: node.mtime, children: append([]DuEntry(nil), node.children...)}
+		}
+		updated.children[i].Size, updated.children[i].Files, updated.children[i].Dirs = sub.size, sub.files, sub.dirs
+	}
+
+	if updated == nil {
+		return node
+	}
+	updated.total()
+	c.nodes.Store(dir, updated)
+	return updated
+}
+
+// total sums the children and sorts them largest first
+func (n *duNode) total() {
+	n.size, n.files, n.dirs = 0, 0, 0
+	for _, child := range n.children {
+		n.size += child.Size
+		n.files += child.Files
+		n.dirs += child.Dirs
+		if child.IsDir {
+			n.dirs++
+		}
+	}
+	sort.SliceStable(n.children, func(i, j int) bool {
+		return n.children[i].Size > n.children[j].Size
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+
+	"github.com/CertStone/simpleKcpFileManager/server/compress"
 )
 
 // EditHandler handles text file editing operations
@@ -70,8 +72,9 @@ func (h *EditHandler) HandleGetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set content type
+	// Set content type; the ETag lets the save detect concurrent changes
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", fileETag(info))
 	w.WriteHeader(http.StatusOK)
 	w.Write(content)
 }
@@ -94,12 +97,20 @@ func (h *EditHandler) HandleSaveFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
+	if isReservedName(cleanPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
 
 	// Check content length
 	if r.ContentLength > maxEditSize {
 		http.Error(w, "Content too large (max 1MB)", http.StatusBadRequest)
 		return
 	}
+	if err := h.fileHandler.checkWrite(cleanPath, r.ContentLength); err != nil {
+		writeRuleError(w, err)
+		return
+	}
 
 	// Create directory if not exists
 	dir := filepath.Dir(cleanPath)
@@ -121,14 +132,33 @@ func (h *EditHandler) HandleSaveFile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Content too large (max 1MB)", http.StatusBadRequest)
 		return
 	}
+	err = h.fileHandler.checkWrite(cleanPath, int64(len(content)))
+	if err == nil {
+		err = h.fileHandler.checkContent(cleanPath, content[:min(len(content), compress.HeadSize)])
+	}
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
 
-	// Write file
+	// Write file unless it changed since the client read it; the lock is
+	// shared with uploads to the same file
+	lock := fileLock(cleanPath)
+	lock.Lock()
+	defer lock.Unlock()
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
 	err = os.WriteFile(cleanPath, content, 0644)
+	h.fileHandler.forgetChecksums(cleanPath)
 	if err != nil {
 		http.Error(w, "Failed to write file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if etag := currentETag(cleanPath); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
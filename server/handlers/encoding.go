@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// EncodingWriter compresses a file response with the encoding the client
+// accepts. Every response is compressed on its own, so a ranged chunk
+// arrives as an independent stream whose Content-Range still counts bytes of
+// the uncompressed file. Error responses, small bodies and content of
+// already compressed MIME types are sent as they are. Compressed responses
+// carry the file's ETag as a weak one.
+type EncodingWriter struct {
+	http.ResponseWriter
+	encoding    string
+	ifNoneMatch string // Of the request, to answer a 304 with the ETag the client has
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+// NewEncodingWriter wraps w for a response to r; Close must be called once
+// the response is written
+func NewEncodingWriter(w http.ResponseWriter, r *http.Request) *EncodingWriter {
+	ew := &EncodingWriter{ResponseWriter: w, ifNoneMatch: r.Header.Get("If-None-Match")}
+	if r.Method != http.MethodHead {
+		ew.encoding = common.NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+	}
+	return ew
+}
+
+// WriteHeader decides whether the response is compressed
+func (ew *EncodingWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+
+	header := ew.Header()
+	if code == http.StatusOK || code == http.StatusPartialContent || code == http.StatusNotModified {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if etag := header.Get("ETag"); code == http.StatusNotModified && etag != "" &&
+		!strings.HasPrefix(etag, "W/") && strings.Contains(ew.ifNoneMatch, "W/"+etag) {
+		header.Set("ETag", "W/"+etag) // The client validated a compressed copy
+	}
+	if ew.encoding != "" && (code == http.StatusOK || code == http.StatusPartialContent) &&
+		header.Get("Content-Encoding") == "" && common.IsCompressibleType(header.Get("Content-Type")) {
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil || size >= common.MinEncodeSize {
+			if encoder, err := common.NewEncoder(ew.ResponseWriter, ew.encoding); err == nil {
+				ew.encoder = encoder
+				header.Del("Content-Length")
+				header.Set("Content-Encoding", ew.encoding)
+				// The file's strong ETag names its identity bytes; the
+				// encoded body only matches it weakly, so caches and
+				// If-Range do not mix it up with an identity response
+				if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+					header.Set("ETag", "W/"+etag)
+				}
+			}
+		}
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *EncodingWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.encoder != nil {
+		return ew.encoder.Write(b)
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Close completes the compressed stream, if any
+func (ew *EncodingWriter) Close() error {
+	if ew.encoder == nil {
+		return nil
+	}
+	return ew.encoder.Close()
+}
+
+// decodeRequestBody replaces the body of a request sent with a
+// Content-Encoding by its decompressed content, whose size is then unknown.
+// Unsupported encodings are answered with 415 and false.
+func decodeRequestBody(w http.ResponseWriter, r *http.Request) bool {
+	encoding := r.Header.Get("Content-Encoding")
+	if encoding == "" || encoding == "identity" {
+		return true
+	}
+	if common.NegotiateEncoding(encoding) == "" {
+		http.Error(w, fmt.Sprintf("Unsupported content encoding: %s", encoding), http.StatusUnsupportedMediaType)
+		return false
+	}
+	body, err := common.NewDecoder(r.Body, encoding)
+	if err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	r.Body = body
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return true
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fileLocks holds a mutex per file path, shared by all handlers so that a
+// precondition check and the write it guards are atomic
+var fileLocks sync.Map // map[string]*sync.Mutex
+
+// fileLock returns the mutex for a file path
+func fileLock(path string) *sync.Mutex {
+	lock, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// lockFiles locks the mutexes of several paths in a fixed order, so that
+// handlers locking more than one never deadlock, and returns the unlock
+func lockFiles(paths ...string) func() {
+	sorted := append([]string(nil), paths...)
+	sort.Strings(sorted)
+	var locked []*sync.Mutex
+	for i, p := range sorted {
+		if i > 0 && p == sorted[i-1] {
+			continue
+		}
+		lock := fileLock(p)
+		lock.Lock()
+		locked = append(locked, lock)
+	}
+	return func() {
+		for _, lock := range locked {
+			lock.Unlock()
+		}
+	}
+}
+
+// fileETag returns a strong validator for a file built from its size,
+// modification time and inode, so replacing a file by rename changes it even
+// when size and time match. Symlinks get none: requests act on the target,
+// whose validator differs.
+func fileETag(info os.FileInfo) string {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return ""
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, info.Size(), info.ModTime().UnixNano(), statInode(info))
+}
+
+// currentETag returns the ETag of the file at fullPath, empty if it does not exist
+func currentETag(fullPath string) string {
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return ""
+	}
+	return fileETag(info)
+}
+
+// preconditions holds the If-Match and If-None-Match headers of a write. They
+// are kept with upload sessions so the commit can check them again.
+type preconditions struct {
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneMatch string `json:"ifNoneMatch,omitempty"`
+}
+
+// preconditionsFromRequest reads the If-Match and If-None-Match headers
+func preconditionsFromRequest(r *http.Request) preconditions {
+	return preconditions{
+		IfMatch:     strings.TrimSpace(r.Header.Get("If-Match")),
+		IfNoneMatch: strings.TrimSpace(r.Header.Get("If-None-Match")),
+	}
+}
+
+// empty returns true if the request carried no preconditions
+func (p preconditions) empty() bool {
+	return p.IfMatch == "" && p.IfNoneMatch == ""
+}
+
+// hold reports whether the preconditions are met by a file whose current
+// ETag is etag (empty for a missing file). "*" matches any existing file.
+func (p preconditions) hold(etag string) bool {
+	if p.IfMatch != "" && (etag == "" || !etagListHas(p.IfMatch, etag)) {
+		return false
+	}
+	if p.IfNoneMatch != "" && etag != "" && etagListHas(p.IfNoneMatch, etag) {
+		return false
+	}
+	return true
+}
+
+// check evaluates the preconditions against fullPath. On failure it answers
+// 412 with the file's current ETag and returns false.
+func (p preconditions) check(w http.ResponseWriter, fullPath string) bool {
+	if p.empty() {
+		return true
+	}
+	etag := currentETag(fullPath)
+	if p.hold(etag) {
+		return true
+	}
+	writePreconditionFailed(w, etag)
+	return false
+}
+
+// writePreconditionFailed answers 412, telling the client the current ETag
+// (none if the file does not exist) so it can reload and retry
+func writePreconditionFailed(w http.ResponseWriter, etag string) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.Error(w, "Precondition failed: file changed on server", http.StatusPreconditionFailed)
+}
+
+// checkPreconditions evaluates the If-Match/If-None-Match headers of r against
+// fullPath, answering 412 and returning false if they fail
+func checkPreconditions(w http.ResponseWriter, r *http.Request, fullPath string) bool {
+	return preconditionsFromRequest(r).check(w, fullPath)
+}
+
+// etagListHas reports whether a comma separated If-Match style list contains
+// etag or "*". Weak prefixes are ignored, since fileETag only issues strong tags.
+func etagListHas(list, etag string) bool {
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "*" || strings.TrimPrefix(e, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
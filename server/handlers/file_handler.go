@@ -8,9 +8,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
 )
 
 // ListItem represents a file or directory in the listing
@@ -21,12 +22,18 @@ type ListItem struct {
 	ModTime int64  `json:"modTime"`
 	IsDir   bool   `json:"isDir"`
 	Mode    string `json:"mode"` // Simplified permissions string
+	ETag    string `json:"etag,omitempty"`
+	FileMeta
 }
 
 // FileHandler handles file operations
 type FileHandler struct {
-	rootDir   string
-	hashCache sync.Map
+	rootDir    string
+	hashCache  *ChecksumCache // Shared with the other handlers; nil disables caching
+	userNames  sync.Map       // uid -> user name
+	groupNames sync.Map       // gid -> group name
+	du         duCache        // Cached directory sizes for the du action
+	rules      *UploadRules
 }
 
 // NewFileHandler creates a new file handler
@@ -72,8 +79,9 @@ func (h *FileHandler) isPathSafe(requestPath string) (string, bool) {
 	return fullPath, true
 }
 
-// ListFiles returns a list of files in the specified directory
-func (h *FileHandler) ListFiles(rel string, recursive bool) ([]ListItem, error) {
+// ListFiles returns a list of files in the specified directory.
+// Optional metadata is only collected for the groups set in fields.
+func (h *FileHandler) ListFiles(rel string, recursive bool, fields metaFields) ([]ListItem, error) {
 	rel = h.cleanRelPath(rel)
 	target, safe := h.isPathSafe(rel)
 	if !safe {
@@ -95,12 +103,14 @@ func (h *FileHandler) ListFiles(rel string, recursive bool) ([]ListItem, error)
 			}
 			relPath, _ := filepath.Rel(h.rootDir, p)
 			items = append(items, ListItem{
-				Name:    d.Name(),
-				Path:    "/" + filepath.ToSlash(relPath),
-				Size:    info.Size(),
-				ModTime: info.ModTime().Unix(),
-				IsDir:   info.IsDir(),
-				Mode:    info.Mode().String(),
+				Name:     d.Name(),
+				Path:     "/" + filepath.ToSlash(relPath),
+				Size:     info.Size(),
+				ModTime:  info.ModTime().Unix(),
+				IsDir:    info.IsDir(),
+				Mode:     info.Mode().String(),
+				ETag:     fileETag(info),
+				FileMeta: h.buildMeta(p, info, fields),
 			})
 			return nil
 		})
@@ -118,12 +128,14 @@ func (h *FileHandler) ListFiles(rel string, recursive bool) ([]ListItem, error)
 			continue
 		}
 		items = append(items, ListItem{
-			Name:    e.Name(),
-			Path:    "/" + path.Join(rel, e.Name()),
-			Size:    info.Size(),
-			ModTime: info.ModTime().Unix(),
-			IsDir:   e.IsDir(),
-			Mode:    info.Mode().String(),
+			Name:     e.Name(),
+			Path:     "/" + path.Join(rel, e.Name()),
+			Size:     info.Size(),
+			ModTime:  info.ModTime().Unix(),
+			IsDir:    e.IsDir(),
+			Mode:     info.Mode().String(),
+			ETag:     fileETag(info),
+			FileMeta: h.buildMeta(filepath.Join(target, e.Name()), info, fields),
 		})
 	}
 	return items, nil
@@ -133,7 +145,8 @@ func (h *FileHandler) ListFiles(rel string, recursive bool) ([]ListItem, error)
 func (h *FileHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 	rel := r.URL.Query().Get("path")
 	recursive := r.URL.Query().Get("recursive") == "1"
-	files, err := h.ListFiles(rel, recursive)
+	fields := parseMetaFields(r.URL.Query().Get("fields"))
+	files, err := h.ListFiles(rel, recursive, fields)
 	if err != nil {
 		http.Error(w, "Cannot list files", http.StatusInternalServerError)
 		return
@@ -166,8 +179,12 @@ func (h *FileHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
 
 	// Delete file or directory
+	defer h.forgetChecksums(cleanPath)
 	if info.IsDir() {
 		err = os.RemoveAll(cleanPath)
 	} else {
@@ -197,6 +214,10 @@ func (h *FileHandler) HandleMkdir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
+
 	// Create directory with parents
 	err := os.MkdirAll(cleanPath, 0755)
 	if err != nil {
@@ -223,6 +244,12 @@ func (h *FileHandler) HandleRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, err := conflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	cleanOldPath, safe := h.isPathSafe(oldPath)
 	if !safe {
 		http.Error(w, "Invalid old path", http.StatusBadRequest)
@@ -234,9 +261,13 @@ func (h *FileHandler) HandleRename(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid new path", http.StatusBadRequest)
 		return
 	}
+	if isReservedName(cleanNewPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
 
-	// Check if old path exists
-	_, err := os.Stat(cleanOldPath)
+	// Check if old path exists (Lstat so symlinks are moved, not followed)
+	srcInfo, err := os.Lstat(cleanOldPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "Source not found", http.StatusNotFound)
@@ -246,17 +277,90 @@ func (h *FileHandler) HandleRename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If-Match guards the source the client saw, If-None-Match the
+	// destination (say "*" to never replace anything there)
+	defer lockFiles(cleanOldPath, cleanNewPath)()
+	pre := preconditionsFromRequest(r)
+	if !(preconditions{IfMatch: pre.IfMatch}).check(w, cleanOldPath) || !(preconditions{IfNoneMatch: pre.IfNoneMatch}).check(w, cleanNewPath) {
+		return
+	}
+
+	if srcInfo.IsDir() && isSubPath(cleanOldPath, cleanNewPath) {
+		http.Error(w, "Cannot move a directory into itself", http.StatusBadRequest)
+		return
+	}
+
+	// Moving must not bring in files the destination's upload rules forbid
+	if err := h.checkTree(cleanOldPath, cleanNewPath); err != nil {
+		if !writeRuleError(w, err) {
+			http.Error(w, "Failed to check source: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// Rename
-	err = os.Rename(cleanOldPath, cleanNewPath)
+	finalPath, result, err := h.movePath(cleanOldPath, cleanNewPath, srcInfo, policy)
+	h.forgetChecksums(cleanOldPath)
+	h.forgetChecksums(finalPath)
 	if err != nil {
+		if h.writeConflictError(w, err, policy, srcInfo) {
+			return
+		}
 		http.Error(w, "Failed to rename: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.setConflictHeaders(w, result, finalPath)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// movePath moves src to dst honoring policy and returns the final path and outcome.
+// A directory moved onto an existing directory under overwrite/newer is merged.
+func (h *FileHandler) movePath(src, dst string, srcInfo os.FileInfo, policy common.ConflictPolicy) (string, string, error) {
+	// Renaming onto itself (e.g. a case-only rename) is not a conflict
+	if dstInfo, err := os.Lstat(dst); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return dst, common.ConflictResultCreated, os.Rename(src, dst)
+	}
+
+	target, result, err := common.ResolveConflict(dst, srcInfo.IsDir(), srcInfo.ModTime(), policy)
+	if err != nil || target == "" {
+		return target, result, err
+	}
+
+	if result == common.ConflictResultOverwritten && srcInfo.IsDir() {
+		return target, result, h.mergeDir(src, target, policy)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", "", err
+	}
+	return target, result, os.Rename(src, target)
+}
+
+// mergeDir moves the contents of src into the existing directory dst,
+// resolving every entry with policy, then removes src
+func (h *FileHandler) mergeDir(src, dst string, policy common.ConflictPolicy) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if _, _, err := h.movePath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), info, policy); err != nil {
+			return err
+		}
+	}
+
+	// Entries skipped under "newer" stay behind, in which case src is kept
+	os.Remove(src)
+	return nil
+}
+
 // HandleCopy handles file/directory copying
 func (h *FileHandler) HandleCopy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -272,6 +376,12 @@ func (h *FileHandler) HandleCopy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	policy, err := conflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	cleanSrcPath, safe := h.isPathSafe(srcPath)
 	if !safe {
 		http.Error(w, "Invalid source path", http.StatusBadRequest)
@@ -283,9 +393,13 @@ func (h *FileHandler) HandleCopy(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid destination path", http.StatusBadRequest)
 		return
 	}
+	if isReservedName(cleanDstPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
 
-	// Check if source exists
-	srcInfo, err := os.Stat(cleanSrcPath)
+	// Check if source exists; a symlink is copied as a link
+	srcInfo, err := os.Lstat(cleanSrcPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "Source not found", http.StatusNotFound)
@@ -295,18 +409,68 @@ func (h *FileHandler) HandleCopy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Copy file or directory
+	// Compare where the paths really lead, as symlinks on the way (say
+	// d -> .) can put the destination inside the source
+	realDstPath, err := h.resolveInRoot("", path.Clean("/"+dstPath))
+	if err != nil {
+		http.Error(w, "Invalid destination path", http.StatusBadRequest)
+		return
+	}
 	if srcInfo.IsDir() {
-		err = h.copyDir(cleanSrcPath, cleanDstPath)
-	} else {
-		err = h.copyFile(cleanSrcPath, cleanDstPath)
+		realSrcPath, err := filepath.EvalSymlinks(cleanSrcPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if isSubPath(realSrcPath, realDstPath) {
+			http.Error(w, "Cannot copy a directory into itself", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Every file must be allowed at its destination before anything is copied
+	if err := h.checkTree(cleanSrcPath, cleanDstPath); err != nil {
+		if !writeRuleError(w, err) {
+			http.Error(w, "Failed to check source: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The destination is the only path written
+	defer lockFiles(cleanDstPath)()
+	if !checkPreconditions(w, r, cleanDstPath) {
+		return
+	}
+
+	// Copying a file onto itself would truncate it; only rename makes sense
+	if dstInfo, err := os.Stat(cleanDstPath); err == nil && os.SameFile(srcInfo, dstInfo) && policy != common.ConflictRename {
+		http.Error(w, "Source and destination are the same", http.StatusBadRequest)
+		return
+	}
+
+	target, result, err := common.ResolveConflict(cleanDstPath, srcInfo.IsDir(), srcInfo.ModTime(), policy)
+	if err == nil && target != "" {
+		defer h.forgetChecksums(target)
+		// Copy file, link or directory
+		switch {
+		case srcInfo.IsDir():
+			err = h.copyDir(cleanSrcPath, target, policy)
+		case srcInfo.Mode()&os.ModeSymlink != 0:
+			err = h.copyLink(cleanSrcPath, target)
+		default:
+			err = h.copyFile(cleanSrcPath, target)
+		}
 	}
 
 	if err != nil {
+		if h.writeConflictError(w, err, policy, srcInfo) {
+			return
+		}
 		http.Error(w, "Failed to copy: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	h.setConflictHeaders(w, result, target)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
@@ -330,6 +494,13 @@ func (h *FileHandler) copyFile(src, dst string) error {
 		return err
 	}
 
+	// Replace a symlink at dst instead of writing through it
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+
 	dstFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, srcInfo.Mode())
 	if err != nil {
 		return err
@@ -340,8 +511,47 @@ func (h *FileHandler) copyFile(src, dst string) error {
 	return err
 }
 
-// copyDir recursively copies a directory
-func (h *FileHandler) copyDir(src, dst string) error {
+// copyLink recreates the symlink src at dst with the same target. A link
+// that would lead out of the root from its new place is left out.
+func (h *FileHandler) copyLink(src, dst string) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// Relative targets are resolved from the copy's directory
+	dir, err := filepath.EvalSymlinks(filepath.Dir(dst))
+	if err == nil {
+		if filepath.IsAbs(target) {
+			var real string
+			if real, err = filepath.EvalSymlinks(target); err == nil {
+				_, err = h.resolveInRoot(real, "")
+			}
+		} else {
+			_, err = h.resolveInRoot(dir, filepath.ToSlash(target))
+		}
+	}
+	if err != nil {
+		fmt.Printf("[DEBUG] Not copying symlink %s -> %s: %v\n", src, target, err)
+		return nil
+	}
+	// Overwriting replaces the entry itself
+	if info, err := os.Lstat(dst); err == nil && !info.IsDir() {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, dst)
+}
+
+// copyDir recursively copies a directory, resolving entries that already
+// exist in dst with policy (dst may be an existing directory being merged).
+// Symlinks are copied as links rather than followed, and devices, sockets
+// and pipes are left out.
+func (h *FileHandler) copyDir(src, dst string, policy common.ConflictPolicy) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -359,88 +569,301 @@ func (h *FileHandler) copyDir(src, dst string) error {
 
 	for _, entry := range entries {
 		srcPath := filepath.Join(src, entry.Name())
-		dstPath := filepath.Join(dst, entry.Name())
+		info, err := os.Lstat(srcPath)
+		if err != nil {
+			return err
+		}
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if !info.IsDir() && !isLink && !info.Mode().IsRegular() {
+			continue
+		}
 
-		if entry.IsDir() {
-			if err := h.copyDir(srcPath, dstPath); err != nil {
-				return err
-			}
-		} else {
-			if err := h.copyFile(srcPath, dstPath); err != nil {
-				return err
-			}
+		dstPath, _, err := common.ResolveConflict(filepath.Join(dst, entry.Name()), info.IsDir(), info.ModTime(), policy)
+		if err != nil {
+			return err
+		}
+		if dstPath == "" {
+			continue // Skipped
+		}
+
+		switch {
+		case info.IsDir():
+			err = h.copyDir(srcPath, dstPath, policy)
+		case isLink:
+			err = h.copyLink(srcPath, dstPath)
+		default:
+			err = h.copyFile(srcPath, dstPath)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// FileStatInfo represents detailed file information
-type FileStatInfo struct {
-	Name    string `json:"name"`
-	Path    string `json:"path"`
-	Size    int64  `json:"size"`
-	ModTime int64  `json:"modTime"`
-	IsDir   bool   `json:"isDir"`
-	Mode    string `json:"mode"`
-	ModeNum uint32 `json:"modeNum"` // Numeric mode for chmod
+// isSubPath reports whether child is parent itself or located inside it
+func isSubPath(parent, child string) bool {
+	absParent, err := filepath.Abs(parent)
+	if err != nil {
+		return false
+	}
+	absChild, err := filepath.Abs(child)
+	if err != nil {
+		return false
+	}
+	return absChild == absParent || strings.HasPrefix(absChild, absParent+string(filepath.Separator))
 }
 
-// HandleStat handles GET /stat requests to get file attributes
-func (h *FileHandler) HandleStat(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// HandleSymlink handles POST requests to create a symbolic link.
+// path is the link to create, target is what it points to.
+func (h *FileHandler) HandleSymlink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	filePath := r.URL.Query().Get("path")
-	if filePath == "" {
-		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+	linkPath := r.URL.Query().Get("path")
+	target := r.URL.Query().Get("target")
+
+	if linkPath == "" || target == "" {
+		http.Error(w, "Missing path or target parameter", http.StatusBadRequest)
 		return
 	}
 
-	cleanPath, safe := h.isPathSafe(filePath)
+	cleanLinkPath, safe := h.isPathSafe(linkPath)
 	if !safe {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
+		http.Error(w, "Invalid link path", http.StatusBadRequest)
+		return
+	}
+	if isReservedName(cleanLinkPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
 		return
 	}
 
-	info, err := os.Stat(cleanPath)
+	linkTarget, err := h.resolveLinkTarget(linkPath, target)
+	if err != nil {
+		http.Error(w, "Invalid target: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Never replace an existing entry
+	if _, err := os.Lstat(cleanLinkPath); err == nil {
+		http.Error(w, "Destination already exists", http.StatusConflict)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cleanLinkPath), 0755); err != nil {
+		http.Error(w, "Failed to create directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.Symlink(linkTarget, cleanLinkPath); err != nil {
+		http.Error(w, "Failed to create symlink: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "OK\n%s -> %s", linkPath, filepath.ToSlash(linkTarget))
+}
+
+// HandleHardlink handles POST requests to create a hard link.
+// path is the new link, target is an existing regular file.
+func (h *FileHandler) HandleHardlink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	linkPath := r.URL.Query().Get("path")
+	target := r.URL.Query().Get("target")
+
+	if linkPath == "" || target == "" {
+		http.Error(w, "Missing path or target parameter", http.StatusBadRequest)
+		return
+	}
+
+	cleanLinkPath, safe := h.isPathSafe(linkPath)
+	if !safe {
+		http.Error(w, "Invalid link path", http.StatusBadRequest)
+		return
+	}
+	if isReservedName(cleanLinkPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
+
+	cleanTarget, safe := h.isPathSafe(target)
+	if !safe {
+		http.Error(w, "Invalid target path", http.StatusBadRequest)
+		return
+	}
+
+	// Symlinked folders on either path must not lead out of the root
+	if _, err := h.resolveInRoot("", path.Dir(path.Clean("/"+linkPath))); err != nil {
+		http.Error(w, "Invalid link path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err := h.resolveInRoot("", path.Clean("/"+target)); err != nil {
+		http.Error(w, "Invalid target path: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only regular files can be hard linked; Lstat so a symlink escaping
+	// the root cannot be used to link to a file outside of it
+	info, err := os.Lstat(cleanTarget)
 	if err != nil {
 		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
+			http.Error(w, "Target not found", http.StatusNotFound)
 			return
 		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !info.Mode().IsRegular() {
+		http.Error(w, "Target must be a regular file", http.StatusBadRequest)
+		return
+	}
 
-	statInfo := FileStatInfo{
-		Name:    info.Name(),
-		Path:    filePath,
-		Size:    info.Size(),
-		ModTime: info.ModTime().Unix(),
-		IsDir:   info.IsDir(),
-		Mode:    info.Mode().String(),
-		ModeNum: uint32(info.Mode().Perm()),
+	if _, err := os.Lstat(cleanLinkPath); err == nil {
+		http.Error(w, "Destination already exists", http.StatusConflict)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(statInfo)
+	if err := os.MkdirAll(filepath.Dir(cleanLinkPath), 0755); err != nil {
+		http.Error(w, "Failed to create directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := os.Link(cleanTarget, cleanLinkPath); err != nil {
+		http.Error(w, "Failed to create hard link: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "OK\n%s => %s", linkPath, target)
 }
 
-// HandleChmod handles POST /chmod requests to change file permissions
-func (h *FileHandler) HandleChmod(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+// resolveLinkTarget validates a symlink target and returns the value to store on disk.
+// Absolute targets are interpreted relative to the served root, relative targets
+// relative to the link's directory. The resolved target must stay inside the root,
+// and it is always stored as a relative path so the tree can be moved as a whole.
+func (h *FileHandler) resolveLinkTarget(linkPath, target string) (string, error) {
+	target = strings.ReplaceAll(target, "\\", "/")
+	linkDir := strings.TrimPrefix(path.Dir(path.Clean("/"+linkPath)), "/")
+
+	var rel string
+	if path.IsAbs(target) {
+		rel = strings.TrimPrefix(path.Clean(target), "/")
+	} else {
+		// Clean without a leading slash keeps ".." so escapes are detectable
+		rel = path.Clean(path.Join(linkDir, target))
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			return "", fmt.Errorf("target escapes the root directory")
+		}
+	}
+	if rel == "." {
+		rel = ""
+	}
+
+	if _, safe := h.isPathSafe("/" + rel); !safe {
+		return "", fmt.Errorf("target escapes the root directory")
+	}
+
+	if linkDir == "" {
+		linkDir = "."
+	}
+	if rel == "" {
+		rel = "."
+	}
+	stored, err := filepath.Rel(filepath.FromSlash(linkDir), filepath.FromSlash(rel))
+	if err != nil {
+		return "", err
+	}
+
+	// The checks above are lexical; symlinks already on the way (say d -> .)
+	// change where ".." leads, so follow the stored value as the OS will
+	parent, err := h.resolveInRoot("", linkDir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := h.resolveInRoot(parent, filepath.ToSlash(stored)); err != nil {
+		return "", err
+	}
+	return stored, nil
+}
+
+// resolveInRoot resolves rel, a slash separated path relative to dir (the
+// root if empty), the way the OS does: symlinks met on the way are followed
+// before a later ".." applies. Components that do not exist yet are taken
+// as they are. It returns the physical path, or an error if that lies
+// outside the root or a symlink cannot be resolved.
+func (h *FileHandler) resolveInRoot(dir, rel string) (string, error) {
+	absRoot, err := filepath.Abs(h.rootDir)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		dir = root
+	}
+
+	current := dir
+	for _, part := range strings.Split(rel, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if current, err = filepath.EvalSymlinks(current); err != nil {
+				return "", fmt.Errorf("cannot resolve %s: %w", part, err)
+			}
+		}
+	}
+
+	if !isSubPath(root, current) {
+		return "", fmt.Errorf("path escapes the root directory")
+	}
+	return current, nil
+}
+
+// FileStatInfo represents detailed file information
+type FileStatInfo struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	IsDir   bool   `json:"isDir"`
+	Mode    string `json:"mode"`
+	ModeNum uint32 `json:"modeNum"` // Numeric mode for chmod
+	ETag    string `json:"etag,omitempty"`
+	FileMeta
+}
+
+// HandleStat handles GET /stat requests to get file attributes
+func (h *FileHandler) HandleStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	filePath := r.URL.Query().Get("path")
-	modeStr := r.URL.Query().Get("mode")
-
-	if filePath == "" || modeStr == "" {
-		http.Error(w, "Missing path or mode parameter", http.StatusBadRequest)
+	if filePath == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
 		return
 	}
 
@@ -450,15 +873,8 @@ func (h *FileHandler) HandleChmod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse mode (supports octal like "755" or "0755")
-	mode, err := strconv.ParseUint(modeStr, 8, 32)
+	info, err := os.Stat(cleanPath)
 	if err != nil {
-		http.Error(w, "Invalid mode format (use octal like 755)", http.StatusBadRequest)
-		return
-	}
-
-	// Check if file exists
-	if _, err := os.Stat(cleanPath); err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "File not found", http.StatusNotFound)
 			return
@@ -467,13 +883,27 @@ func (h *FileHandler) HandleChmod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Change mode
-	err = os.Chmod(cleanPath, os.FileMode(mode))
-	if err != nil {
-		http.Error(w, "Failed to change permissions: "+err.Error(), http.StatusInternalServerError)
-		return
+	// Optional metadata describes the path itself, so symlinks are not followed
+	var meta FileMeta
+	fields := parseMetaFields(r.URL.Query().Get("fields"))
+	if fields.any() {
+		if linfo, err := os.Lstat(cleanPath); err == nil {
+			meta = h.buildMeta(cleanPath, linfo, fields)
+		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK\nMode changed to %o", mode)
+	statInfo := FileStatInfo{
+		Name:     info.Name(),
+		Path:     filePath,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().Unix(),
+		IsDir:    info.IsDir(),
+		Mode:     info.Mode().String(),
+		ModeNum:  uint32(info.Mode().Perm()),
+		ETag:     fileETag(info),
+		FileMeta: meta,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statInfo)
 }
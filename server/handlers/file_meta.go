@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// FileMeta holds optional file metadata requested via the fields parameter.
+// All fields are omitted from JSON unless requested and available.
+type FileMeta struct {
+	IsSymlink  bool    `json:"isSymlink,omitempty"`
+	LinkTarget string  `json:"linkTarget,omitempty"`
+	LinkIsDir  bool    `json:"linkIsDir,omitempty"` // Symlink resolves to a directory
+	UID        *uint32 `json:"uid,omitempty"`
+	GID        *uint32 `json:"gid,omitempty"`
+	Owner      string  `json:"owner,omitempty"`
+	Group      string  `json:"group,omitempty"`
+	AccessTime int64   `json:"atime,omitempty"`
+	ChangeTime int64   `json:"ctime,omitempty"`
+	Inode      uint64  `json:"inode,omitempty"`
+	Nlink      uint64  `json:"nlink,omitempty"`
+	Hidden     bool    `json:"hidden,omitempty"`
+	MimeType   string  `json:"mimeType,omitempty"`
+}
+
+// metaFields records which optional metadata groups a request asked for
+type metaFields struct {
+	link   bool
+	owner  bool
+	times  bool
+	inode  bool
+	hidden bool
+	mime   bool
+}
+
+// sysInfo holds platform specific stat data (see file_meta_*.go)
+type sysInfo struct {
+	hasOwner bool
+	uid      uint32
+	gid      uint32
+	atime    int64
+	ctime    int64
+	inode    uint64
+	nlink    uint64
+	hidden   bool
+}
+
+// parseMetaFields parses a comma separated fields parameter such as "owner,mime"
+func parseMetaFields(raw string) metaFields {
+	var f metaFields
+	for _, name := range strings.Split(raw, ",") {
+		switch strings.TrimSpace(name) {
+		case common.FieldAll:
+			return metaFields{link: true, owner: true, times: true, inode: true, hidden: true, mime: true}
+		case common.FieldLink:
+			f.link = true
+		case common.FieldOwner:
+			f.owner = true
+		case common.FieldTimes:
+			f.times = true
+		case common.FieldInode:
+			f.inode = true
+		case common.FieldHidden:
+			f.hidden = true
+		case common.FieldMime:
+			f.mime = true
+		}
+	}
+	return f
+}
+
+// any returns true if at least one optional field was requested
+func (f metaFields) any() bool {
+	return f.link || f.owner || f.times || f.inode || f.hidden || f.mime
+}
+
+// buildMeta collects the requested optional metadata for a path.
+// info should come from os.Lstat so that symlinks are reported as links.
+func (h *FileHandler) buildMeta(fullPath string, info os.FileInfo, fields metaFields) FileMeta {
+	var meta FileMeta
+	if !fields.any() {
+		return meta
+	}
+
+	if fields.link && info.Mode()&os.ModeSymlink != 0 {
+		meta.IsSymlink = true
+		if target, err := os.Readlink(fullPath); err == nil {
+			meta.LinkTarget = filepath.ToSlash(target)
+		}
+		if resolved, err := os.Stat(fullPath); err == nil {
+			meta.LinkIsDir = resolved.IsDir()
+		}
+	}
+
+	if fields.owner || fields.times || fields.inode || fields.hidden {
+		sys := statSys(fullPath, info)
+		if fields.owner && sys.hasOwner {
+			uid, gid := sys.uid, sys.gid
+			meta.UID = &uid
+			meta.GID = &gid
+			meta.Owner = h.lookupUser(uid)
+			meta.Group = h.lookupGroup(gid)
+		}
+		if fields.times {
+			meta.AccessTime = sys.atime
+			meta.ChangeTime = sys.ctime
+		}
+		if fields.inode {
+			meta.Inode = sys.inode
+			meta.Nlink = sys.nlink
+		}
+		if fields.hidden {
+			meta.Hidden = sys.hidden || strings.HasPrefix(info.Name(), ".")
+		}
+	}
+
+	if fields.mime {
+		meta.MimeType = sniffMimeType(fullPath, info)
+	}
+
+	return meta
+}
+
+// lookupUser resolves a uid to a user name, caching the result
+func (h *FileHandler) lookupUser(uid uint32) string {
+	return cachedLookup(&h.userNames, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+// lookupGroup resolves a gid to a group name, caching the result
+func (h *FileHandler) lookupGroup(gid uint32) string {
+	return cachedLookup(&h.groupNames, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// cachedLookup looks up a numeric id through cache, falling back to the id itself
+func cachedLookup(cache *sync.Map, id uint32, lookup func(string) (string, error)) string {
+	if name, ok := cache.Load(id); ok {
+		return name.(string)
+	}
+	idStr := strconv.FormatUint(uint64(id), 10)
+	name, err := lookup(idStr)
+	if err != nil || name == "" {
+		name = idStr
+	}
+	cache.Store(id, name)
+	return name
+}
+
+// sniffMimeType detects the MIME type of a file from its first 512 bytes,
+// falling back to the extension when the content is not conclusive
+func sniffMimeType(fullPath string, info os.FileInfo) string {
+	switch {
+	case info.IsDir():
+		return "inode/directory"
+	case info.Mode()&os.ModeSymlink != 0:
+		return "inode/symlink"
+	case !info.Mode().IsRegular():
+		return "application/octet-stream"
+	case info.Size() == 0:
+		return "application/x-empty"
+	}
+
+	byExt := mime.TypeByExtension(filepath.Ext(fullPath))
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return byExt
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := f.Read(buf)
+	sniffed := http.DetectContentType(buf[:n])
+
+	// DetectContentType only knows a few dozen signatures; prefer the
+	// extension when the sniffer falls back to a generic type
+	if byExt != "" && (sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/plain")) {
+		return byExt
+	}
+	return sniffed
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"os"
+	"syscall"
+)
+
+// statSys extracts ownership, timestamps and inode data from a Linux stat result
+func statSys(fullPath string, info os.FileInfo) sysInfo {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return sysInfo{}
+	}
+	return sysInfo{
+		hasOwner: true,
+		uid:      st.Uid,
+		gid:      st.Gid,
+		atime:    int64(st.Atim.Sec),
+		ctime:    int64(st.Ctim.Sec),
+		inode:    st.Ino,
+		nlink:    uint64(st.Nlink),
+	}
+}
+
+// statInode returns the inode number from info, 0 if unavailable
+func statInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+//go:build !linux && !windows
+
+package handlers
+
+import (
+	"os"
+	"syscall"
+)
+
+// statSys extracts ownership and inode data on other Unix systems.
+// Timestamp field names differ between BSDs, so atime/ctime are not reported here.
+func statSys(fullPath string, info os.FileInfo) sysInfo {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return sysInfo{}
+	}
+	return sysInfo{
+		hasOwner: true,
+		uid:      st.Uid,
+		gid:      st.Gid,
+		inode:    uint64(st.Ino),
+		nlink:    uint64(st.Nlink),
+	}
+}
+
+// statInode returns the inode number from info, 0 if unavailable
+func statInode(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
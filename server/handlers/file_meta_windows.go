@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"os"
+	"syscall"
+)
+
+// statSys extracts timestamps, the hidden attribute and the file index on Windows.
+// Windows has no uid/gid, so ownership is never reported. ctime is the creation time.
+func statSys(fullPath string, info os.FileInfo) sysInfo {
+	var sys sysInfo
+	if attr, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		sys.atime = attr.LastAccessTime.Nanoseconds() / 1e9
+		sys.ctime = attr.CreationTime.Nanoseconds() / 1e9
+		sys.hidden = attr.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+	}
+
+	// File index and link count need an open handle
+	pathPtr, err := syscall.UTF16PtrFromString(fullPath)
+	if err != nil {
+		return sys
+	}
+	handle, err := syscall.CreateFile(pathPtr, 0,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OPEN_REPARSE_POINT, 0)
+	if err != nil {
+		return sys
+	}
+	defer syscall.CloseHandle(handle)
+
+	var fi syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(handle, &fi); err == nil {
+		sys.inode = uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow)
+		sys.nlink = uint64(fi.NumberOfLinks)
+	}
+	return sys
+}
+
+// statInode returns 0: the file index needs an open handle on Windows, which
+// is too costly per directory entry
+func statInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,452 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// PermChange describes one mode or ownership change (planned or applied)
+type PermChange struct {
+	Path     string `json:"path"`
+	IsDir    bool   `json:"isDir"`
+	OldMode  string `json:"oldMode,omitempty"` // Octal, e.g. "0644"
+	NewMode  string `json:"newMode,omitempty"`
+	OldOwner string `json:"oldOwner,omitempty"` // "user:group"
+	NewOwner string `json:"newOwner,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PermResult is the JSON response of chmod and chown.
+// Only entries that actually change are listed.
+type PermResult struct {
+	DryRun  bool         `json:"dryRun"`
+	Changed int          `json:"changed"`
+	Failed  int          `json:"failed"`
+	Changes []PermChange `json:"changes"`
+}
+
+// add records a change and updates the counters
+func (res *PermResult) add(change PermChange) {
+	if change.Error != "" {
+		res.Failed++
+	} else {
+		res.Changed++
+	}
+	res.Changes = append(res.Changes, change)
+}
+
+// modeSpec is a parsed chmod mode, either absolute octal ("755") or a list of
+// symbolic clauses ("u+x,go-w", "a=rX")
+type modeSpec struct {
+	octal   bool
+	value   uint32
+	clauses []modeClause
+}
+
+// modeClause is one symbolic clause: who ("ugoa") followed by operations
+type modeClause struct {
+	who uint32 // Mask of affected bits
+	ops []modeOp
+}
+
+type modeOp struct {
+	op    byte // '+', '-' or '='
+	perms string
+}
+
+// parseModeSpec parses an octal or symbolic mode
+func parseModeSpec(s string) (*modeSpec, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	if s[0] >= '0' && s[0] <= '7' {
+		value, err := strconv.ParseUint(s, 8, 32)
+		if err != nil || value > 07777 {
+			return nil, fmt.Errorf("invalid octal mode: %s", s)
+		}
+		return &modeSpec{octal: true, value: uint32(value)}, nil
+	}
+
+	spec := &modeSpec{}
+	for _, part := range strings.Split(s, ",") {
+		var clause modeClause
+		i := 0
+		for ; i < len(part) && strings.IndexByte("ugoa", part[i]) >= 0; i++ {
+			switch part[i] {
+			case 'u':
+				clause.who |= 04700
+			case 'g':
+				clause.who |= 02070
+			case 'o':
+				clause.who |= 01007
+			case 'a':
+				clause.who |= 07777
+			}
+		}
+		if clause.who == 0 {
+			clause.who = 07777
+		}
+
+		for i < len(part) {
+			op := part[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("invalid symbolic mode: %s", s)
+			}
+			i++
+			start := i
+			for ; i < len(part) && strings.IndexByte("rwxXst", part[i]) >= 0; i++ {
+			}
+			clause.ops = append(clause.ops, modeOp{op: op, perms: part[start:i]})
+		}
+		if len(clause.ops) == 0 {
+			return nil, fmt.Errorf("invalid symbolic mode: %s", s)
+		}
+		spec.clauses = append(spec.clauses, clause)
+	}
+	return spec, nil
+}
+
+// apply returns the new unix mode bits for an entry with current bits cur
+func (m *modeSpec) apply(cur uint32, isDir bool) uint32 {
+	if m.octal {
+		return m.value
+	}
+
+	for _, clause := range m.clauses {
+		for _, op := range clause.ops {
+			var bits uint32
+			for _, p := range op.perms {
+				switch p {
+				case 'r':
+					bits |= 0444
+				case 'w':
+					bits |= 0222
+				case 'x':
+					bits |= 0111
+				case 'X':
+					// Execute only for directories or files already executable by someone
+					if isDir || cur&0111 != 0 {
+						bits |= 0111
+					}
+				case 's':
+					bits |= 06000
+				case 't':
+					bits |= 01000
+				}
+			}
+			bits &= clause.who
+
+			switch op.op {
+			case '+':
+				cur |= bits
+			case '-':
+				cur &^= bits
+			case '=':
+				cur = cur&^clause.who | bits
+			}
+		}
+	}
+	return cur
+}
+
+// unixMode converts an os.FileMode to classic unix permission bits
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// fileMode converts unix permission bits to an os.FileMode for os.Chmod
+func fileMode(bits uint32) os.FileMode {
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// walkPerm calls fn for path and, if recursive, everything below it.
+// Symlinks are never followed or changed, so a walk cannot leave the root.
+// Entries that cannot be read are recorded in res as failures.
+func (h *FileHandler) walkPerm(root string, recursive bool, res *PermResult, fn func(path string, info os.FileInfo) error) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("cannot change a symlink")
+	}
+	if !recursive || !info.IsDir() {
+		return fn(root, info)
+	}
+
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			res.add(PermChange{Path: h.virtualPath(path), IsDir: d != nil && d.IsDir(), Error: err.Error()})
+			return nil
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info)
+	})
+}
+
+// writePermResult writes res as JSON
+func writePermResult(w http.ResponseWriter, res *PermResult) {
+	if res.Changes == nil {
+		res.Changes = []PermChange{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// HandleChmod handles POST /chmod requests to change file permissions.
+// mode applies to files and directories alike; fileMode and dirMode override
+// it per type. Modes are octal ("755") or symbolic ("u+x,go-w").
+// With recursive=1 the whole tree is changed, with dryRun=1 nothing is.
+func (h *FileHandler) HandleChmod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filePath := query.Get(common.QueryPath)
+	if filePath == "" {
+		http.Error(w, "Missing path parameter", http.StatusBadRequest)
+		return
+	}
+
+	mode, err := parseModeSpec(query.Get(common.QueryMode))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fileSpec, err := parseModeSpec(query.Get(common.QueryFileMode))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dirSpec, err := parseModeSpec(query.Get(common.QueryDirMode))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fileSpec == nil {
+		fileSpec = mode
+	}
+	if dirSpec == nil {
+		dirSpec = mode
+	}
+	if fileSpec == nil && dirSpec == nil {
+		http.Error(w, "Missing mode parameter", http.StatusBadRequest)
+		return
+	}
+
+	cleanPath, safe := h.isPathSafe(filePath)
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	// Check if file exists
+	if _, err := os.Lstat(cleanPath); err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
+
+	res := &PermResult{DryRun: query.Get(common.QueryDryRun) == "1"}
+	recursive := query.Get(common.QueryRecursive) == "1"
+
+	err = h.walkPerm(cleanPath, recursive, res, func(path string, info os.FileInfo) error {
+		spec := fileSpec
+		if info.IsDir() {
+			spec = dirSpec
+		}
+		if spec == nil {
+			return nil
+		}
+
+		oldBits := unixMode(info.Mode())
+		newBits := spec.apply(oldBits, info.IsDir())
+		if newBits == oldBits {
+			return nil
+		}
+
+		change := PermChange{
+			Path:    h.virtualPath(path),
+			IsDir:   info.IsDir(),
+			OldMode: fmt.Sprintf("%04o", oldBits),
+			NewMode: fmt.Sprintf("%04o", newBits),
+		}
+		if !res.DryRun {
+			// Change mode
+			if err := os.Chmod(path, fileMode(newBits)); err != nil {
+				change.Error = err.Error()
+			}
+		}
+		res.add(change)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to change permissions: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writePermResult(w, res)
+}
+
+// HandleChown handles POST /chown requests to change file ownership.
+// user and group accept names or numeric ids; either may be omitted.
+func (h *FileHandler) HandleChown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filePath := query.Get(common.QueryPath)
+	userName := query.Get(common.QueryUser)
+	groupName := query.Get(common.QueryGroup)
+
+	if filePath == "" || (userName == "" && groupName == "") {
+		http.Error(w, "Missing path, user or group parameter", http.StatusBadRequest)
+		return
+	}
+
+	cleanPath, safe := h.isPathSafe(filePath)
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	info, err := os.Lstat(cleanPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !statSys(cleanPath, info).hasOwner {
+		http.Error(w, "Changing ownership is not supported on this server", http.StatusNotImplemented)
+		return
+	}
+	if !checkPreconditions(w, r, cleanPath) {
+		return
+	}
+
+	// -1 leaves the id unchanged
+	uid, gid := -1, -1
+	if userName != "" {
+		if uid, err = lookupUID(userName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if groupName != "" {
+		if gid, err = lookupGID(groupName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := &PermResult{DryRun: query.Get(common.QueryDryRun) == "1"}
+	recursive := query.Get(common.QueryRecursive) == "1"
+
+	err = h.walkPerm(cleanPath, recursive, res, func(path string, info os.FileInfo) error {
+		sys := statSys(path, info)
+		newUID, newGID := int(sys.uid), int(sys.gid)
+		if uid >= 0 {
+			newUID = uid
+		}
+		if gid >= 0 {
+			newGID = gid
+		}
+		if newUID == int(sys.uid) && newGID == int(sys.gid) {
+			return nil
+		}
+
+		change := PermChange{
+			Path:     h.virtualPath(path),
+			IsDir:    info.IsDir(),
+			OldOwner: h.lookupUser(sys.uid) + ":" + h.lookupGroup(sys.gid),
+			NewOwner: h.lookupUser(uint32(newUID)) + ":" + h.lookupGroup(uint32(newGID)),
+		}
+		if !res.DryRun {
+			if err := os.Lchown(path, uid, gid); err != nil {
+				change.Error = err.Error()
+			}
+		}
+		res.add(change)
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "Failed to change owner: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writePermResult(w, res)
+}
+
+// lookupUID resolves a user name or numeric id
+func lookupUID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown user: %s", name)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGID resolves a group name or numeric id
+func lookupGID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown group: %s", name)
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	// maxRangeHashes bounds the hashes returned by one range-hash request
+	maxRangeHashes = 65536
+	// minRangeHashChunk is the smallest chunk size a hash list may use
+	minRangeHashChunk = 64 * 1024
+)
+
+// HandleRangeHash handles GET /?action=range-hash&path=X&ranges=S-E,... and
+// GET /?action=range-hash&path=X&chunkSize=N. It returns the SHA256 of each
+// byte range, or of every chunkSize block of the file, as common.RangeHashes
+// along with the file's ETag, so clients can verify parts of a download and
+// fetch again only those that differ. The ranges together may not be larger
+// than the file.
+func (h *FileHandler) HandleRangeHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, info, cleanPath := h.openRegular(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	query := r.URL.Query()
+	var ranges []common.Extent
+	switch {
+	case query.Get(common.QueryRanges) != "":
+		var err error
+		if ranges, err = common.ParseRanges(query.Get(common.QueryRanges), info.Size()); err != nil {
+			http.Error(w, "Invalid ranges parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var total int64
+		for _, rg := range ranges {
+			total += rg.End - rg.Start
+		}
+		if total > info.Size() {
+			http.Error(w, "Ranges cover more than the file", http.StatusBadRequest)
+			return
+		}
+	case query.Get(common.QueryChunkSize) != "":
+		chunkSize, err := strconv.ParseInt(query.Get(common.QueryChunkSize), 10, 64)
+		if err != nil || chunkSize < minRangeHashChunk {
+			http.Error(w, "Invalid chunkSize parameter", http.StatusBadRequest)
+			return
+		}
+		for start := int64(0); start < info.Size(); start += chunkSize {
+			ranges = append(ranges, common.Extent{Start: start, End: min(start+chunkSize, info.Size())})
+		}
+	default:
+		http.Error(w, "Missing ranges or chunkSize parameter", http.StatusBadRequest)
+		return
+	}
+	if len(ranges) > maxRangeHashes {
+		http.Error(w, "Too many ranges", http.StatusBadRequest)
+		return
+	}
+
+	result := common.RangeHashes{
+		Path:   h.virtualPath(cleanPath),
+		Size:   info.Size(),
+		ETag:   fileETag(info),
+		Hashes: make([]common.RangeHash, 0, len(ranges)),
+	}
+	for _, rg := range ranges {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, io.NewSectionReader(file, rg.Start, rg.End-rg.Start)); err != nil {
+			http.Error(w, "Failed to hash range: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result.Hashes = append(result.Hashes, common.RangeHash{Extent: rg, SHA256: hex.EncodeToString(hash.Sum(nil))})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.ETag != "" {
+		w.Header().Set("ETag", result.ETag)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// sourceMeta is the modification time and permission bits of the client's
+// local file, sent with an upload so the server copy keeps them
+type sourceMeta struct {
+	ModTime int64   `json:"modTime,omitempty"` // Unix seconds, 0 if not sent
+	Mode    *uint32 `json:"mode,omitempty"`    // Permission bits, nil if not sent
+}
+
+// sourceMetaFromRequest reads the X-Source-Mtime and X-Source-Mode headers;
+// missing or malformed values are left unset
+func sourceMetaFromRequest(r *http.Request) sourceMeta {
+	var meta sourceMeta
+	if mtime, err := strconv.ParseInt(r.Header.Get(common.HeaderSourceMtime), 10, 64); err == nil && mtime > 0 {
+		meta.ModTime = mtime
+	}
+	if mode, err := strconv.ParseUint(r.Header.Get(common.HeaderSourceMode), 8, 32); err == nil {
+		perm := uint32(os.FileMode(mode) & os.ModePerm)
+		meta.Mode = &perm
+	}
+	return meta
+}
+
+// modTime returns the source modification time, zero if not sent
+func (m sourceMeta) modTime() time.Time {
+	if m.ModTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(m.ModTime, 0)
+}
+
+// apply sets the sent mode and modification time on path
+func (m sourceMeta) apply(path string) error {
+	if m.Mode != nil {
+		if err := os.Chmod(path, os.FileMode(*m.Mode)); err != nil {
+			return err
+		}
+	}
+	if m.ModTime != 0 {
+		return os.Chtimes(path, time.Time{}, m.modTime())
+	}
+	return nil
+}
+
+// SetFileMetaHeaders describes a file being downloaded: its permission bits
+// in X-File-Mode, its modification time in Last-Modified and its ETag
+func SetFileMetaHeaders(w http.ResponseWriter, info os.FileInfo) {
+	w.Header().Set("ETag", fileETag(info))
+	w.Header().Set(common.HeaderFileMode, strconv.FormatUint(uint64(info.Mode().Perm()), 8))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+// HandleSparseMap handles GET /?action=sparse-map&path=X.
+// It returns the data extents of a file as a common.SparseMap, so clients
+// can skip its holes when downloading. Holes are only detected on Linux;
+// elsewhere the whole file is reported as data.
+func (h *FileHandler) HandleSparseMap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, info, cleanPath := h.openRegular(w, r)
+	if file == nil {
+		return
+	}
+	defer file.Close()
+
+	m, err := common.ReadSparseMap(file, info.Size())
+	if err != nil {
+		http.Error(w, "Failed to read sparse map: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.Path = h.virtualPath(cleanPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
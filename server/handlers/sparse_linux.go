@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// fallocate flags from <linux/falloc.h>
+const (
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+// punchZeros turns the all-zero 4KB blocks within [start, end) of a file
+// into holes. Filesystems that cannot punch holes are left as they are.
+func punchZeros(path string, start, end int64) error {
+	const block = 4096
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	punch := func(from, to int64) error {
+		if from >= to {
+			return nil
+		}
+		err := syscall.Fallocate(int(file.Fd()), fallocKeepSize|fallocPunchHole, from, to-from)
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			return nil
+		}
+		return err
+	}
+
+	// Only whole blocks can become holes
+	start = (start + block - 1) / block * block
+	end = end / block * block
+
+	buf := make([]byte, 256*block)
+	zero := make([]byte, block)
+	run := start // Start of the current run of zero blocks
+	for off := start; off < end; {
+		n, err := file.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+		if n == 0 {
+			if err == nil || err == io.EOF {
+				break
+			}
+			return err
+		}
+		for i := 0; i+block <= n; i += block {
+			if !bytes.Equal(buf[i:i+block], zero) {
+				if err := punch(run, off+int64(i)); err != nil {
+					return err
+				}
+				run = off + int64(i+block)
+			}
+		}
+		off += int64(n / block * block)
+		if n < block {
+			break
+		}
+	}
+	return punch(run, end)
+}
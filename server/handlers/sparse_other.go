@@ -0,0 +1,8 @@
+//go:build !linux
+
+package handlers
+
+// punchZeros is a no-op: punching holes is only implemented on Linux
+func punchZeros(path string, start, end int64) error {
+	return nil
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+)
+
+const (
+	// defaultTailLines is how many lines tail returns without a lines parameter
+	defaultTailLines = 100
+	// maxTailLines bounds the lines parameter
+	maxTailLines = 100000
+	// maxTailBytes bounds how far back from the end tail looks for lines
+	maxTailBytes = 16 * 1024 * 1024
+	// tailPollInterval is how often a followed file is checked for new data
+	tailPollInterval = 500 * time.Millisecond
+)
+
+// HandleTail handles GET /?action=tail&path=X[&lines=N][&follow=1]. It
+// returns the last N lines of a file (100 by default, 0 for none, at most
+// the last 16MB). With follow=1 the response stays open and data appended
+// to the file is sent as it arrives, like tail -F: when the file is replaced
+// (log rotation) the rest of the old file is sent and the new one followed
+// from its start, and when it is truncated it is followed from its start.
+// The stream ends when the client closes it.
+func (h *FileHandler) HandleTail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	lines := defaultTailLines
+	if s := query.Get(common.QueryLines); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxTailLines {
+			http.Error(w, "Invalid lines parameter", http.StatusBadRequest)
+			return
+		}
+		lines = n
+	}
+	follow := query.Get(common.QueryFollow) == "1"
+
+	file, info, cleanPath := h.openRegular(w, r)
+	if file == nil {
+		return
+	}
+	defer func() { file.Close() }()
+
+	start, err := tailStart(file, info.Size(), lines)
+	if err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		http.Error(w, "Failed to read file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	offset := start
+	n, err := io.Copy(w, file)
+	offset += n
+	if err != nil || !follow {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := os.Stat(cleanPath)
+		switch {
+		case err != nil:
+			// Between moving the old file away and creating the new one
+		case !os.SameFile(info, current):
+			// Rotated: finish the old file, then follow the new one
+			rotated, err := os.Open(cleanPath)
+			if err != nil {
+				break
+			}
+			rotatedInfo, err := rotated.Stat()
+			if err != nil {
+				rotated.Close()
+				break
+			}
+			if _, err := io.Copy(w, file); err != nil {
+				rotated.Close()
+				return
+			}
+			file.Close()
+			file, info, offset = rotated, rotatedInfo, 0
+		case current.Size() < offset:
+			// Truncated (e.g. copytruncate): whatever it holds now is new
+			if offset, err = file.Seek(0, io.SeekStart); err != nil {
+				return
+			}
+		}
+
+		n, err := io.Copy(w, file)
+		offset += n
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			flush()
+		}
+	}
+}
+
+// tailStart returns the offset at which the last lines lines of a file of
+// size bytes begin, looking back at most maxTailBytes. A newline ending the
+// file does not start another line.
+func tailStart(file *os.File, size int64, lines int) (int64, error) {
+	if lines <= 0 {
+		return size, nil
+	}
+	limit := max(0, size-maxTailBytes)
+	buf := make([]byte, 64*1024)
+	pos := size
+	for pos > limit {
+		n := min(int64(len(buf)), pos-limit)
+		pos -= n
+		if _, err := file.ReadAt(buf[:n], pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := n - 1; i >= 0; i-- {
+			if buf[i] == '\n' && pos+i != size-1 {
+				lines--
+				if lines == 0 {
+					return pos + i + 1, nil
+				}
+			}
+		}
+	}
+	return limit, nil
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,12 +15,15 @@ import (
 	"time"
 
 	"github.com/CertStone/simpleKcpFileManager/common"
+	"github.com/CertStone/simpleKcpFileManager/server/compress"
 )
 
 // UploadHandler handles file uploads
 type UploadHandler struct {
 	fileHandler *FileHandler
-	fileLocks   sync.Map // map[string]*sync.Mutex - per-file locks
+	sessions    sync.Map // map[string]*uploadSession - active upload sessions by id
+	partials    sync.Map // map[string]*partialUpload - Content-Range uploads by target path
+	sessionDir  string   // Where upload sessions are persisted, "" for memory only
 }
 
 // NewUploadHandler creates a new upload handler
@@ -27,18 +33,15 @@ func NewUploadHandler(rootDir string) *UploadHandler {
 	}
 }
 
-// getLock returns a mutex lock for the specific file path
-func (h *UploadHandler) getLock(path string) *sync.Mutex {
-	lock, _ := h.fileLocks.LoadOrStore(path, &sync.Mutex{})
-	return lock.(*sync.Mutex)
-}
-
 // HandleUpload handles file upload with resume support
 func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !decodeRequestBody(w, r) {
+		return
+	}
 
 	filePath := r.URL.Query().Get("path")
 	if filePath == "" {
@@ -46,14 +49,21 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for auto-extract request
-	autoExtract := r.Header.Get("X-Auto-Extract") == "1"
+	policy, err := conflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	cleanPath, safe := h.fileHandler.isPathSafe(filePath)
 	if !safe {
 		http.Error(w, "Invalid path", http.StatusBadRequest)
 		return
 	}
+	if isReservedName(cleanPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
 
 	// Create directory if not exists
 	dir := filepath.Dir(cleanPath)
@@ -62,120 +72,547 @@ func (h *UploadHandler) HandleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for resume support via Content-Range header
+	// Check for chunked upload via Content-Range header
 	contentRange := r.Header.Get("Content-Range")
-	var startOffset int64 = 0
+	var startOffset, totalSize, chunkLength int64
 
 	if contentRange != "" {
 		// Parse Content-Range: bytes start-end/total
 		// Example: bytes 0-1023/2048
 		var start, end, total int64
 		_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
-		if err == nil {
-			startOffset = start
+		if err != nil || start < 0 || end < start || end >= total {
+			http.Error(w, "Invalid Content-Range", http.StatusBadRequest)
+			return
 		}
+		chunkLength = end - start + 1
+		if r.ContentLength >= 0 && r.ContentLength != chunkLength {
+			http.Error(w, "Content-Range does not match the body length", http.StatusBadRequest)
+			return
+		}
+		startOffset = start
+		totalSize = total
 	}
 
-	// NOTE: Per-file locking removed to support parallel chunk uploads
-	// Each chunk writes to a different offset in the same file
-	// File system and OS handle concurrent writes to different file positions
+	// Auto-extract is decided by the first chunk; later chunks of a parallel
+	// upload follow it whatever headers they carry
+	var extract *extractTarget
+	if startOffset == 0 {
+		if extract, err = h.extractTarget(r, cleanPath, policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Open file for writing
-	// For chunked upload (with startOffset), use O_RDWR to avoid truncating
-	// For new upload (no startOffset), use O_WRONLY|O_TRUNC to create fresh file
-	var file *os.File
-	var err error
-	if startOffset > 0 {
-		// Chunked upload: open in read-write mode without truncating
-		file, err = os.OpenFile(cleanPath, os.O_CREATE|os.O_RDWR, 0644)
-	} else {
-		// New upload: create or truncate
-		file, err = os.OpenFile(cleanPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	// Resolve conflicts on the first chunk only; later chunks of a parallel
+	// upload are sent to the final path returned for chunk 0. With auto-extract
+	// the policy applies to the extracted entries instead of the archive.
+	meta := sourceMetaFromRequest(r)
+	pre := preconditionsFromRequest(r)
+	result := ""
+	var reader io.Reader = r.Body
+	if startOffset == 0 && extract == nil {
+		// Upload rules are checked against the declared size before anything
+		// is written; a body of unknown size is cut off at the limit
+		declared := r.ContentLength
+		if totalSize > 0 {
+			declared = totalSize
+		}
+		if err := h.fileHandler.checkWrite(cleanPath, declared); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		if declared < 0 {
+			reader = h.fileHandler.limitBody(cleanPath, reader)
+		}
+		if reader, err = h.fileHandler.checkBody(cleanPath, reader); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		if !pre.check(w, cleanPath) {
+			return
+		}
+		var target string
+		target, result, err = common.ResolveConflict(cleanPath, false, meta.modTime(), policy)
+		if err != nil {
+			if !h.fileHandler.writeConflictError(w, err, policy, nil) {
+				http.Error(w, "Failed to check destination: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if target == "" {
+			h.fileHandler.setConflictHeaders(w, result, "")
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, "OK\nSkipped: destination exists")
+			return
+		}
+		if result == common.ConflictResultRenamed {
+			pre = preconditions{} // A fresh name has nothing to protect
+		}
+		cleanPath = target
 	}
-	if err != nil {
-		http.Error(w, "Failed to open file: "+err.Error(), http.StatusInternalServerError)
+
+	// A whole tar archive sent in one request is extracted as it arrives.
+	// Zip archives need random access, and under ConflictFail the archive is
+	// checked for conflicts before anything is written, so those are stored
+	// first. The same goes for upload rules, which are checked on every entry.
+	if extract != nil && contentRange == "" && policy != common.ConflictFail && h.fileHandler.rules == nil && compress.IsTarArchive(cleanPath) {
+		h.extractUploadStream(w, r, extract)
 		return
 	}
-	defer file.Close()
 
-	// Seek to start offset if resuming
-	if startOffset > 0 {
-		_, err = file.Seek(startOffset, io.SeekStart)
-		if err != nil {
-			http.Error(w, "Failed to seek file: "+err.Error(), http.StatusInternalServerError)
+	// Data is written to a hidden part file of its own next to the target
+	// and only renamed over it once complete, so readers never see a
+	// half-written file, a failed upload leaves the previous version
+	// untouched and concurrent uploads to the same target do not mix
+	var part string
+
+	// Chunks of a parallel upload are tracked until every byte has arrived.
+	// The chunk at offset 0 starts the upload, so clients send it first.
+	var partial *partialUpload
+	if totalSize > 0 {
+		if startOffset == 0 {
+			partial = &partialUpload{total: totalSize, ranges: make(map[int64]int64), meta: meta, pre: pre, extract: extract}
+			if err := h.startPartial(cleanPath, partial); err != nil {
+				http.Error(w, "Failed to create file: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			part = partial.part
+		} else if p, ok := h.partials.Load(cleanPath); ok {
+			partial = p.(*partialUpload)
+			part = partial.part
+			if partial.total != totalSize {
+				http.Error(w, "Content-Range total does not match the upload", http.StatusBadRequest)
+				return
+			}
+			if err := partial.check(startOffset, chunkLength); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			meta, pre, extract = partial.meta, partial.pre, partial.extract
+			// Every chunk is held to the rules for its target and the upload's
+			// total; the content was checked with the chunk at offset 0
+			if extract == nil {
+				if err := h.fileHandler.checkWrite(cleanPath, partial.total); err != nil {
+					writeRuleError(w, err)
+					return
+				}
+			}
+		} else {
+			http.Error(w, "Upload not started: send the chunk at offset 0 first", http.StatusBadRequest)
+			return
+		}
+		// A restart of the upload waits for the chunk to be written
+		if !partial.begin() {
+			http.Error(w, "Upload was restarted or has finished", http.StatusBadRequest)
 			return
 		}
+		defer partial.writing.RUnlock()
+	} else if part, err = newPartPath(cleanPath); err == nil {
+		err = createPart(part, 0)
+	}
+
+	// A chunk never writes outside its range, whatever its body holds
+	if contentRange != "" {
+		reader = io.LimitReader(reader, chunkLength)
 	}
 
-	// Copy data with explicit flush to ensure data is written promptly
-	// This helps with parallel uploads by releasing the file handle sooner
-	written, err := io.Copy(file, r.Body)
+	var written int64
+	body := newDigestBody(r, reader)
+	if err == nil {
+		written, err = writePart(part, startOffset, body)
+	}
 	if err != nil {
+		// A broken rule ends the upload; other failures only lose this
+		// chunk, which the client can send again
+		var rule *ruleError
+		if partial == nil || errors.As(err, &rule) {
+			h.abandonPart(cleanPath, part, partial)
+		}
+		if writeRuleError(w, err) {
+			return
+		}
 		http.Error(w, "Failed to write file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-
-	// Sync to disk to ensure data is persisted
-	if err := file.Sync(); err != nil {
-		http.Error(w, "Failed to sync file: "+err.Error(), http.StatusInternalServerError)
+	if contentRange != "" && (written != chunkLength || bodyContinues(r.Body)) {
+		// Not recorded; the chunk can be sent again
+		if partial == nil {
+			h.abandonPart(cleanPath, part, nil)
+		}
+		http.Error(w, "Content-Range does not match the body length", http.StatusBadRequest)
 		return
 	}
-
-	// Get final file size
-	info, err := file.Stat()
-	if err != nil {
-		http.Error(w, "Failed to stat file: "+err.Error(), http.StatusInternalServerError)
+	if err := body.verify(); err != nil {
+		// A corrupted chunk is simply not recorded so it can be sent again
+		if partial == nil {
+			h.abandonPart(cleanPath, part, nil)
+		}
+		writeDigestError(w, "Upload")
 		return
 	}
 
-	// Auto-extract if requested and file is tar.gz
-	// Only do this on the last chunk (when total size matches expected size)
-	if autoExtract && strings.HasSuffix(cleanPath, ".tar.gz") {
-		fmt.Printf("[DEBUG] Auto-extract requested for: %s\n", cleanPath)
-
-		// IMPORTANT: Close the file handle BEFORE extracting and deleting
-		// This is necessary on Windows where open files cannot be deleted
-		file.Close()
-
-		// The tar.gz contains files with their original name as root
-		// e.g., uploading "file.msi" creates tar with "file.msi" inside
-		// So we extract to the parent directory of the .tar.gz file
-		extractPath := filepath.Dir(cleanPath)
-		fmt.Printf("[DEBUG] Extract path: %s\n", extractPath)
-
-		// Extract archive
-		if err := common.DecompressFromTarGz(cleanPath, extractPath); err != nil {
-			fmt.Printf("[ERROR] Failed to extract: %v\n", err)
-			http.Error(w, "Failed to extract archive: "+err.Error(), http.StatusInternalServerError)
+	complete, received := true, written
+	if partial != nil {
+		if complete, received, err = partial.add(startOffset, written); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		fmt.Printf("[DEBUG] Extract successful\n")
-
-		// Remove temporary tar.gz file asynchronously with retry
-		// This handles cases where the file might still be briefly locked
-		go func(archivePath string) {
-			for i := 0; i < 5; i++ {
-				if err := os.Remove(archivePath); err != nil {
-					if os.IsNotExist(err) {
-						return // Already deleted
-					}
-					fmt.Printf("[DEBUG] Retry %d: failed to remove temp archive: %v\n", i+1, err)
-					// Wait a bit before retrying (100ms, 200ms, 400ms, 800ms, 1600ms)
-					sleepDuration := (1 << i) * 100 // exponential backoff
-					<-time.After(time.Duration(sleepDuration) * time.Millisecond)
-				} else {
-					fmt.Printf("[DEBUG] Temp archive removed: %s\n", archivePath)
+		if complete {
+			h.partials.CompareAndDelete(cleanPath, partial)
+		}
+	}
+
+	if complete {
+		// Auto-extract the finished archive straight from the part file
+		if extract != nil {
+			fmt.Printf("[DEBUG] Auto-extract %s to %s\n", cleanPath, extract.Dest)
+
+			stats, err := compress.ExtractArchive(part, extract.Dest, extract.Policy, h.fileHandler.entryCheck())
+			h.fileHandler.forgetChecksums(extract.Dest)
+			removeTempArchive(part)
+			if err != nil {
+				fmt.Printf("[ERROR] Failed to extract: %v\n", err)
+				if h.fileHandler.writeConflictError(w, err, extract.Policy, nil) || writeRuleError(w, err) {
 					return
 				}
+				http.Error(w, "Failed to extract archive: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			fmt.Printf("[DEBUG] Extract successful: %d files, %d directories\n", stats.Files, stats.Dirs)
+			setExtractHeaders(w, stats)
+		} else if err := h.commitPartIf(part, cleanPath, meta, pre); err != nil {
+			os.Remove(part)
+			if errors.Is(err, errPreconditionFailed) {
+				writePreconditionFailed(w, currentETag(cleanPath))
+				return
 			}
-			fmt.Printf("Warning: failed to remove temporary archive after retries: %s\n", archivePath)
-		}(cleanPath)
+			http.Error(w, "Failed to commit file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Return success with file size
+	// Return success with the bytes received so far
+	if result != "" {
+		h.fileHandler.setConflictHeaders(w, result, cleanPath)
+	}
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("X-Uploaded-Bytes", strconv.FormatInt(written, 10))
-	w.Header().Set("X-File-Size", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("X-File-Size", strconv.FormatInt(received, 10))
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK\nUploaded: %d bytes\nTotal: %d bytes", written, info.Size())
+	fmt.Fprintf(w, "OK\nUploaded: %d bytes\nTotal: %d bytes", written, received)
+}
+
+// extractTarget describes where an uploaded archive is extracted
+type extractTarget struct {
+	Dest   string                `json:"dest"`   // Filesystem directory to extract into
+	Policy common.ConflictPolicy `json:"policy"` // Applied to the extracted entries
+}
+
+// extractTarget returns the extraction requested with X-Auto-Extract for an
+// upload to archivePath, or nil if the archive is to be stored. Without
+// X-Extract-Dest the archive's own directory is used, since packed uploads
+// contain the source name as their root.
+func (h *UploadHandler) extractTarget(r *http.Request, archivePath string, policy common.ConflictPolicy) (*extractTarget, error) {
+	if r.Header.Get(common.HeaderAutoExtract) != "1" {
+		return nil, nil
+	}
+	if !compress.IsArchive(archivePath) {
+		return nil, fmt.Errorf("unsupported archive format: %s", filepath.Base(archivePath))
+	}
+
+	dest := filepath.Dir(archivePath)
+	if d := r.Header.Get(common.HeaderExtractDest); d != "" {
+		var safe bool
+		if dest, safe = h.fileHandler.isPathSafe(d); !safe {
+			return nil, fmt.Errorf("invalid extract destination")
+		}
+	}
+	return &extractTarget{Dest: dest, Policy: policy}, nil
+}
+
+// extractUploadStream extracts an uploaded tar archive directly from the
+// request body, without storing the archive
+func (h *UploadHandler) extractUploadStream(w http.ResponseWriter, r *http.Request, extract *extractTarget) {
+	fmt.Printf("[DEBUG] Streaming extract of %s to %s\n", r.URL.Query().Get("path"), extract.Dest)
+
+	body := &countingReader{reader: r.Body}
+	stats, err := compress.ExtractTarStream(body, extract.Dest, extract.Policy)
+	h.fileHandler.forgetChecksums(extract.Dest)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to extract: %v\n", err)
+		if h.fileHandler.writeConflictError(w, err, extract.Policy, nil) {
+			return
+		}
+		http.Error(w, "Failed to extract archive: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[DEBUG] Extract successful: %d files, %d directories\n", stats.Files, stats.Dirs)
+
+	setExtractHeaders(w, stats)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("X-Uploaded-Bytes", strconv.FormatInt(body.n, 10))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "OK\nExtracted: %d files, %d directories from %d bytes", stats.Files, stats.Dirs, body.n)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// partialUpload tracks the chunks of a Content-Range upload
+type partialUpload struct {
+	mu      sync.Mutex
+	total   int64
+	ranges  map[int64]int64 // chunk start -> length; a resent chunk replaces itself
+	meta    sourceMeta      // Sent with the chunk at offset 0
+	pre     preconditions   // Sent with the chunk at offset 0, checked again at commit
+	extract *extractTarget  // Set if the chunk at offset 0 asked for auto-extract
+	part    string          // Hidden file the chunks are written to
+	done    bool
+	ended   bool         // Replaced by a new upload or abandoned; mu
+	writing sync.RWMutex // Shared by chunk writes, exclusive for a restart
+}
+
+// begin registers a chunk write, which a restart of the upload waits for,
+// and reports false if the upload has finished or ended. A true result must
+// be followed by p.writing.RUnlock.
+func (p *partialUpload) begin() bool {
+	p.writing.RLock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done || p.ended {
+		p.writing.RUnlock()
+		return false
+	}
+	return true
+}
+
+// end stops the upload once the chunks being written are done
+func (p *partialUpload) end() {
+	p.writing.Lock()
+	defer p.writing.Unlock()
+	p.mu.Lock()
+	p.ended = true
+	p.mu.Unlock()
+}
+
+// add records a written chunk and reports whether the upload just became
+// complete, along with the number of bytes received so far. Chunks must lie
+// within the upload and may only overlap a chunk sent at the same offset,
+// so the received bytes add up to the total only once all have arrived.
+func (p *partialUpload) add(start, length int64) (bool, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ended {
+		return false, 0, fmt.Errorf("upload was restarted")
+	}
+	if err := p.checkLocked(start, length); err != nil {
+		return false, 0, err
+	}
+	var received int64
+	for s, n := range p.ranges {
+		if s != start {
+			received += n
+		}
+	}
+	p.ranges[start] = length
+	received += length
+	if p.done || received < p.total {
+		return false, received, nil
+	}
+	p.done = true
+	return true, received, nil
+}
+
+// check reports whether a chunk may be written: it must lie within the
+// upload and may only overlap a chunk sent at the same offset
+func (p *partialUpload) check(start, length int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkLocked(start, length)
+}
+
+func (p *partialUpload) checkLocked(start, length int64) error {
+	if start < 0 || start+length > p.total {
+		return fmt.Errorf("chunk exceeds the upload size")
+	}
+	for s, n := range p.ranges {
+		if s != start && s < start+length && start < s+n {
+			return fmt.Errorf("chunk overlaps another chunk")
+		}
+	}
+	return nil
+}
+
+// bodyContinues reports whether body holds more data than was read from it
+func bodyContinues(body io.Reader) bool {
+	n, _ := io.ReadFull(body, make([]byte, 1))
+	return n > 0
+}
+
+// startPartial starts the Content-Range upload p to target in a new part
+// file. An unfinished upload to the same target is replaced once its chunks
+// in flight are written; the part file exists before any chunk can find p.
+func (h *UploadHandler) startPartial(target string, p *partialUpload) error {
+	part, err := newPartPath(target)
+	if err != nil {
+		return err
+	}
+	if err := createPart(part, p.total); err != nil {
+		return err
+	}
+	p.part = part
+
+	if old, ok := h.partials.Swap(target, p); ok {
+		old := old.(*partialUpload)
+		old.end()
+		old.mu.Lock()
+		done := old.done
+		old.mu.Unlock()
+		if !done {
+			os.Remove(old.part)
+		}
+	}
+	return nil
+}
+
+// abandonPart drops a failed upload to target; chunks of partial still in
+// flight are not recorded
+func (h *UploadHandler) abandonPart(target, part string, partial *partialUpload) {
+	if partial != nil {
+		partial.mu.Lock()
+		partial.ended = true
+		partial.mu.Unlock()
+		h.partials.CompareAndDelete(target, partial)
+	}
+	os.Remove(part)
+}
+
+// partPath returns the hidden file the upload id to target is written to
+func partPath(target, id string) string {
+	return filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+"."+id+partSuffix)
+}
+
+// newPartPath returns a part file for a new upload to target that no other
+// upload uses
+func newPartPath(target string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return partPath(target, hex.EncodeToString(buf)), nil
+}
+
+// partSuffix ends the names of the hidden files uploads are written to
+const partSuffix = ".part"
+
+// isReservedName reports whether the base name of p is that of a hidden
+// part file (".name.id.part") or session state file (".name.upload"). Clients
+// may not create such entries, so they cannot plant or replace the files
+// uploads are written to.
+func isReservedName(p string) bool {
+	name := filepath.Base(p)
+	return strings.HasPrefix(name, ".") && (strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, sessionStateSuffix))
+}
+
+// createPart creates or truncates a part file and preallocates size bytes
+func createPart(part string, size int64) error {
+	file, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		if err := file.Truncate(size); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// writePart writes r into an existing part file at offset and syncs it
+func writePart(part string, offset int64, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(part, os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	written, err := io.Copy(io.NewOffsetWriter(file, offset), r)
+	if err != nil {
+		return written, err
+	}
+	return written, file.Sync()
+}
+
+// commitPart renames a finished part file over target. The source mode and
+// modification time are applied if the client sent them; without a mode the
+// permissions of the file being replaced are kept.
+func commitPart(part, target string, meta sourceMeta) error {
+	if info, err := os.Stat(target); err == nil && info.Mode().IsRegular() && meta.Mode == nil {
+		os.Chmod(part, info.Mode().Perm())
+	}
+	if err := meta.apply(part); err != nil {
+		return err
+	}
+	return os.Rename(part, target)
+}
+
+// errPreconditionFailed is returned when the target changed after an
+// upload's If-Match/If-None-Match headers were first checked
+var errPreconditionFailed = errors.New("precondition failed")
+
+// commitPartIf commits like commitPart once pre still holds for target, so a
+// file replaced while the upload was running is not overwritten. The target's
+// lock keeps the check and the rename together, and out of the way of an
+// edit save to the same file.
+func (h *UploadHandler) commitPartIf(part, target string, meta sourceMeta, pre preconditions) error {
+	lock := fileLock(target)
+	lock.Lock()
+	defer lock.Unlock()
+	return h.commitPartLocked(part, target, meta, pre)
+}
+
+// commitPartLocked is commitPartIf for callers already holding the
+// target's lock
+func (h *UploadHandler) commitPartLocked(part, target string, meta sourceMeta, pre preconditions) error {
+	defer h.fileHandler.forgetChecksums(target)
+	if !pre.hold(currentETag(target)) {
+		return errPreconditionFailed
+	}
+	return commitPart(part, target, meta)
+}
+
+// removeTempArchive removes an extracted archive asynchronously with retry.
+// This handles cases where the file might still be briefly locked (Windows).
+func removeTempArchive(archivePath string) {
+	go func() {
+		for i := 0; i < 5; i++ {
+			if err := os.Remove(archivePath); err != nil {
+				if os.IsNotExist(err) {
+					return // Already deleted
+				}
+				fmt.Printf("[DEBUG] Retry %d: failed to remove temp archive: %v\n", i+1, err)
+				// Wait a bit before retrying (100ms, 200ms, 400ms, 800ms, 1600ms)
+				sleepDuration := (1 << i) * 100 // exponential backoff
+				<-time.After(time.Duration(sleepDuration) * time.Millisecond)
+			} else {
+				fmt.Printf("[DEBUG] Temp archive removed: %s\n", archivePath)
+				return
+			}
+		}
+		fmt.Printf("Warning: failed to remove temporary archive after retries: %s\n", archivePath)
+	}()
 }
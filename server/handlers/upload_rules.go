@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/CertStone/simpleKcpFileManager/server/compress"
+)
+
+// UploadRule restricts the files written below a path prefix
+type UploadRule struct {
+	Path      string   `json:"path"`                // Server path prefix, "/" for the whole root
+	MaxSize   int64    `json:"maxSize,omitempty"`   // Largest file in bytes, 0 for no limit
+	Allow     []string `json:"allow,omitempty"`     // Name patterns ("*.md", ".pdf"); if set, other names are rejected
+	Deny      []string `json:"deny,omitempty"`      // Name patterns rejected even if allowed
+	AllowMIME []string `json:"allowMime,omitempty"` // Detected types ("text/*", "image/png"); if set, other types are rejected
+	DenyMIME  []string `json:"denyMime,omitempty"`  // Detected types rejected even if allowed
+}
+
+// UploadRules are the rules of a server, loaded from a JSON file such as
+//
+//	{"rules": [{"path": "/docs", "maxSize": 104857600, "deny": ["*.exe"], "denyMime": ["application/x-executable"]}]}
+//
+// The rule with the longest matching path prefix applies; paths no rule
+// covers are unrestricted. Patterns starting with "." match the extension,
+// patterns containing "/" match the whole server path, others the file name,
+// all ignoring case.
+type UploadRules struct {
+	Rules []UploadRule `json:"rules"`
+}
+
+// LoadUploadRules reads and validates upload rules from a JSON file
+func LoadUploadRules(file string) (*UploadRules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules UploadRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", file, err)
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		rule.Path = path.Clean("/" + rule.Path)
+		for _, pattern := range append(rule.Allow, rule.Deny...) {
+			if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+				return nil, fmt.Errorf("rule %s: bad pattern %q", rule.Path, pattern)
+			}
+		}
+	}
+	return &rules, nil
+}
+
+// SetUploadRules applies rules to copies and moves; nil removes them
+func (h *FileHandler) SetUploadRules(rules *UploadRules) {
+	h.rules = rules
+}
+
+// SetUploadRules applies rules to uploads; nil removes them
+func (h *UploadHandler) SetUploadRules(rules *UploadRules) {
+	h.fileHandler.rules = rules
+}
+
+// SetUploadRules applies rules to extracted files; nil removes them
+func (h *CompressHandler) SetUploadRules(rules *UploadRules) {
+	h.fileHandler.rules = rules
+}
+
+// SetUploadRules applies rules to saved files; nil removes them
+func (h *EditHandler) SetUploadRules(rules *UploadRules) {
+	h.fileHandler.rules = rules
+}
+
+// ruleError is returned when a write breaks an upload rule
+type ruleError struct {
+	Path   string // Server path of the rejected file
+	Rule   string // Path prefix of the rule
+	Reason string
+	Status int // 413 for size, 415 for name and type
+}
+
+func (e *ruleError) Error() string {
+	return fmt.Sprintf("%s rejected by rule %s: %s", e.Path, e.Rule, e.Reason)
+}
+
+// RuleInfo is the body of a 413 or 415 response for a broken upload rule
+type RuleInfo struct {
+	Error  string `json:"error"` // Always "rule"
+	Reason string `json:"reason"`
+	Path   string `json:"path"`
+	Rule   string `json:"rule"`
+}
+
+// ruleFor returns the rule for server path p, nil if none applies
+func (u *UploadRules) ruleFor(p string) *UploadRule {
+	if u == nil {
+		return nil
+	}
+	var best *UploadRule
+	for i := range u.Rules {
+		rule := &u.Rules[i]
+		if rule.Path != "/" && p != rule.Path && !strings.HasPrefix(p, rule.Path+"/") {
+			continue
+		}
+		if best == nil || len(rule.Path) > len(best.Path) {
+			best = rule
+		}
+	}
+	return best
+}
+
+// checkFile checks the name and size (-1 if not known yet) of a file
+// written to server path p
+func (u *UploadRules) checkFile(p string, size int64) error {
+	rule := u.ruleFor(p)
+	if rule == nil {
+		return nil
+	}
+	if rule.MaxSize > 0 && size > rule.MaxSize {
+		return &ruleError{Path: p, Rule: rule.Path, Status: http.StatusRequestEntityTooLarge,
+			Reason: fmt.Sprintf("file of %d bytes exceeds the limit of %d bytes", size, rule.MaxSize)}
+	}
+	if len(rule.Allow) > 0 && !matchName(rule.Allow, p) {
+		return &ruleError{Path: p, Rule: rule.Path, Status: http.StatusUnsupportedMediaType, Reason: "file name not allowed"}
+	}
+	if matchName(rule.Deny, p) {
+		return &ruleError{Path: p, Rule: rule.Path, Status: http.StatusUnsupportedMediaType, Reason: "file name denied"}
+	}
+	return nil
+}
+
+// checkHead checks the type detected from the first bytes of a file
+// written to server path p
+func (u *UploadRules) checkHead(p string, head []byte) error {
+	rule := u.ruleFor(p)
+	if rule == nil || (len(rule.AllowMIME) == 0 && len(rule.DenyMIME) == 0) {
+		return nil
+	}
+	mimeType := detectType(head)
+	if len(rule.AllowMIME) > 0 && !matchMIME(rule.AllowMIME, mimeType) {
+		return &ruleError{Path: p, Rule: rule.Path, Status: http.StatusUnsupportedMediaType, Reason: "content type " + mimeType + " not allowed"}
+	}
+	if matchMIME(rule.DenyMIME, mimeType) {
+		return &ruleError{Path: p, Rule: rule.Path, Status: http.StatusUnsupportedMediaType, Reason: "content type " + mimeType + " denied"}
+	}
+	return nil
+}
+
+// inspectsContent reports whether writes to server path p need checkHead
+func (u *UploadRules) inspectsContent(p string) bool {
+	rule := u.ruleFor(p)
+	return rule != nil && (len(rule.AllowMIME) > 0 || len(rule.DenyMIME) > 0)
+}
+
+// limit returns the size limit for server path p, 0 if there is none
+func (u *UploadRules) limit(p string) int64 {
+	if rule := u.ruleFor(p); rule != nil {
+		return rule.MaxSize
+	}
+	return 0
+}
+
+// matchName reports whether server path p matches any of patterns
+func matchName(patterns []string, p string) bool {
+	p = strings.ToLower(p)
+	name := path.Base(p)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern, "*?["):
+			if strings.HasSuffix(name, pattern) {
+				return true
+			}
+		case strings.Contains(pattern, "/"):
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		default:
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchMIME reports whether mimeType matches any of patterns ("type/*" or exact)
+func matchMIME(patterns []string, mimeType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mimeType || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mimeType, pattern[:len(pattern)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// executableMagic adds the executable formats http.DetectContentType does
+// not know
+var executableMagic = []struct {
+	magic    []byte
+	mimeType string
+}{
+	{[]byte("\x7fELF"), "application/x-executable"},
+	{[]byte("MZ"), "application/x-msdownload"},
+	{[]byte{0xcf, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte{0xce, 0xfa, 0xed, 0xfe}, "application/x-mach-binary"},
+	{[]byte("#!"), "text/x-script"},
+}
+
+// detectType returns the media type of content starting with head, without parameters
+func detectType(head []byte) string {
+	for _, e := range executableMagic {
+		if bytes.HasPrefix(head, e.magic) {
+			return e.mimeType
+		}
+	}
+	mimeType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return mimeType
+}
+
+// checkWrite checks the name and size (-1 if unknown) of a file written to fullPath
+func (h *FileHandler) checkWrite(fullPath string, size int64) error {
+	return h.rules.checkFile(h.virtualPath(fullPath), size)
+}
+
+// checkContent checks the first bytes of a file written to fullPath
+func (h *FileHandler) checkContent(fullPath string, head []byte) error {
+	return h.rules.checkHead(h.virtualPath(fullPath), head)
+}
+
+// checkBody checks the content of a request body written to fullPath and
+// returns a reader that still yields the whole body
+func (h *FileHandler) checkBody(fullPath string, body io.Reader) (io.Reader, error) {
+	if !h.rules.inspectsContent(h.virtualPath(fullPath)) {
+		return body, nil
+	}
+	reader := bufio.NewReaderSize(body, compress.HeadSize)
+	head, _ := reader.Peek(compress.HeadSize)
+	return reader, h.checkContent(fullPath, head)
+}
+
+// checkFileHead checks the content of an existing file about to be written to fullPath
+func (h *FileHandler) checkFileHead(src, fullPath string) error {
+	if !h.rules.inspectsContent(h.virtualPath(fullPath)) {
+		return nil
+	}
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	head := make([]byte, compress.HeadSize)
+	n, _ := io.ReadFull(file, head)
+	return h.checkContent(fullPath, head[:n])
+}
+
+// checkTree checks every regular file below src as if it were written to
+// the same relative path below dst, for copies and moves
+func (h *FileHandler) checkTree(src, dst string) error {
+	if h.rules == nil {
+		return nil
+	}
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := h.checkWrite(target, info.Size()); err != nil {
+			return err
+		}
+		return h.checkFileHead(p, target)
+	})
+}
+
+// entryCheck returns the check for files extracted from an archive, nil
+// without rules
+func (h *FileHandler) entryCheck() compress.EntryCheck {
+	if h.rules == nil {
+		return nil
+	}
+	return func(path string, size int64, head []byte) error {
+		if err := h.checkWrite(path, size); err != nil {
+			return err
+		}
+		return h.checkContent(path, head)
+	}
+}
+
+// writeRuleError writes err as a structured 413/415 response if it is a
+// broken upload rule and reports whether it did so
+func writeRuleError(w http.ResponseWriter, err error) bool {
+	var rule *ruleError
+	if !errors.As(err, &rule) {
+		return false
+	}
+	fmt.Printf("[DEBUG] Upload rejected: %v\n", rule)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rule.Status)
+	json.NewEncoder(w).Encode(RuleInfo{Error: "rule", Reason: rule.Reason, Path: rule.Path, Rule: rule.Rule})
+	return true
+}
+
+// limitBody caps a body of unknown size at the limit for fullPath. Reading
+// past it fails with a rule error, so a client that did not declare a size
+// cannot exceed the limit either.
+func (h *FileHandler) limitBody(fullPath string, body io.Reader) io.Reader {
+	limit := h.rules.limit(h.virtualPath(fullPath))
+	if limit <= 0 {
+		return body
+	}
+	return &limitedBody{reader: body, remaining: limit + 1, fail: func() error { return h.checkWrite(fullPath, limit+1) }}
+}
+
+// limitedBody fails once more than the allowed bytes have been read
+type limitedBody struct {
+	reader    io.Reader
+	remaining int64
+	fail      func() error
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, l.fail()
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining <= 0 {
+		return n, l.fail()
+	}
+	return n, err
+}
+
+// limitWriter caps output of unknown size written to fullPath at the limit
+// for fullPath, for files the server builds itself. Writing past it fails
+// with a rule error before the extra bytes reach w.
+func (h *FileHandler) limitWriter(fullPath string, w io.Writer) io.Writer {
+	limit := h.rules.limit(h.virtualPath(fullPath))
+	if limit <= 0 {
+		return w
+	}
+	return &limitedWriter{writer: w, remaining: limit, fail: func() error { return h.checkWrite(fullPath, limit+1) }}
+}
+
+// limitedWriter fails once more than the allowed bytes would be written
+type limitedWriter struct {
+	writer    io.Writer
+	remaining int64
+	fail      func() error
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		n, _ := l.writer.Write(p[:l.remaining])
+		l.remaining -= int64(n)
+		return n, l.fail()
+	}
+	n, err := l.writer.Write(p)
+	l.remaining -= int64(n)
+	return n, err
+}
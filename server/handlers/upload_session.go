@@ -0,0 +1,786 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CertStone/simpleKcpFileManager/common"
+	"github.com/CertStone/simpleKcpFileManager/server/compress"
+)
+
+const (
+	defaultSessionChunkSize = 4 * 1024 * 1024
+	minSessionChunkSize     = 256 * 1024
+	maxSessionChunkSize     = 64 * 1024 * 1024
+
+	// sessionStateSuffix marks the hidden files older versions persisted
+	// sessions in next to their target: "dir/.name.upload"
+	sessionStateSuffix = ".upload"
+
+	// sessionActiveWindow is how long after its last chunk a session counts
+	// as in use, so that another init does not replace it unasked
+	sessionActiveWindow = 30 * time.Second
+)
+
+// ByteRange is a half-open range [Start, End) of file offsets
+type ByteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// UploadStatus describes an upload session and the chunks it still needs
+type UploadStatus struct {
+	ID        string      `json:"id"`
+	Path      string      `json:"path"` // Final server path (differs from the request when renamed)
+	Size      int64       `json:"size"`
+	ChunkSize int64       `json:"chunkSize"`
+	Chunks    int         `json:"chunks"`
+	Received  int         `json:"received"`
+	Missing   []ByteRange `json:"missing"`
+	Resumed   bool        `json:"resumed,omitempty"` // Init found an existing session
+	Skipped   bool        `json:"skipped,omitempty"` // Target exists and conflict=skip
+	Error     string      `json:"error,omitempty"`   // Why commit failed; Missing lists chunks to resend
+}
+
+// uploadSession is the persisted state of a resumable upload. It is stored
+// as JSON in the session directory, outside the served root, under a name
+// derived from the requested path so that it survives server restarts and
+// can be found again by path. Only server paths are stored; the filesystem
+// paths are derived from them again whenever a session is loaded. Data goes
+// to a part file that only replaces the target at commit.
+type uploadSession struct {
+	ID          string        `json:"id"`
+	Path        string        `json:"path"`   // Requested server path
+	Target      string        `json:"target"` // Server path replaced at commit (differs from Path when renamed)
+	Size        int64         `json:"size"`
+	Hash        string        `json:"hash"` // Client supplied SHA256, used to match resumes
+	ChunkSize   int64         `json:"chunkSize"`
+	Result      string        `json:"result"`      // Conflict outcome decided at init
+	Bitmap      []byte        `json:"bitmap"`      // One bit per received chunk
+	Digests     []string      `json:"digests"`     // SHA256 per chunk as sent by the client, "" if none
+	Source      sourceMeta    `json:"source"`      // Mode and mtime applied at commit
+	Sparse      bool          `json:"sparse"`      // Source has holes; zero blocks are not stored
+	AutoExtract bool          `json:"autoExtract"` // Extract the archive at commit instead of storing it
+	Pre         preconditions `json:"pre"`         // If-Match/If-None-Match checked at init and commit
+	Updated     int64         `json:"updated"`
+
+	TargetPath string         `json:"-"` // Filesystem path of Target
+	PartPath   string         `json:"-"` // Hidden file the chunks are written to
+	Extract    *extractTarget `json:"-"` // Where to extract, given again with every init
+
+	mu        sync.Mutex
+	statePath string       // "" if sessions are not persisted
+	writing   sync.RWMutex // Shared by chunk writes, exclusive for commit, abort and restart
+	inflight  int          // Chunks being written; s.mu
+	ended     bool         // Committed, aborted or replaced; s.mu
+}
+
+// chunks returns the number of chunks in the session
+func (s *uploadSession) chunks() int {
+	if s.Size == 0 {
+		return 0
+	}
+	return int((s.Size + s.ChunkSize - 1) / s.ChunkSize)
+}
+
+// chunkRange returns the byte range of chunk index
+func (s *uploadSession) chunkRange(index int) ByteRange {
+	start := int64(index) * s.ChunkSize
+	end := start + s.ChunkSize
+	if end > s.Size {
+		end = s.Size
+	}
+	return ByteRange{Start: start, End: end}
+}
+
+func (s *uploadSession) has(index int) bool {
+	return s.Bitmap[index/8]&(1<<(index%8)) != 0
+}
+
+func (s *uploadSession) set(index int) {
+	s.Bitmap[index/8] |= 1 << (index % 8)
+}
+
+func (s *uploadSession) clear(index int) {
+	s.Bitmap[index/8] &^= 1 << (index % 8)
+}
+
+// markHoles records the chunks without data in m as received, with the
+// digest of zeros: the freshly preallocated part file is a hole there
+func (s *uploadSession) markHoles(m *common.SparseMap) {
+	zeroDigests := make(map[int64]string)
+	next := 0 // First data extent that may still overlap a chunk
+	for i := 0; i < s.chunks(); i++ {
+		r := s.chunkRange(i)
+		for next < len(m.Data) && m.Data[next].End <= r.Start {
+			next++
+		}
+		if next < len(m.Data) && m.Data[next].Start < r.End {
+			continue
+		}
+
+		n := r.End - r.Start
+		if _, ok := zeroDigests[n]; !ok {
+			zeroDigests[n] = zeroDigest(n)
+		}
+		s.set(i)
+		s.Digests[i] = zeroDigests[n]
+	}
+}
+
+// verify hashes the part file and returns the chunks that must be sent
+// again: those whose data differs from the digest sent with them and, if the
+// whole file still does not match Hash, those that had no digest (or every
+// chunk when all digests matched, since the bad data cannot be located).
+// s.mu must be held.
+func (s *uploadSession) verify() ([]int, error) {
+	sums, whole, err := hashChunks(s.PartPath, s.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var bad, unchecked []int
+	for i := 0; i < s.chunks(); i++ {
+		switch {
+		case s.Digests[i] == "":
+			unchecked = append(unchecked, i)
+		case i >= len(sums) || sums[i] != s.Digests[i]:
+			bad = append(bad, i)
+		}
+	}
+	if len(bad) > 0 || s.Hash == "" || s.Hash == whole {
+		return bad, nil
+	}
+	if len(unchecked) > 0 {
+		return unchecked, nil
+	}
+	all := make([]int, s.chunks())
+	for i := range all {
+		all[i] = i
+	}
+	return all, nil
+}
+
+// status builds the client view of the session; s.mu must be held
+func (s *uploadSession) status(h *FileHandler) UploadStatus {
+	st := UploadStatus{
+		ID:        s.ID,
+		Path:      h.virtualPath(s.TargetPath),
+		Size:      s.Size,
+		ChunkSize: s.ChunkSize,
+		Chunks:    s.chunks(),
+		Missing:   []ByteRange{},
+	}
+	for i := 0; i < st.Chunks; i++ {
+		if s.has(i) {
+			st.Received++
+			continue
+		}
+		r := s.chunkRange(i)
+		// Merge consecutive missing chunks into one range
+		if n := len(st.Missing); n > 0 && st.Missing[n-1].End == r.Start {
+			st.Missing[n-1].End = r.End
+		} else {
+			st.Missing = append(st.Missing, r)
+		}
+	}
+	return st
+}
+
+// beginChunk registers a chunk write, which commit, abort and restart wait
+// for, and reports false if the session has ended meanwhile. A true result
+// must be followed by endChunk.
+func (s *uploadSession) beginChunk() bool {
+	s.writing.RLock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		s.writing.RUnlock()
+		return false
+	}
+	s.inflight++
+	return true
+}
+
+// endChunk finishes a chunk write registered with beginChunk
+func (s *uploadSession) endChunk() {
+	s.mu.Lock()
+	s.inflight--
+	s.mu.Unlock()
+	s.writing.RUnlock()
+}
+
+// active reports whether a client is still sending to the session: a chunk
+// is being written or arrived within sessionActiveWindow; s.mu must be held
+func (s *uploadSession) active() bool {
+	return !s.ended && (s.inflight > 0 || time.Since(time.Unix(s.Updated, 0)) < sessionActiveWindow)
+}
+
+// save persists the session atomically; s.mu must be held
+func (s *uploadSession) save() error {
+	s.Updated = time.Now().Unix()
+	if s.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.statePath)
+}
+
+// removeState deletes the persisted state of the session
+func (s *uploadSession) removeState() {
+	if s.statePath != "" {
+		os.Remove(s.statePath)
+	}
+}
+
+// SetSessionDir persists upload sessions in dir, which must be outside the
+// served root, so they can be resumed after a restart. Without it sessions
+// are kept in memory only.
+func (h *UploadHandler) SetSessionDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	h.sessionDir = dir
+	return nil
+}
+
+// sessionStatePath returns the state file of the session for the server
+// path p, "" if sessions are not persisted
+func (h *UploadHandler) sessionStatePath(p string) string {
+	if h.sessionDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(p))
+	return filepath.Join(h.sessionDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+// loadSession reads the persisted session for the server path p, returning
+// nil if there is none or it does not belong to p
+func (h *UploadHandler) loadSession(p string) *uploadSession {
+	statePath := h.sessionStatePath(p)
+	if statePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	var s uploadSession
+	if err := json.Unmarshal(data, &s); err != nil || s.ChunkSize <= 0 || len(s.Bitmap) != (s.chunks()+7)/8 {
+		return nil
+	}
+	// A renamed target stays next to the requested path
+	target, safe := h.fileHandler.isPathSafe(s.Target)
+	if !safe || s.Path != p || path.Dir(path.Clean("/"+s.Target)) != path.Dir(p) || isReservedName(target) {
+		return nil
+	}
+	// The id names the part file
+	if id, err := hex.DecodeString(s.ID); err != nil || len(id) != 16 {
+		return nil
+	}
+	if len(s.Digests) != s.chunks() {
+		s.Digests = make([]string, s.chunks())
+	}
+	s.TargetPath, s.PartPath = target, partPath(target, s.ID)
+	s.statePath = statePath
+	return &s
+}
+
+// newSessionID returns a random session identifier
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// session returns the active session for the id parameter, writing 404 if unknown.
+// Sessions are only known in memory after init; after a server restart
+// clients call upload-init again, which reloads the persisted state.
+func (h *UploadHandler) session(w http.ResponseWriter, r *http.Request) *uploadSession {
+	id := r.URL.Query().Get(common.QueryID)
+	if s, ok := h.sessions.Load(id); ok {
+		return s.(*uploadSession)
+	}
+	http.Error(w, "Unknown upload session", http.StatusNotFound)
+	return nil
+}
+
+// HandleUploadInit handles POST /?action=upload-init&path=X&size=N[&hash=H][&chunkSize=N][&restart=1].
+// If an unfinished session for the same path, size and hash exists it is
+// resumed and only its missing ranges are reported. A different session for
+// the path that is still in use is only replaced with restart=1, otherwise
+// 409 is returned. A JSON common.SparseMap
+// body marks the chunks that lie entirely in holes of the source as received,
+// so they are never sent and stay holes in the part file.
+func (h *UploadHandler) HandleUploadInit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filePath := query.Get(common.QueryPath)
+	size, err := strconv.ParseInt(query.Get(common.QuerySize), 10, 64)
+	if filePath == "" || err != nil || size < 0 {
+		http.Error(w, "Missing path or size parameter", http.StatusBadRequest)
+		return
+	}
+	hash := strings.ToLower(query.Get(common.QueryHash))
+
+	chunkSize := int64(defaultSessionChunkSize)
+	if s := query.Get(common.QueryChunkSize); s != "" {
+		if chunkSize, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, "Invalid chunkSize parameter", http.StatusBadRequest)
+			return
+		}
+		chunkSize = min(max(chunkSize, minSessionChunkSize), maxSessionChunkSize)
+	}
+
+	policy, err := conflictPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sparse *common.SparseMap
+	if r.ContentLength != 0 && r.Header.Get("Content-Type") == "application/json" {
+		sparse = &common.SparseMap{}
+		if err := json.NewDecoder(r.Body).Decode(sparse); err != nil || sparse.Size != size {
+			http.Error(w, "Invalid sparse map", http.StatusBadRequest)
+			return
+		}
+	}
+
+	cleanPath, safe := h.fileHandler.isPathSafe(filePath)
+	if !safe {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	if isReservedName(cleanPath) {
+		http.Error(w, "Reserved file name", http.StatusBadRequest)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cleanPath), 0755); err != nil {
+		http.Error(w, "Failed to create directory: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Serialize inits for the same path so two clients cannot both create a session
+	lock := fileLock(cleanPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	virtualPath := h.fileHandler.virtualPath(cleanPath)
+	meta := sourceMetaFromRequest(r)
+	extract, err := h.extractTarget(r, cleanPath, policy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Preconditions protect the stored file, so they do not apply to
+	// auto-extract; upload rules are then checked on the extracted entries
+	pre := preconditionsFromRequest(r)
+	if extract != nil {
+		pre = preconditions{}
+	} else if err := h.fileHandler.checkWrite(cleanPath, size); err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if !pre.check(w, cleanPath) {
+		return
+	}
+
+	// Resume an existing session if it describes the same file
+	if s := h.loadSession(virtualPath); s != nil && s.Size == size && s.Hash == hash && s.AutoExtract == (extract != nil) {
+		if _, err := os.Stat(s.PartPath); err == nil {
+			if active, ok := h.sessions.Load(s.ID); ok {
+				s = active.(*uploadSession)
+			} else {
+				h.sessions.Store(s.ID, s)
+			}
+			s.mu.Lock()
+			s.Source, s.Extract = meta, extract
+			if s.Result != common.ConflictResultRenamed {
+				s.Pre = pre
+			}
+			s.save()
+			st := s.status(h.fileHandler)
+			s.mu.Unlock()
+			st.Resumed = true
+			h.fileHandler.setConflictHeaders(w, s.Result, s.TargetPath)
+			writeUploadStatus(w, st)
+			return
+		}
+	}
+
+	// Starting over: drop any stale session for this path. One still in use
+	// is only replaced on request, once its chunks in flight are written.
+	if old := h.loadSession(virtualPath); old != nil {
+		if v, ok := h.sessions.Load(old.ID); ok {
+			running := v.(*uploadSession)
+			running.mu.Lock()
+			busy := running.active()
+			running.mu.Unlock()
+			if busy && query.Get(common.QueryRestart) != "1" {
+				http.Error(w, "Another upload to this path is in progress", http.StatusConflict)
+				return
+			}
+			running.writing.Lock()
+			running.mu.Lock()
+			running.ended = true
+			running.mu.Unlock()
+			running.writing.Unlock()
+		}
+		h.sessions.Delete(old.ID)
+		os.Remove(old.PartPath)
+	}
+
+	// With auto-extract the policy applies to the extracted entries instead
+	target, result := cleanPath, ""
+	if extract == nil {
+		target, result, err = common.ResolveConflict(cleanPath, false, meta.modTime(), policy)
+	}
+	if err != nil {
+		if !h.fileHandler.writeConflictError(w, err, policy, nil) {
+			http.Error(w, "Failed to check destination: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if target == "" {
+		if statePath := h.sessionStatePath(virtualPath); statePath != "" {
+			os.Remove(statePath)
+		}
+		h.fileHandler.setConflictHeaders(w, result, "")
+		writeUploadStatus(w, UploadStatus{Path: h.fileHandler.virtualPath(cleanPath), Size: size, Missing: []ByteRange{}, Skipped: true})
+		return
+	}
+
+	if result == common.ConflictResultRenamed {
+		pre = preconditions{} // A fresh name has nothing to protect
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s := &uploadSession{
+		ID:          id,
+		Path:        virtualPath,
+		Target:      h.fileHandler.virtualPath(target),
+		Size:        size,
+		Hash:        hash,
+		ChunkSize:   chunkSize,
+		Result:      result,
+		Source:      meta,
+		AutoExtract: extract != nil,
+		Pre:         pre,
+		TargetPath:  target,
+		PartPath:    partPath(target, id),
+		Extract:     extract,
+		statePath:   h.sessionStatePath(virtualPath),
+	}
+	s.Bitmap = make([]byte, (s.chunks()+7)/8)
+	s.Digests = make([]string, s.chunks())
+	if sparse != nil {
+		s.Sparse = true
+		s.markHoles(sparse)
+	}
+
+	// Preallocate the part file; chunks are written in place
+	err = createPart(s.PartPath, size)
+	if err == nil {
+		err = s.save()
+	}
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.sessions.Store(id, s)
+	h.fileHandler.setConflictHeaders(w, result, target)
+	writeUploadStatus(w, s.status(h.fileHandler))
+}
+
+// HandleUploadChunk handles PUT /?action=upload-chunk&id=X&index=N.
+// The body must be exactly the chunk's bytes; the chunk is recorded in the
+// persisted bitmap once written and synced. If an X-Chunk-Digest header is
+// sent the data is checked against it (422 on mismatch) and the digest is
+// kept to locate corrupted chunks at commit.
+func (h *UploadHandler) HandleUploadChunk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !decodeRequestBody(w, r) {
+		return
+	}
+
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+
+	index, err := strconv.Atoi(r.URL.Query().Get(common.QueryIndex))
+	if err != nil || index < 0 || index >= s.chunks() {
+		http.Error(w, "Invalid chunk index", http.StatusBadRequest)
+		return
+	}
+	chunk := s.chunkRange(index)
+
+	// Commit, abort and restart wait for the write; a chunk arriving after
+	// the session ended must not touch its part file
+	if !s.beginChunk() {
+		http.Error(w, "Unknown upload session", http.StatusNotFound)
+		return
+	}
+	defer s.endChunk()
+
+	length := chunk.End - chunk.Start
+	var reader io.Reader = io.LimitReader(r.Body, length)
+	if index == 0 && s.Extract == nil {
+		// Reject content the upload rules do not allow before storing it
+		if reader, err = h.fileHandler.checkBody(s.TargetPath, reader); err != nil {
+			writeRuleError(w, err)
+			return
+		}
+	}
+	body := newDigestBody(r, reader)
+	written, err := writePart(s.PartPath, chunk.Start, body)
+	if err != nil {
+		http.Error(w, "Failed to write chunk: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A short, oversized or corrupted body is not recorded, so the chunk stays missing
+	if extra, _ := r.Body.Read(make([]byte, 1)); written != length || extra > 0 {
+		http.Error(w, fmt.Sprintf("Chunk %d must be %d bytes", index, length), http.StatusBadRequest)
+		return
+	}
+	if err := body.verify(); err != nil {
+		writeDigestError(w, fmt.Sprintf("Chunk %d", index))
+		return
+	}
+	// Chunks of a sparse source may still start or end in a hole
+	if s.Sparse {
+		if err := punchZeros(s.PartPath, chunk.Start, chunk.End); err != nil {
+			fmt.Printf("[DEBUG] Failed to punch holes in %s: %v\n", s.PartPath, err)
+		}
+	}
+
+	s.mu.Lock()
+	s.set(index)
+	s.Digests[index] = body.expected
+	err = s.save()
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to record chunk: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "OK\nChunk %d: %d bytes", index, written)
+}
+
+// HandleUploadStatus handles GET /?action=upload-status&id=X
+func (h *UploadHandler) HandleUploadStatus(w http.ResponseWriter, r *http.Request) {
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	st := s.status(h.fileHandler)
+	s.mu.Unlock()
+	writeUploadStatus(w, st)
+}
+
+// HandleUploadCommit handles POST /?action=upload-commit&id=X, renaming the
+// part file over the target once every chunk has arrived and the data matches
+// the chunk digests and the whole-file hash. If chunks are missing or corrupt
+// they are marked missing, the session is kept and 400 is returned with the
+// status as JSON so the client can send them and commit again.
+func (h *UploadHandler) HandleUploadCommit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+
+	// Lock the target before waiting for chunks still being written, in the
+	// same order as a restart of the session by upload-init
+	lock := fileLock(s.TargetPath)
+	lock.Lock()
+	defer lock.Unlock()
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		http.Error(w, "Unknown upload session", http.StatusNotFound)
+		return
+	}
+
+	st := s.status(h.fileHandler)
+	if st.Received != st.Chunks {
+		writeCommitStatus(w, st)
+		return
+	}
+
+	// Check the written data before it replaces the target
+	bad, err := s.verify()
+	if err != nil {
+		http.Error(w, "Failed to verify file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(bad) > 0 {
+		for _, index := range bad {
+			s.clear(index)
+			s.Digests[index] = ""
+		}
+		if err := s.save(); err != nil {
+			http.Error(w, "Failed to record chunks: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		st = s.status(h.fileHandler)
+		st.Error = "checksum mismatch"
+		writeCommitStatus(w, st)
+		return
+	}
+
+	if s.Extract != nil {
+		h.commitExtract(w, s)
+		return
+	}
+
+	// The rules may have changed since init, and the first chunk may have
+	// been a hole of a sparse source
+	err = h.fileHandler.checkWrite(s.TargetPath, s.Size)
+	if err == nil {
+		err = h.fileHandler.checkFileHead(s.PartPath, s.TargetPath)
+	}
+	if err != nil {
+		if writeRuleError(w, err) {
+			h.dropSession(s)
+			return
+		}
+		http.Error(w, "Failed to check file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.commitPartLocked(s.PartPath, s.TargetPath, s.Source, s.Pre); err != nil {
+		if errors.Is(err, errPreconditionFailed) {
+			// The upload was based on a version that no longer exists
+			h.dropSession(s)
+			writePreconditionFailed(w, currentETag(s.TargetPath))
+			return
+		}
+		http.Error(w, "Failed to commit file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.ended = true
+	h.sessions.Delete(s.ID)
+	s.removeState()
+
+	h.fileHandler.setConflictHeaders(w, s.Result, s.TargetPath)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "OK\nCommitted: %d bytes", s.Size)
+}
+
+// commitExtract finishes an auto-extract session by extracting the part
+// file. The session ends either way, since its data has been consumed or
+// cannot be extracted; s.mu must be held.
+func (h *UploadHandler) commitExtract(w http.ResponseWriter, s *uploadSession) {
+	stats, err := compress.ExtractArchive(s.PartPath, s.Extract.Dest, s.Extract.Policy, h.fileHandler.entryCheck())
+	h.fileHandler.forgetChecksums(s.Extract.Dest)
+	s.ended = true
+	h.sessions.Delete(s.ID)
+	s.removeState()
+	removeTempArchive(s.PartPath)
+	if err != nil {
+		fmt.Printf("[ERROR] Failed to extract: %v\n", err)
+		if h.fileHandler.writeConflictError(w, err, s.Extract.Policy, nil) || writeRuleError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to extract archive: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Printf("[DEBUG] Extract successful: %d files, %d directories\n", stats.Files, stats.Dirs)
+
+	setExtractHeaders(w, stats)
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "OK\nExtracted: %d files, %d directories", stats.Files, stats.Dirs)
+}
+
+// HandleUploadAbort handles DELETE /?action=upload-abort&id=X, removing the
+// session and its part file; an existing target is left untouched
+func (h *UploadHandler) HandleUploadAbort(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s := h.session(w, r)
+	if s == nil {
+		return
+	}
+
+	// Wait for chunks still being written
+	s.writing.Lock()
+	defer s.writing.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.dropSession(s)
+	w.Write([]byte("OK"))
+}
+
+// dropSession ends a session and removes its state and part file; s.mu
+// must be held
+func (h *UploadHandler) dropSession(s *uploadSession) {
+	s.ended = true
+	h.sessions.Delete(s.ID)
+	s.removeState()
+	os.Remove(s.PartPath)
+}
+
+// writeCommitStatus answers a commit that cannot complete yet
+func writeCommitStatus(w http.ResponseWriter, st UploadStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(st)
+}
+
+// writeUploadStatus writes st as JSON
+func writeUploadStatus(w http.ResponseWriter, st UploadStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
@@ -4,13 +4,14 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"flag"
-	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 
 	"github.com/CertStone/simpleKcpFileManager/common"
 	"github.com/CertStone/simpleKcpFileManager/server/handlers"
@@ -23,6 +24,9 @@ func main() {
 	port := flag.String("p", "8080", "Port to listen")
 	dir := flag.String("d", ".", "Directory to serve")
 	key := flag.String("key", "", "Encryption key")
+	rulesFile := flag.String("rules", "", "JSON file with upload size and file type rules")
+	hashCacheFile := flag.String("hashcache", "", "Checksum cache file outside the served directory (default: in the user cache directory, - to keep it in memory)")
+	sessionDir := flag.String("sessions", "", "Directory for resumable upload sessions outside the served directory (default: in the user cache directory, - to keep them in memory)")
 	flag.Parse()
 
 	// Require encryption key
@@ -48,6 +52,68 @@ func main() {
 	compressHandler := handlers.NewCompressHandler(*dir)
 	editHandler := handlers.NewEditHandler(*dir)
 
+	// Upload rules apply to everything that writes files into the root
+	if *rulesFile != "" {
+		rules, err := handlers.LoadUploadRules(*rulesFile)
+		if err != nil {
+			log.Fatal("Failed to load upload rules:", err)
+		}
+		fileHandler.SetUploadRules(rules)
+		uploadHandler.SetUploadRules(rules)
+		compressHandler.SetUploadRules(rules)
+		editHandler.SetUploadRules(rules)
+		log.Printf("Loaded %d upload rules from %s", len(rules.Rules), *rulesFile)
+	}
+
+	// Checksums are cached across requests and restarts; every handler that
+	// writes files drops the entries it makes stale
+	switch *hashCacheFile {
+	case "":
+		*hashCacheFile = defaultHashCacheFile(*dir)
+	case "-":
+		*hashCacheFile = ""
+	default:
+		// Clients must not be able to read or rewrite cached checksums
+		if inServedDir(*dir, *hashCacheFile) {
+			log.Fatal("The checksum cache file must be outside the served directory")
+		}
+	}
+	if *hashCacheFile != "" {
+		log.Printf("Caching checksums in %s", *hashCacheFile)
+	}
+	hashCache := handlers.NewChecksumCache(*hashCacheFile)
+	fileHandler.SetChecksumCache(hashCache)
+	uploadHandler.SetChecksumCache(hashCache)
+	compressHandler.SetChecksumCache(hashCache)
+	editHandler.SetChecksumCache(hashCache)
+
+	// Upload sessions are persisted where clients cannot forge them
+	switch *sessionDir {
+	case "":
+		*sessionDir = userCachePath(*dir, "uploads")
+	case "-":
+		*sessionDir = ""
+	default:
+		if inServedDir(*dir, *sessionDir) {
+			log.Fatal("The upload session directory must be outside the served directory")
+		}
+	}
+	if *sessionDir != "" {
+		if err := uploadHandler.SetSessionDir(*sessionDir); err != nil {
+			log.Fatal("Failed to create upload session directory:", err)
+		}
+		log.Printf("Keeping upload sessions in %s", *sessionDir)
+	}
+
+	// Write out cached checksums before exiting
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		hashCache.Close()
+		os.Exit(0)
+	}()
+
 	// Create main HTTP handler
 	mainHandler := createMainHandler(*dir, fileHandler, uploadHandler, compressHandler, editHandler)
 
@@ -74,6 +140,54 @@ func main() {
 	}
 }
 
+// defaultHashCacheFile returns the checksum cache file for a served
+// directory in the user cache directory, or "" to keep the cache in memory
+// where there is none
+func defaultHashCacheFile(dir string) string {
+	p := userCachePath(dir, "checksums")
+	if p == "" {
+		return ""
+	}
+	return p + ".json"
+}
+
+// userCachePath returns a path in the user cache directory named after kind
+// and the served directory dir, or "" to keep the data in memory where there
+// is none
+func userCachePath(dir, kind string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		log.Printf("No user cache directory, keeping %s in memory: %v", kind, err)
+		return ""
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(absDir))
+	return filepath.Join(cacheDir, "simpleKcpFileManager", kind+"-"+hex.EncodeToString(sum[:8]))
+}
+
+// inServedDir reports whether file lies inside the served directory dir
+func inServedDir(dir, file string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return true
+	}
+	if d, err := filepath.EvalSymlinks(absDir); err == nil {
+		absDir = d
+	}
+	if f, err := filepath.EvalSymlinks(filepath.Dir(absFile)); err == nil {
+		absFile = filepath.Join(f, filepath.Base(absFile))
+	}
+	rel, err := filepath.Rel(absDir, absFile)
+	return err != nil || rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
 // createMainHandler creates the main HTTP handler with all routes
 func createMainHandler(rootDir string, fileHandler *handlers.FileHandler, uploadHandler *handlers.UploadHandler, compressHandler *handlers.CompressHandler, editHandler *handlers.EditHandler) http.Handler {
 	mux := http.NewServeMux()
@@ -84,9 +198,12 @@ func createMainHandler(rootDir string, fileHandler *handlers.FileHandler, upload
 
 		action := r.URL.Query().Get("action")
 
+		// Tell clients which encodings uploads may be compressed with
+		w.Header().Set("Accept-Encoding", common.AcceptEncodings)
+
 		switch action {
 		case "checksum":
-			handleChecksum(rootDir, w, r)
+			fileHandler.HandleChecksum(w, r)
 		case "list":
 			fileHandler.HandleList(w, r)
 		case "delete":
@@ -99,12 +216,31 @@ func createMainHandler(rootDir string, fileHandler *handlers.FileHandler, upload
 			fileHandler.HandleCopy(w, r)
 		case "stat":
 			fileHandler.HandleStat(w, r)
+		case "du":
+			fileHandler.HandleDu(w, r)
 		case "chmod":
 			fileHandler.HandleChmod(w, r)
+		case "chown":
+			fileHandler.HandleChown(w, r)
+		case "symlink":
+			fileHandler.HandleSymlink(w, r)
+		case "hardlink":
+			fileHandler.HandleHardlink(w, r)
 		case "compress":
 			compressHandler.HandleCompress(w, r)
 		case "extract":
 			compressHandler.HandleExtract(w, r)
+		case "archive":
+			compressHandler.HandleArchive(w, r)
+		case "tail":
+			fileHandler.HandleTail(w, r)
+		case "batch":
+			// Batches of small text files compress well
+			ew := handlers.NewEncodingWriter(w, r)
+			fileHandler.HandleBatch(ew, r)
+			if err := ew.Close(); err != nil {
+				log.Printf("Compressed batch download failed: %v", err)
+			}
 		case "edit":
 			if r.Method == http.MethodGet {
 				editHandler.HandleGetFile(w, r)
@@ -115,10 +251,41 @@ func createMainHandler(rootDir string, fileHandler *handlers.FileHandler, upload
 			}
 		case "upload":
 			uploadHandler.HandleUpload(w, r)
+		case "upload-init":
+			uploadHandler.HandleUploadInit(w, r)
+		case "upload-chunk":
+			uploadHandler.HandleUploadChunk(w, r)
+		case "upload-status":
+			uploadHandler.HandleUploadStatus(w, r)
+		case "upload-commit":
+			uploadHandler.HandleUploadCommit(w, r)
+		case "upload-abort":
+			uploadHandler.HandleUploadAbort(w, r)
+		case "signature":
+			fileHandler.HandleSignature(w, r)
+		case "delta-upload":
+			uploadHandler.HandleDeltaUpload(w, r)
+		case "delta-download":
+			fileHandler.HandleDeltaDownload(w, r)
+		case "sparse-map":
+			fileHandler.HandleSparseMap(w, r)
+		case "range-hash":
+			fileHandler.HandleRangeHash(w, r)
 		default:
 			// Default: serve file for download
 			if r.Method == http.MethodGet || r.Method == http.MethodHead {
-				http.FileServer(http.Dir(rootDir)).ServeHTTP(w, r)
+				// Let clients restore the file's mode and modification time
+				if fullPath, safe := isPathSafe(rootDir, r.URL.Path); safe {
+					if info, err := os.Stat(fullPath); err == nil && info.Mode().IsRegular() {
+						handlers.SetFileMetaHeaders(w, info)
+					}
+				}
+				// Compress the file or range if the client accepts it
+				ew := handlers.NewEncodingWriter(w, r)
+				http.FileServer(http.Dir(rootDir)).ServeHTTP(ew, r)
+				if err := ew.Close(); err != nil {
+					log.Printf("Compressed download of %s failed: %v", r.URL.Path, err)
+				}
 			} else if r.Method == http.MethodPut {
 				uploadHandler.HandleUpload(w, r)
 			} else {
@@ -130,23 +297,6 @@ func createMainHandler(rootDir string, fileHandler *handlers.FileHandler, upload
 	return mux
 }
 
-// handleChecksum handles file checksum requests
-func handleChecksum(root string, w http.ResponseWriter, r *http.Request) {
-	filePath, safe := isPathSafe(root, r.URL.Path)
-	if !safe {
-		http.Error(w, "Invalid path", http.StatusBadRequest)
-		return
-	}
-
-	sum, err := getFileChecksum(filePath)
-	if err != nil {
-		http.Error(w, "File not found or unreadable", http.StatusNotFound)
-		return
-	}
-
-	w.Write([]byte(sum))
-}
-
 // isPathSafe checks if a path is safe (prevents directory traversal)
 func isPathSafe(root, requestPath string) (string, bool) {
 	// Clean path
@@ -168,19 +318,3 @@ func isPathSafe(root, requestPath string) (string, bool) {
 	}
 	return fullPath, true
 }
-
-// getFileChecksum calculates SHA256 checksum of a file
-func getFileChecksum(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-
-	return hex.EncodeToString(h.Sum(nil)), nil
-}